@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/peonii/inertia/internal/domain"
+)
+
+func (a *api) accountsHandler(w http.ResponseWriter, r *http.Request) {
+	accounts, err := a.accountRepo.FindByUserID(r.Context(), a.session(r))
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find accounts")
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, accounts)
+}
+
+// Starts linking a provider for a guest, the code goes
+// to /oauth2/authorize as link_code
+func (a *api) linkAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := a.userRepo.FindOne(r.Context(), a.session(r))
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find user")
+		return
+	}
+
+	if !user.IsGuest() {
+		a.sendError(w, r, http.StatusForbidden, nil, "only guests can link a login")
+		return
+	}
+
+	code, err := a.oauthCodeRepo.CreateLinkCode(r.Context(), user.ID)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to create link code")
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, domain.AccountLinkResponse{
+		LinkCode:  code,
+		ExpiresIn: int(domain.OAuthCodeExpiryTime.Seconds()),
+	})
+}
+
+func (a *api) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	accounts, err := a.accountRepo.FindByUserID(r.Context(), a.session(r))
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find accounts")
+		return
+	}
+
+	found := false
+	for _, account := range accounts {
+		if account.ID == id {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		a.sendError(w, r, http.StatusNotFound, nil, "failed to find account")
+		return
+	}
+
+	// There'd be no way to sign back in
+	if len(accounts) == 1 {
+		a.sendError(w, r, http.StatusConflict, nil, "can't remove your only login")
+		return
+	}
+
+	if err := a.accountRepo.Delete(r.Context(), id); err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to delete account")
+		return
+	}
+
+	a.sendNoContent(w)
+}
@@ -2,15 +2,16 @@ package api
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/adjust/rmq/v5"
 	"github.com/go-andiamo/chioas"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/peonii/inertia/internal/domain"
+	"github.com/peonii/inertia/internal/provider"
 	"github.com/peonii/inertia/internal/repository"
 	"github.com/pkgz/websocket"
 	"github.com/redis/go-redis/v9"
@@ -19,9 +20,19 @@ import (
 
 // Type-safe way to access environment variables
 type APIConfig struct {
-	DiscordClientID     string
-	DiscordClientSecret string
-	DiscordRedirectURI  string
+	// Who users can sign in with
+	Providers []provider.Config
+
+	// Where uploaded quest evidence is kept
+	BlobDir string
+
+	// EdDSA or RS256, for new signing keys
+	JWTAlgorithm string
+	// How often the signing key is replaced
+	JWTRotation time.Duration
+	// Only for checking tokens signed before there
+	// were signing keys, can go once they've expired
+	JWTSecret string
 }
 
 type api struct {
@@ -39,32 +50,80 @@ type api struct {
 	teamRepo       repository.TeamRepository
 	locationRepo   repository.LocationRepository
 	gameInviteRepo repository.GameInviteRepository
+	gameRoleRepo   repository.GameRoleRepository
 	questRepo      repository.QuestRepository
 	notifRepo      repository.NotificationRepository
 	powerupRepo    repository.PowerupRepository
+	gameResultRepo repository.GameResultRepository
+	gameRulesRepo  repository.GameRulesRepository
+	catchRepo      repository.CatchRepository
+	blobRepo       repository.BlobRepository
+
+	questSubmissionRepo repository.QuestSubmissionRepository
+
+	suspiciousActivityRepo repository.SuspiciousActivityRepository
+
+	teamTransactionRepo repository.TeamTransactionRepository
 
 	oauthCodeRepo    repository.OAuthCodeRepository
+	oauthClientRepo  repository.OAuthClientRepository
 	accessTokenRepo  repository.AccessTokenRepository
 	refreshTokenRepo repository.RefreshTokenRepository
+	sessionRepo      repository.SessionRepository
+	rateLimitRepo    repository.RateLimitRepository
+
+	// By name, which is also the account type
+	providers map[string]provider.Provider
 
 	wsServer *websocket.Server
 	WsHub    *wsHub
+
+	LocationWriter *repository.LocationWriter
+	KeyRing        *repository.SigningKeyRing
 }
 
 func MakeAPI(ctx context.Context, cfg *APIConfig, db *pgxpool.Pool, rdc *redis.Client, logger *zap.Logger, queue rmq.Connection) *api {
 	ur := repository.MakePostgresUserRepository(db)
 	ar := repository.MakePostgresAccountRepository(db)
 	ocr := repository.MakeRedisOAuthCodeRepository(rdc)
-	atr := repository.MakeJWTAccessTokenRepository()
+	oclr := repository.MakePostgresOAuthClientRepository(db)
+	kr := repository.MakeSigningKeyRing(repository.MakePostgresSigningKeyRepository(db), cfg.JWTAlgorithm, cfg.JWTRotation)
+	kr.OnError = func(err error) {
+		logger.Error("failed to refresh signing keys", zap.Error(err))
+	}
+	atr := repository.MakeJWTAccessTokenRepository(kr, rdc, cfg.JWTSecret)
 	rtr := repository.MakeRedisRefreshTokenRepository(rdc)
+	ssr := repository.MakeRedisSessionRepository(rdc)
+	rlr := repository.MakeRedisRateLimitRepository(rdc)
 	gr := repository.MakePostgresGameRepository(db)
 	tr := repository.MakePostgresTeamRepository(db)
-	lr := repository.MakePostgresLocationRepository(db, rdc)
+	lw := repository.MakeLocationWriter(db, repository.DefaultLocationWriterConfig)
+	lw.OnError = func(err error, dropped int) {
+		logger.Error("failed to write location history", zap.Int("dropped", dropped), zap.Error(err))
+	}
+	lr := repository.MakePostgresLocationRepository(db, rdc, lw)
 	gir := repository.MakePostgresGameInviteRepository(db)
+	grolr := repository.MakePostgresGameRoleRepository(db)
 	qr := repository.MakePostgresQuestRepository(db)
 	usr := repository.MakePostgresUserStatsRepository(db)
 	nr := repository.MakePostgresNotificationRepository(db)
 	pr := repository.MakePostgresPowerupRepository(db)
+	grr := repository.MakePostgresGameResultRepository(db)
+	grlr := repository.MakePostgresGameRulesRepository(db)
+	ttr := repository.MakePostgresTeamTransactionRepository(db)
+	cr := repository.MakePostgresCatchRepository(db)
+	br := repository.MakeLocalBlobRepository(cfg.BlobDir)
+	qsr := repository.MakePostgresQuestSubmissionRepository(db)
+	sar := repository.MakePostgresSuspiciousActivityRepository(db, rdc)
+
+	providers := make(map[string]provider.Provider, len(cfg.Providers))
+	for _, pc := range cfg.Providers {
+		p, err := provider.New(pc)
+		if err != nil {
+			panic(fmt.Sprintf("failed to set up provider: %v", err))
+		}
+		providers[p.Name()] = p
+	}
 
 	wsServer := websocket.New()
 
@@ -84,18 +143,35 @@ func MakeAPI(ctx context.Context, cfg *APIConfig, db *pgxpool.Pool, rdc *redis.C
 		accountRepo:      ar,
 		userStatsRepo:    usr,
 		oauthCodeRepo:    ocr,
+		oauthClientRepo:  oclr,
 		accessTokenRepo:  atr,
 		refreshTokenRepo: rtr,
+		sessionRepo:      ssr,
+		rateLimitRepo:    rlr,
+		providers:        providers,
 		gameRepo:         gr,
 		teamRepo:         tr,
 		locationRepo:     lr,
 		gameInviteRepo:   gir,
+		gameRoleRepo:     grolr,
 		questRepo:        qr,
 		notifRepo:        nr,
 		powerupRepo:      pr,
+		gameResultRepo:   grr,
+		gameRulesRepo:    grlr,
+		catchRepo:        cr,
+		blobRepo:         br,
+
+		teamTransactionRepo: ttr,
+		questSubmissionRepo: qsr,
+
+		suspiciousActivityRepo: sar,
 
 		wsServer: wsServer,
-		WsHub:    NewWsHub(logger, db),
+		WsHub:    NewWsHub(logger, db, rdc),
+
+		LocationWriter: lw,
+		KeyRing:        kr,
 	}
 }
 
@@ -190,6 +266,76 @@ func (a *api) makeRouter(ctx context.Context) *chi.Mux {
 											},
 										},
 									},
+									"/accounts": chioas.Path{
+										Methods: chioas.Methods{
+											http.MethodGet: chioas.Method{
+												Description: "Get the logins linked to your user",
+												Handler:     a.accountsHandler,
+												Responses: chioas.Responses{
+													http.StatusOK: chioas.Response{
+														Schema:  domain.Account{},
+														IsArray: true,
+													},
+												},
+											},
+											http.MethodPost: chioas.Method{
+												Description: "Get a code for a guest to link a login with",
+												Handler:     a.linkAccountHandler,
+												Responses: chioas.Responses{
+													http.StatusOK: chioas.Response{
+														Schema: domain.AccountLinkResponse{},
+													},
+												},
+											},
+										},
+										Paths: chioas.Paths{
+											"/{id}": chioas.Path{
+												Methods: chioas.Methods{
+													http.MethodDelete: chioas.Method{
+														Description: "Unlink a login, the last one can't be removed",
+														Handler:     a.deleteAccountHandler,
+														Responses: chioas.Responses{
+															http.StatusNoContent: chioas.Response{},
+														},
+													},
+												},
+											},
+										},
+									},
+									"/sessions": chioas.Path{
+										Methods: chioas.Methods{
+											http.MethodGet: chioas.Method{
+												Description: "Get the devices you're signed in on",
+												Handler:     a.sessionsHandler,
+												Responses: chioas.Responses{
+													http.StatusOK: chioas.Response{
+														Schema:  domain.Session{},
+														IsArray: true,
+													},
+												},
+											},
+											http.MethodDelete: chioas.Method{
+												Description: "Sign out everywhere but the current session",
+												Handler:     a.deleteSessionsHandler,
+												Responses: chioas.Responses{
+													http.StatusNoContent: chioas.Response{},
+												},
+											},
+										},
+										Paths: chioas.Paths{
+											"/{id}": chioas.Path{
+												Methods: chioas.Methods{
+													http.MethodDelete: chioas.Method{
+														Description: "Sign out a session, revoking its tokens",
+														Handler:     a.deleteSessionHandler,
+														Responses: chioas.Responses{
+															http.StatusNoContent: chioas.Response{},
+														},
+													},
+												},
+											},
+										},
+									},
 									"/teams": chioas.Path{
 										Methods: chioas.Methods{
 											http.MethodGet: chioas.Method{
@@ -327,6 +473,86 @@ func (a *api) makeRouter(ctx context.Context) *chi.Mux {
 									},
 								},
 							},
+							"/{id}/status": chioas.Path{
+								Methods: chioas.Methods{
+									http.MethodPost: chioas.Method{
+										Description: "Move a game to a different status",
+										Handler:     a.updateGameStatusHandler,
+										Responses: chioas.Responses{
+											http.StatusOK: chioas.Response{
+												Schema: domain.Game{},
+											},
+										},
+										Request: &chioas.Request{
+											Schema: domain.GameStatusUpdate{},
+										},
+									},
+								},
+							},
+							"/{id}/play-area": chioas.Path{
+								Methods: chioas.Methods{
+									http.MethodPut: chioas.Method{
+										Description: "Set the area players have to stay in, as a GeoJSON Polygon",
+										Handler:     a.updatePlayAreaHandler,
+										Responses: chioas.Responses{
+											http.StatusOK: chioas.Response{
+												Schema: domain.Game{},
+											},
+										},
+										Request: &chioas.Request{
+											Schema: domain.GeoJSONPolygon{},
+										},
+									},
+									http.MethodDelete: chioas.Method{
+										Description: "Remove the play area, letting players go anywhere",
+										Handler:     a.deletePlayAreaHandler,
+										Responses: chioas.Responses{
+											http.StatusOK: chioas.Response{
+												Schema: domain.Game{},
+											},
+										},
+									},
+								},
+							},
+							"/{id}/results": chioas.Path{
+								Methods: chioas.Methods{
+									http.MethodGet: chioas.Method{
+										Description: "Get the final standings of a finished game",
+										Handler:     a.gameResultsHandler,
+										Responses: chioas.Responses{
+											http.StatusOK: chioas.Response{
+												Schema:  domain.GameResult{},
+												IsArray: true,
+											},
+										},
+									},
+								},
+							},
+							"/{id}/rules": chioas.Path{
+								Methods: chioas.Methods{
+									http.MethodGet: chioas.Method{
+										Description: "Get the rules (prices, durations) of a game",
+										Handler:     a.gameRulesHandler,
+										Responses: chioas.Responses{
+											http.StatusOK: chioas.Response{
+												Schema: domain.GameRules{},
+											},
+										},
+									},
+									http.MethodPut: chioas.Method{
+										Description: "Replace the rules of a game",
+										Handler:     a.updateGameRulesHandler,
+										Responses: chioas.Responses{
+											http.StatusOK: chioas.Response{
+												Schema: domain.GameRules{},
+											},
+										},
+										Request: &chioas.Request{
+											Schema: domain.GameRulesUpdate{},
+										},
+									},
+								},
+							},
 							"/{id}/teams": chioas.Path{
 								Methods: chioas.Methods{
 									http.MethodGet: chioas.Method{
@@ -366,13 +592,16 @@ func (a *api) makeRouter(ctx context.Context) *chi.Mux {
 							"/{id}/create-invite": chioas.Path{
 								Methods: chioas.Methods{
 									http.MethodPost: chioas.Method{
-										Description: "Create an invite for a game",
+										Description: "Create an invite for a game, players' by default",
 										Handler:     a.createGameInvite,
 										Responses: chioas.Responses{
 											http.StatusOK: chioas.Response{
 												Schema: domain.GameInvite{},
 											},
 										},
+										Request: &chioas.Request{
+											Schema: domain.GameInviteCreate{},
+										},
 									},
 								},
 							},
@@ -390,6 +619,116 @@ func (a *api) makeRouter(ctx context.Context) *chi.Mux {
 									},
 								},
 							},
+							"/{id}/submissions": chioas.Path{
+								Methods: chioas.Methods{
+									http.MethodGet: chioas.Method{
+										Description: "Get quest evidence submissions in a game (host and referees)",
+										Handler:     a.submissionsByGameIDHandler,
+										Responses: chioas.Responses{
+											http.StatusOK: chioas.Response{
+												Schema:  domain.QuestSubmission{},
+												IsArray: true,
+											},
+										},
+									},
+								},
+							},
+							"/{id}/suspicious-activity": chioas.Path{
+								Methods: chioas.Methods{
+									http.MethodGet: chioas.Method{
+										Description: "Get locations that were turned down as suspicious in a game, optionally for one ?user_id= (host and referees)",
+										Handler:     a.suspiciousActivityHandler,
+										Responses: chioas.Responses{
+											http.StatusOK: chioas.Response{
+												Schema:  domain.SuspiciousActivity{},
+												IsArray: true,
+											},
+										},
+									},
+								},
+							},
+							"/{id}/replay": chioas.Path{
+								Methods: chioas.Methods{
+									http.MethodGet: chioas.Method{
+										Description: "Get every player's track in a game, optionally downsampled with ?interval= (seconds) and ?tolerance= (meters). Host and referees only until the game is finished.",
+										Handler:     a.gameReplayHandler,
+										Responses: chioas.Responses{
+											http.StatusOK: chioas.Response{
+												Schema: domain.Replay{},
+											},
+										},
+									},
+								},
+							},
+							"/{id}/spectate": chioas.Path{
+								Methods: chioas.Methods{
+									http.MethodPost: chioas.Method{
+										Description: "Become a spectator of a game with a spectator invite",
+										Handler:     a.spectateGameHandler,
+										Responses: chioas.Responses{
+											http.StatusCreated: chioas.Response{
+												Schema: domain.GameRole{},
+											},
+										},
+										Request: &chioas.Request{
+											Schema: spectateRequest{},
+										},
+									},
+								},
+							},
+							"/{id}/roles": chioas.Path{
+								Methods: chioas.Methods{
+									http.MethodGet: chioas.Method{
+										Description: "Get the referees and spectators of a game (host only)",
+										Handler:     a.gameRolesHandler,
+										Responses: chioas.Responses{
+											http.StatusOK: chioas.Response{
+												Schema:  domain.GameRole{},
+												IsArray: true,
+											},
+										},
+									},
+								},
+								Paths: chioas.Paths{
+									"/{userId}": chioas.Path{
+										Methods: chioas.Methods{
+											http.MethodPut: chioas.Method{
+												Description: "Make a user a referee or a spectator of a game (host only)",
+												Handler:     a.grantGameRoleHandler,
+												Responses: chioas.Responses{
+													http.StatusOK: chioas.Response{
+														Schema: domain.GameRole{},
+													},
+												},
+												Request: &chioas.Request{
+													Schema: domain.GameRoleGrant{},
+												},
+											},
+											http.MethodDelete: chioas.Method{
+												Description: "Take a user's role in a game away (host only)",
+												Handler:     a.revokeGameRoleHandler,
+												Responses: chioas.Responses{
+													http.StatusNoContent: chioas.Response{},
+												},
+											},
+										},
+									},
+								},
+							},
+							"/{id}/catches": chioas.Path{
+								Methods: chioas.Methods{
+									http.MethodGet: chioas.Method{
+										Description: "Get all catches in a game",
+										Handler:     a.catchesByGameIDHandler,
+										Responses: chioas.Responses{
+											http.StatusOK: chioas.Response{
+												Schema:  domain.Catch{},
+												IsArray: true,
+											},
+										},
+									},
+								},
+							},
 						},
 					},
 					"/teams": chioas.Path{
@@ -453,10 +792,71 @@ func (a *api) makeRouter(ctx context.Context) *chi.Mux {
 									"/catch-team": chioas.Path{
 										Methods: chioas.Methods{
 											http.MethodPost: chioas.Method{
-												Description: "Catch the running team and become the new runner",
+												Description: "Catch the running team and become the new runner. Returns 202 if the catch has to be confirmed",
 												Handler:     a.catchTeamHandler,
 												Responses: chioas.Responses{
-													http.StatusOK: chioas.Response{},
+													http.StatusOK: chioas.Response{
+														Schema: domain.Catch{},
+													},
+													http.StatusAccepted: chioas.Response{
+														Schema: domain.Catch{},
+													},
+												},
+											},
+										},
+									},
+									"/transactions": chioas.Path{
+										Methods: chioas.Methods{
+											http.MethodGet: chioas.Method{
+												Description: "Get a team's balance history",
+												Handler:     a.teamTransactionsHandler,
+												Responses: chioas.Responses{
+													http.StatusOK: chioas.Response{
+														Schema:  domain.TeamTransaction{},
+														IsArray: true,
+													},
+												},
+											},
+											http.MethodPost: chioas.Method{
+												Description: "Adjust a team's balance (host and referees)",
+												Handler:     a.adjustTeamBalanceHandler,
+												Responses: chioas.Responses{
+													http.StatusCreated: chioas.Response{
+														Schema: domain.TeamTransaction{},
+													},
+												},
+												Request: &chioas.Request{
+													Schema: domain.TeamTransactionCreate{},
+												},
+											},
+										},
+									},
+									"/replay": chioas.Path{
+										Paths: chioas.Paths{
+											"/gpx": chioas.Path{
+												Methods: chioas.Methods{
+													http.MethodGet: chioas.Method{
+														Description: "Export a team's tracks as GPX, takes the same parameters as the game's replay",
+														Handler:     a.teamReplayGPXHandler,
+														Responses: chioas.Responses{
+															http.StatusOK: chioas.Response{
+																ContentType: "application/gpx+xml",
+															},
+														},
+													},
+												},
+											},
+											"/geojson": chioas.Path{
+												Methods: chioas.Methods{
+													http.MethodGet: chioas.Method{
+														Description: "Export a team's tracks as a GeoJSON feature collection, takes the same parameters as the game's replay",
+														Handler:     a.teamReplayGeoJSONHandler,
+														Responses: chioas.Responses{
+															http.StatusOK: chioas.Response{
+																Schema: domain.GeoJSONFeatureCollection{},
+															},
+														},
+													},
 												},
 											},
 										},
@@ -562,7 +962,7 @@ func (a *api) makeRouter(ctx context.Context) *chi.Mux {
 									"/complete": chioas.Path{
 										Methods: chioas.Methods{
 											http.MethodPost: chioas.Method{
-												Description: "Complete a quest (provide active quest ID)",
+												Description: "Complete a quest (provide active quest ID). Quests that require evidence take a multipart 'evidence' file and return 202 until the host reviews it",
 												Handler:     a.completeQuestHandler,
 												Responses: chioas.Responses{
 													http.StatusOK: chioas.Response{
@@ -572,6 +972,22 @@ func (a *api) makeRouter(ctx context.Context) *chi.Mux {
 											},
 										},
 									},
+									"/geofence": chioas.Path{
+										Methods: chioas.Methods{
+											http.MethodPut: chioas.Method{
+												Description: "Set a quest's radius or make it honour-based (host only)",
+												Handler:     a.updateQuestGeofenceHandler,
+												Responses: chioas.Responses{
+													http.StatusOK: chioas.Response{
+														Schema: domain.Quest{},
+													},
+												},
+												Request: &chioas.Request{
+													Schema: domain.QuestGeofenceUpdate{},
+												},
+											},
+										},
+									},
 									"/veto": chioas.Path{
 										Methods: chioas.Methods{
 											http.MethodPost: chioas.Method{
@@ -609,9 +1025,125 @@ func (a *api) makeRouter(ctx context.Context) *chi.Mux {
 							},
 						},
 					},
+					"/submissions": chioas.Path{
+						Tag:         "Quests",
+						Middlewares: chi.Middlewares{a.authMiddleware},
+						Paths: chioas.Paths{
+							"/{id}": chioas.Path{
+								Paths: chioas.Paths{
+									"/evidence": chioas.Path{
+										Methods: chioas.Methods{
+											http.MethodGet: chioas.Method{
+												Description: "Download the evidence of a submission",
+												Handler:     a.submissionEvidenceHandler,
+												Responses: chioas.Responses{
+													http.StatusOK: chioas.Response{},
+												},
+											},
+										},
+									},
+									"/approve": chioas.Path{
+										Methods: chioas.Methods{
+											http.MethodPost: chioas.Method{
+												Description: "Approve a submission and pay out the quest (host and referees)",
+												Handler:     a.approveSubmissionHandler,
+												Responses: chioas.Responses{
+													http.StatusOK: chioas.Response{
+														Schema: domain.QuestSubmission{},
+													},
+												},
+												Request: &chioas.Request{
+													Schema: domain.QuestSubmissionReview{},
+												},
+											},
+										},
+									},
+									"/reject": chioas.Path{
+										Methods: chioas.Methods{
+											http.MethodPost: chioas.Method{
+												Description: "Reject a submission, the team can submit again (host and referees)",
+												Handler:     a.rejectSubmissionHandler,
+												Responses: chioas.Responses{
+													http.StatusOK: chioas.Response{
+														Schema: domain.QuestSubmission{},
+													},
+												},
+												Request: &chioas.Request{
+													Schema: domain.QuestSubmissionReview{},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+					"/catches": chioas.Path{
+						Tag:         "Catches",
+						Middlewares: chi.Middlewares{a.authMiddleware},
+						Paths: chioas.Paths{
+							"/{id}": chioas.Path{
+								Paths: chioas.Paths{
+									"/confirm": chioas.Path{
+										Methods: chioas.Methods{
+											http.MethodPost: chioas.Method{
+												Description: "Confirm a pending catch (caught team or host)",
+												Handler:     a.confirmCatchHandler,
+												Responses: chioas.Responses{
+													http.StatusOK: chioas.Response{
+														Schema: domain.Catch{},
+													},
+												},
+											},
+										},
+									},
+									"/reject": chioas.Path{
+										Methods: chioas.Methods{
+											http.MethodPost: chioas.Method{
+												Description: "Reject a pending catch (caught team or host)",
+												Handler:     a.rejectCatchHandler,
+												Responses: chioas.Responses{
+													http.StatusOK: chioas.Response{
+														Schema: domain.Catch{},
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
 					"/oauth2": chioas.Path{
 						Tag: "OAuth2",
 						Paths: chioas.Paths{
+							"/revoke": chioas.Path{
+								Methods: chioas.Methods{
+									http.MethodPost: chioas.Method{
+										Description: "Revoke an access or refresh token (RFC 7009)",
+										Handler:     a.revokeTokenHandler,
+										Responses: chioas.Responses{
+											http.StatusOK: chioas.Response{},
+										},
+										Request: &chioas.Request{
+											Schema:  domain.TokenRevokeRequest{},
+											Comment: "Revoking a refresh token ends its session",
+										},
+									},
+								},
+							},
+							"/logout": chioas.Path{
+								Middlewares: chi.Middlewares{a.authMiddleware},
+								Methods: chioas.Methods{
+									http.MethodPost: chioas.Method{
+										Description: "Sign out, revoking the session's refresh and access tokens",
+										Handler:     a.logoutHandler,
+										Responses: chioas.Responses{
+											http.StatusNoContent: chioas.Response{},
+										},
+									},
+								},
+							},
 							"/token": chioas.Path{
 								Methods: chioas.Methods{
 									http.MethodPost: chioas.Method{
@@ -621,12 +1153,12 @@ func (a *api) makeRouter(ctx context.Context) *chi.Mux {
 											http.StatusOK: chioas.Response{
 												Schema:      domain.AccessTokenAuthCodeResponse{},
 												Description: "Authorization code grant",
-												Comment:     "Refresh token grant is very similar",
+												Comment:     "Refresh token grant is very similar, its refresh token replaces the one sent",
 											},
 										},
 										Request: &chioas.Request{
 											Schema:  domain.AccessTokenRequest{},
-											Comment: "Only provide code/refresh token adequate to the grant type",
+											Comment: "Only provide code/refresh token adequate to the grant type, the guest grant takes invite_code and name instead. Confidential clients also need client_secret, PKCE clients code_verifier",
 										},
 									},
 								},
@@ -642,47 +1174,27 @@ func (a *api) makeRouter(ctx context.Context) *chi.Mux {
 
 	r.Route("/oauth2", func(r chi.Router) {
 		r.Get("/authorize", a.authorizeHandler)
-		r.Get("/d/callback", a.authorizeDiscordCallbackHandler)
+		// Where Discord logins came back before there were others
+		r.Get("/d/callback", a.authorizeCallbackHandler)
+		// Apple posts the code instead
+		r.Get("/callback/{provider}", a.authorizeCallbackHandler)
+		r.Post("/callback/{provider}", a.authorizeCallbackHandler)
 	})
 
-	a.wsServer.Run(ctx)
-
-	a.wsServer.On("join", func(c *websocket.Conn, msg *websocket.Message) {
-		a.logger.Info("received join message",
-			zap.String("message", string(msg.Data)),
-		)
-		p := &wsAuthPayload{}
-		json.Unmarshal(msg.Data, p)
+	// For other services checking our access tokens
+	r.Get("/.well-known/jwks.json", a.jwksHandler)
 
-		u, err := a.getUserFromPayload(ctx, p)
-		if err != nil {
-			c.Send("invalid")
-			return
-		}
-
-		isRunner := false
-		team, err := a.teamRepo.FindByGameUser(ctx, p.GameID, u.ID)
-		if err != nil {
-			// This means the user is a spectator
-			// and not a runner
-		} else {
-			isRunner = team.IsRunner
-		}
-
-		a.logger.Info("attempting to register user",
-			zap.String("user", u.ID),
-		)
-
-		a.WsHub.Register <- &wsClient{
-			conn:     c,
-			user:     u,
-			gameID:   p.GameID,
-			isRunner: isRunner,
-		}
+	a.wsServer.Run(ctx)
 
-		c.Send("ok")
+	a.wsServer.OnDisconnect(func(c *websocket.Conn) {
+		a.WsHub.Disconnect <- c
 	})
 
+	a.wsServer.On("join", a.wsJoinHandler)
+	a.wsServer.On(wsFrameLocation, a.wsLocationHandler)
+	a.wsServer.On(wsFrameHeartbeat, a.wsHeartbeatHandler)
+	a.wsServer.On(wsFrameLive, a.wsLiveHandler)
+
 	r.HandleFunc("/ws", a.wsServer.Handler)
 
 	if err := apiSpec.SetupRoutes(r, apiSpec); err != nil {
@@ -2,47 +2,73 @@ package api
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"net"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
 	"github.com/peonii/inertia/internal/domain"
+	"github.com/peonii/inertia/internal/provider"
+	"github.com/peonii/inertia/internal/repository"
 	"go.uber.org/zap"
 )
 
 type wsAuthPayload struct {
 	Token  string `json:"t"`
 	GameID string `json:"g"`
+	// Protocol version, missing for v1 clients
+	Version int `json:"v"`
+	// Last seq the client saw, to resume from
+	Seq int64 `json:"s"`
+	// Invite for someone who hasn't joined the game yet
+	Invite string `json:"i"`
 }
 
-func (a *api) getUserFromPayload(ctx context.Context, payload *wsAuthPayload) (*domain.User, error) {
-	token := payload.Token
-
-	jt, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+func (a *api) getUserFromPayload(ctx context.Context, payload *wsAuthPayload) (*domain.User, *domain.AccessToken, error) {
+	token, err := a.authenticate(ctx, payload.Token)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	if !jt.Valid {
-		return nil, fmt.Errorf("invalid jwt")
+	user, err := a.userRepo.FindOne(ctx, token.Subject)
+	return user, token, err
+}
+
+// Everything that makes an access token good, for both
+// HTTP and the WebSocket
+func (a *api) authenticate(ctx context.Context, raw string) (*domain.AccessToken, error) {
+	token, err := a.accessTokenRepo.ParseAccessToken(raw)
+	if err != nil {
+		return nil, err
 	}
 
-	uid, err := jt.Claims.GetSubject()
+	revoked, err := a.accessTokenRepo.IsAccessTokenRevoked(ctx, token)
 	if err != nil {
 		return nil, err
 	}
+	if revoked {
+		return nil, repository.ErrAccessTokenRevoked
+	}
 
-	user, err := a.userRepo.FindOne(ctx, uid)
-	return user, err
+	// Signing out revokes the session, which has to
+	// take its access tokens with it
+	if token.SessionID != "" {
+		if _, err := a.sessionRepo.FindOne(ctx, token.SessionID); err != nil {
+			return nil, err
+		}
+	}
+
+	return token, nil
+}
+
+func (a *api) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	a.sendJson(w, http.StatusOK, a.KeyRing.JWKS())
 }
 
 func (a *api) authorizeHandler(w http.ResponseWriter, r *http.Request) {
@@ -55,178 +81,108 @@ func (a *api) authorizeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// We don't support scopes (no need)
-	// Nor do we require a client_id (also no need)
+	client, err := a.oauthClientRepo.FindOne(r.Context(), queryParams.Get("client_id"))
+	if err != nil {
+		a.sendError(w, r, http.StatusBadRequest, err, "invalid client")
+		return
+	}
 
+	// Anywhere else and the code could end up with someone else
 	redirectUri := queryParams.Get("redirect_uri")
-	if redirectUri == "" {
+	if !client.AllowsRedirect(redirectUri) {
 		a.sendError(w, r, http.StatusBadRequest, nil, "invalid redirect uri")
 		return
 	}
 
 	csrfState := queryParams.Get("state")
-	if csrfState == "" {
+	if csrfState == "" || len(csrfState) > domain.OAuthStateMaxLength {
 		a.sendError(w, r, http.StatusBadRequest, nil, "must provide state")
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "csrf_state",
-		Value:    csrfState,
-		SameSite: http.SameSiteLaxMode,
-		Expires:  time.Now().Add(5 * time.Minute),
-	})
+	scopes, err := client.ParseScope(queryParams.Get("scope"))
+	if err != nil {
+		a.sendError(w, r, http.StatusBadRequest, err, "invalid scope")
+		return
+	}
 
-	// We need to set a cookie for redirect_uri
-	// since we're redirecting to Discord for
-	// account authorization
-	http.SetCookie(w, &http.Cookie{
-		Name:     "redirect_uri",
-		Value:    redirectUri,
-		SameSite: http.SameSiteLaxMode,
-		Expires:  time.Now().Add(5 * time.Minute),
-	})
+	// Public clients can't keep a secret, so PKCE is
+	// the only thing tying the code to them
+	codeChallenge := queryParams.Get("code_challenge")
+	if codeChallenge != "" || client.IsPublic() {
+		if err := domain.ValidateCodeChallenge(codeChallenge, queryParams.Get("code_challenge_method")); err != nil {
+			a.sendError(w, r, http.StatusBadRequest, err, err.Error())
+			return
+		}
+	}
 
-	provider := queryParams.Get("provider")
-	if provider == "" {
-		a.sendError(w, r, http.StatusBadRequest, nil, "must provide provider")
+	p, ok := a.providers[queryParams.Get("provider")]
+	if !ok {
+		a.sendError(w, r, http.StatusBadRequest, nil, "invalid provider")
 		return
 	}
 
-	if provider == "discord" {
-		state := make([]byte, 16)
-		if _, err := rand.Read(state); err != nil {
-			a.sendError(w, r, http.StatusInternalServerError, err, "failed to generate state")
+	// Kept until the user comes back from the provider,
+	// the key doubles as the state we send it
+	authReq := &domain.OAuthRequest{
+		Provider:      p.Name(),
+		ClientID:      client.ID,
+		RedirectURI:   redirectUri,
+		Scopes:        scopes,
+		State:         csrfState,
+		CodeChallenge: codeChallenge,
+	}
+
+	// From POST /users/@me/accounts, the guest keeps
+	// everything once they're signed in
+	if linkCode := queryParams.Get("link_code"); linkCode != "" {
+		authReq.LinkUserID, err = a.oauthCodeRepo.ConsumeLinkCode(r.Context(), linkCode)
+		if err != nil {
+			a.sendError(w, r, http.StatusBadRequest, err, "invalid link code")
 			return
 		}
+	}
 
-		stateEnc := base64.URLEncoding.EncodeToString(state)
+	if err := a.oauthCodeRepo.CreateOAuthRequest(r.Context(), authReq); err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to generate state")
+		return
+	}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:  "state",
-			Value: stateEnc,
+	endpoint, err := p.AuthURL(r.Context(), authReq.Key, authReq.Nonce)
+	if err != nil {
+		a.sendError(w, r, http.StatusBadGateway, err, "failed to reach provider")
+		return
+	}
 
-			SameSite: http.SameSiteLaxMode,
-			HttpOnly: true,
-			Secure:   true,
+	http.SetCookie(w, &http.Cookie{
+		Name:  "state",
+		Value: authReq.Key,
 
-			Expires: time.Now().Add(5 * time.Minute),
-		})
+		// Apple posts back cross-site, which Lax wouldn't send
+		SameSite: http.SameSiteNoneMode,
+		HttpOnly: true,
+		Secure:   true,
 
-		discordEndpoint := fmt.Sprintf("https://discord.com/oauth2/authorize?response_type=code&client_id=%s&scope=identify%%20email&state=%s&redirect_uri=%s",
-			a.config.DiscordClientID,
-			stateEnc,
-			a.config.DiscordRedirectURI,
-		)
+		Expires: time.Now().Add(5 * time.Minute),
+	})
 
-		http.Redirect(w, r, discordEndpoint, http.StatusFound)
-	} else if provider == "test" {
-		// randomName := make([]byte, 16)
-		// if _, err := rand.Read(randomName); err != nil {
-		// 	a.sendError(w, r, http.StatusInternalServerError, err, "failed to generate random name")
-		// 	return
-		// }
-
-		// randomNameEnc := base64.URLEncoding.EncodeToString(randomName)
-
-		// userCreate := &domain.UserCreate{
-		// 	Name:        randomNameEnc,
-		// 	DisplayName: randomNameEnc,
-		// 	Image:       "",
-
-		// 	AuthRole: domain.UserAuthRoleBasic,
-		// }
-
-		// a.logger.Info("creating user",
-		// 	zap.Any("user", userCreate),
-		// )
-
-		// user, err := a.userRepo.Create(r.Context(), userCreate)
-		// if err != nil {
-		// 	a.sendError(w, r, http.StatusInternalServerError, err, "failed to create user")
-		// 	return
-		// }
-
-		// accountCreate := &domain.AccountCreate{
-		// 	UserID:       user.ID,
-		// 	AccountType:  "test",
-		// 	AccountID:    randomNameEnc,
-		// 	Email:        randomNameEnc + "-test",
-		// 	AccessToken:  "",
-		// 	RefreshToken: "",
-		// }
-
-		// _, err = a.accountRepo.Create(r.Context(), accountCreate)
-		// if err != nil {
-		// 	a.sendError(w, r, http.StatusInternalServerError, err, "failed to create account")
-		// 	return
-		// }
-
-		// err = a.userStatsRepo.Init(r.Context(), user.ID)
-		// if err != nil {
-		// 	a.sendError(w, r, http.StatusInternalServerError, err, "failed to init stats")
-		// 	return
-		// }
-
-		// g, err := a.gameRepo.Create(r.Context(), &domain.GameCreate{
-		// 	Name:   "Test Game",
-		// 	HostID: user.ID,
-
-		// 	TimeStart: time.Now(),
-		// 	TimeEnd:   time.Now().Add(1 * time.Hour),
-
-		// 	LocLat: 0,
-		// 	LocLng: 0,
-		// })
-		// if err != nil {
-		// 	a.sendError(w, r, http.StatusInternalServerError, err, "failed to create game")
-		// 	return
-		// }
-
-		// t, err := a.teamRepo.Create(r.Context(), &domain.TeamCreate{
-		// 	Name:       "Test Team",
-		// 	GameID:     g.ID,
-		// 	Color:      "#ff0000",
-		// 	Emoji:      "🔴",
-		// 	GameInvite: "",
-		// })
-		// if err != nil {
-		// 	a.sendError(w, r, http.StatusInternalServerError, err, "failed to create team")
-		// 	return
-		// }
-
-		// err = a.teamRepo.AddTeamMember(r.Context(), t.ID, user.ID)
-		// if err != nil {
-		// 	a.sendError(w, r, http.StatusInternalServerError, err, "failed to add team member")
-		// 	return
-		// }
-
-		// oauthCode, err := a.oauthCodeRepo.CreateOAuthCode(r.Context(), user.ID)
-		// if err != nil {
-		// 	a.sendError(w, r, http.StatusInternalServerError, err, "failed to create oauth code")
-		// 	return
-		// }
-
-		// // Redirect to redirect_uri with code
-		// http.Redirect(w, r, fmt.Sprintf("%s?code=%s&state=%s", redirectUri, oauthCode.Code, csrfState), http.StatusFound)
-		a.sendError(w, r, http.StatusNotImplemented, nil, "test provider not implemented")
-		return
-	} else {
-		a.sendError(w, r, http.StatusBadRequest, nil, "invalid provider - must be \"discord\"")
-		return
-	}
+	http.Redirect(w, r, endpoint, http.StatusFound)
 }
 
-func (a *api) authorizeDiscordCallbackHandler(w http.ResponseWriter, r *http.Request) {
-	queryParams := r.URL.Query()
+func (a *api) authorizeCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "provider")
+	if name == "" {
+		name = "discord"
+	}
 
-	csrfState, err := r.Cookie("csrf_state")
-	if err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to get csrf state")
+	p, ok := a.providers[name]
+	if !ok {
+		a.sendError(w, r, http.StatusNotFound, nil, "invalid provider")
 		return
 	}
 
-	state := queryParams.Get("state")
+	// Query for most, form body for form_post
+	state := r.FormValue("state")
 	if state == "" {
 		a.sendError(w, r, http.StatusBadRequest, nil, "invalid state")
 		return
@@ -243,211 +199,279 @@ func (a *api) authorizeDiscordCallbackHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	code := queryParams.Get("code")
-	if code == "" {
-		a.sendError(w, r, http.StatusBadRequest, nil, "invalid code")
+	// Gone once it's used, so the same state can't be replayed
+	authReq, err := a.oauthCodeRepo.ConsumeOAuthRequest(r.Context(), state)
+	if err != nil {
+		a.sendError(w, r, http.StatusBadRequest, err, "invalid state")
 		return
 	}
 
-	cookie, err = r.Cookie("redirect_uri")
-	if err != nil {
-		a.sendError(w, r, http.StatusBadRequest, err, "invalid redirect uri")
+	if authReq.Provider != p.Name() {
+		a.sendError(w, r, http.StatusBadRequest, nil, "invalid state")
 		return
 	}
 
-	redirectUri := cookie.Value
-
-	data := url.Values{}
-	data.Set("client_id", a.config.DiscordClientID)
-	data.Set("client_secret", a.config.DiscordClientSecret)
-	data.Set("grant_type", "authorization_code")
-	data.Set("code", code)
-	data.Set("redirect_uri", a.config.DiscordRedirectURI)
-
-	endpoint := "https://discord.com/api/oauth2/token"
-	req, err := http.NewRequest("POST", endpoint, strings.NewReader(data.Encode()))
-	if err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to create request")
+	code := r.FormValue("code")
+	if code == "" {
+		a.sendError(w, r, http.StatusBadRequest, nil, "invalid code")
 		return
 	}
 
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	resp, err := http.DefaultClient.Do(req)
+	identity, err := p.Exchange(r.Context(), code, authReq.Nonce)
 	if err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to make request")
+		a.sendError(w, r, http.StatusBadGateway, err, "failed to sign in with provider")
 		return
 	}
-	defer resp.Body.Close()
 
-	var tokenResp struct {
-		AccessToken  string `json:"access_token"`
-		RefreshToken string `json:"refresh_token"`
+	var guest *domain.User
+	if authReq.LinkUserID != "" {
+		guest, err = a.userRepo.FindOne(r.Context(), authReq.LinkUserID)
+		// Already upgraded, or merged into someone
+		if err != nil || !guest.IsGuest() {
+			a.sendError(w, r, http.StatusBadRequest, err, "invalid link code")
+			return
+		}
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to decode response")
+	user, ok := a.findOrCreateUser(w, r, p.Name(), identity, guest)
+	if !ok {
 		return
 	}
 
-	req, err = http.NewRequest("GET", "https://discord.com/api/v10/users/@me", nil)
+	oauthCode, err := a.oauthCodeRepo.CreateOAuthCode(r.Context(), user.ID, authReq)
 	if err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to create request")
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to create oauth code")
 		return
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenResp.AccessToken))
-	var userResp struct {
-		ID          string `json:"id"`
-		Username    string `json:"username"`
-		DisplayName string `json:"global_name"`
-		Email       string `json:"email"`
-		Image       string `json:"avatar"`
-	}
-
-	resp, err = http.DefaultClient.Do(req)
+	// Redirect to redirect_uri with code, it was
+	// checked against the client's when we got it
+	redirectUri, err := url.Parse(authReq.RedirectURI)
 	if err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to make request")
-		return
-	}
-	defer resp.Body.Close()
-
-	if err := json.NewDecoder(resp.Body).Decode(&userResp); err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to decode response")
+		a.sendError(w, r, http.StatusInternalServerError, err, "invalid redirect uri")
 		return
 	}
 
-	var user *domain.User
-	account, err := a.accountRepo.FindByAccountID(r.Context(), userResp.ID, "discord")
-	if err != nil || account == nil {
-		accts, err := a.accountRepo.FindByEmail(r.Context(), userResp.Email)
-		if err != nil || len(accts) == 0 {
-			// This means the user doesn't exist and we have to create them
-
-			dn := userResp.DisplayName
-			if dn == "" {
-				dn = userResp.Username
-			}
+	query := redirectUri.Query()
+	query.Set("code", oauthCode.Code)
+	query.Set("state", authReq.State)
+	redirectUri.RawQuery = query.Encode()
 
-			userCreate := &domain.UserCreate{
-				Name:        userResp.Username,
-				DisplayName: dn,
-				Image:       "https://cdn.discordapp.com/avatars/" + userResp.ID + "/" + userResp.Image + ".png",
-
-				AuthRole: domain.UserAuthRoleBasic,
-			}
-
-			a.logger.Info("creating user",
-				zap.Any("user", userCreate),
-			)
-
-			user, err = a.userRepo.Create(r.Context(), userCreate)
-			if err != nil {
-				a.sendError(w, r, http.StatusInternalServerError, err, "failed to create user")
-				return
-			}
-		} else {
-			user, err = a.userRepo.FindOne(r.Context(), account.UserID)
-			if err != nil {
-				a.sendError(w, r, http.StatusInternalServerError, err, "failed to find user (non-critical)")
-				return
-			}
+	// 303 so a form_post callback turns into a GET
+	http.Redirect(w, r, redirectUri.String(), http.StatusSeeOther)
+}
 
-			dn := userResp.DisplayName
-			if dn == "" {
-				dn = userResp.Username
-			}
+// The user behind an identity. Known accounts sign in as their
+// user, new ones are linked to whoever has the same verified
+// email, and anyone else gets a new user. A guest linking a
+// provider is merged into the user it finds, or becomes the
+// new user.
+func (a *api) findOrCreateUser(w http.ResponseWriter, r *http.Request, accountType string, identity *provider.Identity, guest *domain.User) (*domain.User, bool) {
+	account, err := a.accountRepo.FindByAccountID(r.Context(), identity.ID, accountType)
+	if err == nil {
+		user, err := a.userRepo.FindOne(r.Context(), account.UserID)
+		if err != nil {
+			// Something went REALLY wrong
+			a.sendError(w, r, http.StatusInternalServerError, err, "failed to find user")
+			return nil, false
+		}
 
-			user.Image = "https://cdn.discordapp.com/avatars/" + userResp.ID + "/" + userResp.Image + ".png"
+		if identity.Image != "" && user.Image != identity.Image {
+			user.Image = identity.Image
 
 			user, err = a.userRepo.Update(r.Context(), user)
 			if err != nil {
 				a.sendError(w, r, http.StatusInternalServerError, err, "failed to update user")
-				return
+				return nil, false
 			}
-
 		}
 
-		accountCreate := &domain.AccountCreate{
-			UserID:       user.ID,
-			AccountType:  "discord",
-			AccountID:    userResp.ID,
-			Email:        userResp.Email,
-			AccessToken:  tokenResp.AccessToken,
-			RefreshToken: tokenResp.RefreshToken,
+		account.Email = identity.Email
+		account.EmailVerified = identity.EmailVerified
+		account.AccessToken = identity.AccessToken
+		account.RefreshToken = identity.RefreshToken
+
+		if _, err := a.accountRepo.Update(r.Context(), account); err != nil {
+			a.sendError(w, r, http.StatusInternalServerError, err, "failed to update account")
+			return nil, false
 		}
 
-		_, err = a.accountRepo.Create(r.Context(), accountCreate)
-		if err != nil {
-			a.sendError(w, r, http.StatusInternalServerError, err, "failed to create account")
-			return
+		if guest != nil && !a.mergeGuest(w, r, guest, user) {
+			return nil, false
 		}
-	} else {
-		// User exists, update their image
 
-		user, err = a.userRepo.FindOne(r.Context(), account.UserID)
+		return user, true
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find account")
+		return nil, false
+	}
+
+	var user *domain.User
+
+	// Only when both sides have verified the email, otherwise
+	// anyone could sign up with it and take the account over
+	if identity.Email != "" && identity.EmailVerified {
+		accts, err := a.accountRepo.FindByEmail(r.Context(), identity.Email)
 		if err != nil {
-			// Something went REALLY wrong
-			a.sendError(w, r, http.StatusInternalServerError, err, "failed to find user")
-			return
+			a.sendError(w, r, http.StatusInternalServerError, err, "failed to find accounts")
+			return nil, false
 		}
 
-		dn := userResp.DisplayName
-		if dn == "" {
-			dn = userResp.Username
-		}
+		for _, acct := range accts {
+			if !acct.EmailVerified {
+				continue
+			}
+
+			user, err = a.userRepo.FindOne(r.Context(), acct.UserID)
+			if err != nil {
+				a.sendError(w, r, http.StatusInternalServerError, err, "failed to find user")
+				return nil, false
+			}
 
-		user.Image = "https://cdn.discordapp.com/avatars/" + userResp.ID + "/" + userResp.Image + ".png"
+			break
+		}
+	}
 
-		user, err = a.userRepo.Update(r.Context(), user)
+	if user != nil && guest != nil {
+		if !a.mergeGuest(w, r, guest, user) {
+			return nil, false
+		}
+	} else if guest != nil {
+		// Nobody to merge into, so the guest is who they are now
+		guest.Name = identity.Username
+		guest.DisplayName = identity.DisplayName
+		guest.Image = identity.Image
+		guest.AuthRole = domain.UserAuthRoleBasic
+
+		user, err = a.userRepo.Update(r.Context(), guest)
 		if err != nil {
 			a.sendError(w, r, http.StatusInternalServerError, err, "failed to update user")
-			return
+			return nil, false
 		}
+	} else if user == nil {
+		userCreate := &domain.UserCreate{
+			Name:        identity.Username,
+			DisplayName: identity.DisplayName,
+			Image:       identity.Image,
 
-		account.AccessToken = tokenResp.AccessToken
-		account.RefreshToken = tokenResp.RefreshToken
+			AuthRole: domain.UserAuthRoleBasic,
+		}
 
-		_, err = a.accountRepo.Update(r.Context(), account)
-	}
+		a.logger.Info("creating user",
+			zap.Any("user", userCreate),
+		)
+
+		user, err = a.userRepo.Create(r.Context(), userCreate)
+		if err != nil {
+			a.sendError(w, r, http.StatusInternalServerError, err, "failed to create user")
+			return nil, false
+		}
 
-	_, err = a.userStatsRepo.Get(r.Context(), user.ID)
-	if err != nil {
 		if err := a.userStatsRepo.Init(r.Context(), user.ID); err != nil {
 			a.sendError(w, r, http.StatusInternalServerError, err, "failed to init stats")
-			return
+			return nil, false
 		}
 	}
 
-	oauthCode, err := a.oauthCodeRepo.CreateOAuthCode(r.Context(), user.ID)
+	accountCreate := &domain.AccountCreate{
+		UserID:        user.ID,
+		AccountType:   accountType,
+		AccountID:     identity.ID,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		AccessToken:   identity.AccessToken,
+		RefreshToken:  identity.RefreshToken,
+	}
+
+	if _, err := a.accountRepo.Create(r.Context(), accountCreate); err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to create account")
+		return nil, false
+	}
+
+	return user, true
+}
+
+// Moves the guest's teams, stats and devices over to user.
+// Their sessions go with them, they sign in as user next.
+func (a *api) mergeGuest(w http.ResponseWriter, r *http.Request, guest *domain.User, user *domain.User) bool {
+	if err := a.userRepo.Merge(r.Context(), guest.ID, user.ID); err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to merge guest")
+		return false
+	}
+
+	a.logger.Info("merged guest",
+		zap.String("guest_id", guest.ID),
+		zap.String("user_id", user.ID),
+	)
+
+	// Nobody's looking the guest up anymore, so a stale
+	// location is only a leftover key
+	if err := a.locationRepo.Forget(r.Context(), guest.ID); err != nil {
+		a.logger.Error("failed to forget guest location", zap.String("guest_id", guest.ID), zap.Error(err))
+	}
+
+	sessions, err := a.sessionRepo.FindByUserID(r.Context(), guest.ID)
 	if err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to create oauth code")
-		return
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find sessions")
+		return false
+	}
+
+	for _, s := range sessions {
+		if err := a.sessionRepo.Delete(r.Context(), s.ID); err != nil {
+			a.sendError(w, r, http.StatusInternalServerError, err, "failed to delete session")
+			return false
+		}
 	}
 
-	// Redirect to redirect_uri with code
-	http.Redirect(w, r, fmt.Sprintf("%s?code=%s&state=%s", redirectUri, oauthCode.Code, csrfState.Value), http.StatusFound)
+	return true
 }
 
 const (
 	grantTypeAuthorizationCode = "authorization_code"
 	grantTypeRefreshToken      = "refresh_token"
+	// Not in the spec, signs in a new guest
+	grantTypeGuest = "guest"
 )
 
 func (a *api) tokenCreationHandler(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Code         string `json:"code"`          // Only used for authorization_code grant type
-		RefreshToken string `json:"refresh_token"` // Only used for refresh_token grant type
-		GrantType    string `json:"grant_type"`
-	}
+	var req domain.AccessTokenRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	// Clients going by the spec send a form, ours send JSON
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if err := r.ParseForm(); err != nil {
+			a.sendError(w, r, http.StatusBadRequest, err, "failed to decode request body")
+			return
+		}
+
+		req = domain.AccessTokenRequest{
+			GrantType:    r.PostForm.Get("grant_type"),
+			Code:         r.PostForm.Get("code"),
+			RefreshToken: r.PostForm.Get("refresh_token"),
+			ClientID:     r.PostForm.Get("client_id"),
+			ClientSecret: r.PostForm.Get("client_secret"),
+			RedirectURI:  r.PostForm.Get("redirect_uri"),
+			CodeVerifier: r.PostForm.Get("code_verifier"),
+			DeviceName:   r.PostForm.Get("device_name"),
+			InviteCode:   r.PostForm.Get("invite_code"),
+			Name:         r.PostForm.Get("name"),
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		a.sendError(w, r, http.StatusBadRequest, err, "failed to decode request body")
 		return
 	}
 
+	if id, secret, ok := r.BasicAuth(); ok {
+		req.ClientID = id
+		req.ClientSecret = secret
+	}
+
+	if len(req.DeviceName) > domain.MaxDeviceNameLength {
+		a.sendError(w, r, http.StatusBadRequest, nil, "device name is too long")
+		return
+	}
+
 	if req.GrantType == grantTypeAuthorizationCode {
-		code, err := a.oauthCodeRepo.FindOAuthCodeByToken(r.Context(), req.Code)
+		code, err := a.oauthCodeRepo.ConsumeOAuthCode(r.Context(), req.Code)
 		if err != nil {
 			a.sendError(w, r, http.StatusBadRequest, err, "invalid code")
 			return
@@ -458,38 +482,112 @@ func (a *api) tokenCreationHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		rt, err := a.refreshTokenRepo.CreateRefreshToken(r.Context(), code.UserID)
+		if code.ClientID != req.ClientID {
+			a.sendError(w, r, http.StatusBadRequest, nil, "invalid code")
+			return
+		}
+
+		client, ok := a.authenticateClient(w, r, &req)
+		if !ok {
+			return
+		}
+
+		if code.RedirectURI != req.RedirectURI {
+			a.sendError(w, r, http.StatusBadRequest, nil, "redirect uri doesn't match")
+			return
+		}
+
+		if code.CodeChallenge != "" && !domain.VerifyCodeChallenge(code.CodeChallenge, req.CodeVerifier) {
+			a.sendError(w, r, http.StatusBadRequest, nil, "invalid code verifier")
+			return
+		}
+
+		a.startSession(w, r, &domain.SessionCreate{
+			UserID:     code.UserID,
+			ClientID:   client.ID,
+			Scopes:     code.Scopes,
+			DeviceName: req.DeviceName,
+			UserAgent:  r.UserAgent(),
+		})
+	} else if req.GrantType == grantTypeGuest {
+		client, ok := a.authenticateClient(w, r, &req)
+		if !ok {
+			return
+		}
+
+		// Anyone with an invite can play, so it's all they need
+		invite, err := a.gameInviteRepo.FindBySlug(r.Context(), req.InviteCode)
 		if err != nil {
-			a.sendError(w, r, http.StatusInternalServerError, err, "failed to create token")
+			a.sendError(w, r, http.StatusBadRequest, err, "invalid invite")
 			return
 		}
 
-		at, err := a.accessTokenRepo.CreateAccessToken(code.UserID)
+		name := strings.TrimSpace(req.Name)
+		if err := domain.ValidateGuestName(name); err != nil {
+			a.sendError(w, r, http.StatusBadRequest, err, err.Error())
+			return
+		}
+
+		scopes := domain.GuestScopes(client.Scopes, invite.IsSpectator())
+		if len(scopes) == 0 {
+			a.sendError(w, r, http.StatusBadRequest, nil, "client can't sign in guests")
+			return
+		}
+
+		if !a.allowGuest(w, r, invite) {
+			return
+		}
+
+		user, err := a.userRepo.Create(r.Context(), &domain.UserCreate{
+			Name:        name,
+			DisplayName: name,
+
+			AuthRole: domain.UserAuthRoleGuest,
+		})
 		if err != nil {
-			a.sendError(w, r, http.StatusInternalServerError, err, "failed to create token")
+			a.sendError(w, r, http.StatusInternalServerError, err, "failed to create user")
 			return
 		}
 
-		if err := a.oauthCodeRepo.DeleteOAuthCodeByToken(r.Context(), req.Code); err != nil {
-			a.sendError(w, r, http.StatusInternalServerError, err, "failed to clean up")
+		a.logger.Info("created guest",
+			zap.String("user_id", user.ID),
+			zap.String("game_id", invite.GameID),
+		)
+
+		if err := a.userStatsRepo.Init(r.Context(), user.ID); err != nil {
+			a.sendError(w, r, http.StatusInternalServerError, err, "failed to init stats")
 			return
 		}
 
-		resp := struct {
-			AccessToken  string `json:"access_token"`
-			RefreshToken string `json:"refresh_token"`
-			ExpiresIn    int    `json:"expires_in"`
-			TokenType    string `json:"token_type"`
-		}{
-			AccessToken:  at,
-			RefreshToken: rt.Token,
-			ExpiresIn:    5 * 60,
-			TokenType:    "Bearer",
+		// Spectators are in straight away, players still
+		// join a team with the invite
+		if invite.IsSpectator() {
+			_, err := a.gameRoleRepo.Grant(r.Context(), &domain.GameRole{
+				GameID: invite.GameID,
+				UserID: user.ID,
+				Role:   domain.GameRoleSpectator,
+			})
+			if err != nil {
+				a.sendError(w, r, http.StatusInternalServerError, err, "failed to spectate game")
+				return
+			}
 		}
 
-		a.sendJson(w, http.StatusOK, resp)
+		a.startSession(w, r, &domain.SessionCreate{
+			UserID:     user.ID,
+			ClientID:   client.ID,
+			Scopes:     scopes,
+			GameID:     invite.GameID,
+			DeviceName: req.DeviceName,
+			UserAgent:  r.UserAgent(),
+		})
 	} else if req.GrantType == grantTypeRefreshToken {
 		rt, err := a.refreshTokenRepo.FindRefreshTokenByToken(r.Context(), req.RefreshToken)
+		if errors.Is(err, repository.ErrRefreshTokenReused) {
+			a.logger.Warn("refresh token reused, revoked its session")
+			a.sendError(w, r, http.StatusBadRequest, err, "refresh token was already used")
+			return
+		}
 		if err != nil {
 			a.sendError(w, r, http.StatusBadRequest, err, "invalid refresh token")
 			return
@@ -500,25 +598,258 @@ func (a *api) tokenCreationHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		at, err := a.accessTokenRepo.CreateAccessToken(rt.UserID)
-		if err != nil {
-			a.sendError(w, r, http.StatusInternalServerError, err, "failed to create token")
+		clientID := rt.ClientID
+		scopes := rt.Scopes
+		if rt.SessionID != "" {
+			session, err := a.sessionRepo.FindOne(r.Context(), rt.SessionID)
+			if err != nil {
+				a.sendError(w, r, http.StatusBadRequest, err, "invalid refresh token")
+				return
+			}
+
+			clientID = session.ClientID
+			scopes = session.Scopes
+		}
+
+		// Tokens from before there were clients aren't tied to one
+		if clientID != "" {
+			if clientID != req.ClientID {
+				a.sendError(w, r, http.StatusBadRequest, nil, "invalid refresh token")
+				return
+			}
+
+			client, ok := a.authenticateClient(w, r, &req)
+			if !ok {
+				return
+			}
+
+			// The client could have lost some scopes since
+			allowed := []string{}
+			for _, s := range scopes {
+				if domain.HasScope(client.Scopes, s) {
+					allowed = append(allowed, s)
+				}
+			}
+
+			if len(allowed) == 0 {
+				a.sendError(w, r, http.StatusBadRequest, nil, "invalid scope")
+				return
+			}
+
+			scopes = allowed
+		}
+
+		session, next, ok := a.rotateRefreshToken(w, r, rt, clientID, scopes)
+		if !ok {
 			return
 		}
+		session.Scopes = scopes
 
-		resp := struct {
-			AccessToken string `json:"access_token"`
-			ExpiresIn   int    `json:"expires_in"`
-			TokenType   string `json:"token_type"`
-		}{
-			AccessToken: at,
-			ExpiresIn:   5 * 60,
-			TokenType:   "Bearer",
+		at, err := a.accessTokenRepo.CreateAccessToken(session)
+		if err != nil {
+			a.sendError(w, r, http.StatusInternalServerError, err, "failed to create token")
+			return
 		}
 
-		a.sendJson(w, http.StatusOK, resp)
+		a.sendJson(w, http.StatusOK, domain.AccessTokenRefreshResponse{
+			AccessToken:  at,
+			RefreshToken: next.Token,
+			ExpiresIn:    5 * 60,
+			TokenType:    "Bearer",
+			Scope:        strings.Join(scopes, " "),
+		})
 	} else {
 		a.sendError(w, r, http.StatusBadRequest, nil, "unsupported grant type")
 		return
 	}
 }
+
+// Sends a new session's tokens, for grants that sign someone in
+// Guests are counted against the invite and the address they
+// come from, so a leaked invite can't make users without end
+func (a *api) allowGuest(w http.ResponseWriter, r *http.Request, invite *domain.GameInvite) bool {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+
+	limits := []struct {
+		key   string
+		limit int
+	}{
+		{"guests.invite." + invite.ID, domain.GuestsPerInvite},
+		{"guests.addr." + addr, domain.GuestsPerAddress},
+	}
+
+	for _, l := range limits {
+		ok, err := a.rateLimitRepo.Allow(r.Context(), l.key, l.limit, domain.GuestLimitWindow)
+		if err != nil {
+			a.sendError(w, r, http.StatusInternalServerError, err, "failed to check rate limit")
+			return false
+		}
+
+		if !ok {
+			a.sendError(w, r, http.StatusTooManyRequests, nil, "too many guests, try again later")
+			return false
+		}
+	}
+
+	return true
+}
+
+func (a *api) startSession(w http.ResponseWriter, r *http.Request, sessionCreate *domain.SessionCreate) {
+	session, err := a.sessionRepo.Create(r.Context(), sessionCreate)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to create session")
+		return
+	}
+
+	rt, err := a.refreshTokenRepo.CreateRefreshToken(r.Context(), session)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to create token")
+		return
+	}
+
+	at, err := a.accessTokenRepo.CreateAccessToken(session)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to create token")
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, domain.AccessTokenAuthCodeResponse{
+		AccessToken:  at,
+		RefreshToken: rt.Token,
+		ExpiresIn:    5 * 60,
+		TokenType:    "Bearer",
+		Scope:        strings.Join(session.Scopes, " "),
+	})
+}
+
+// Refresh tokens can only be used once, the response has
+// the one to use next
+func (a *api) rotateRefreshToken(w http.ResponseWriter, r *http.Request, rt *domain.RefreshToken, clientID string, scopes []string) (*domain.Session, *domain.RefreshToken, bool) {
+	if rt.SessionID != "" {
+		session, next, err := a.refreshTokenRepo.RotateRefreshToken(r.Context(), rt.Token, r.UserAgent())
+		if errors.Is(err, repository.ErrRefreshTokenReused) {
+			a.logger.Warn("refresh token reused, revoked its session",
+				zap.String("user_id", rt.UserID),
+				zap.String("session_id", rt.SessionID),
+			)
+			a.sendError(w, r, http.StatusBadRequest, err, "refresh token was already used")
+			return nil, nil, false
+		}
+		if err != nil {
+			a.sendError(w, r, http.StatusBadRequest, err, "invalid refresh token")
+			return nil, nil, false
+		}
+
+		return session, next, true
+	}
+
+	// Tokens from before sessions get one now
+	session, err := a.sessionRepo.Create(r.Context(), &domain.SessionCreate{
+		UserID:    rt.UserID,
+		ClientID:  clientID,
+		Scopes:    scopes,
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to create session")
+		return nil, nil, false
+	}
+
+	next, err := a.refreshTokenRepo.CreateRefreshToken(r.Context(), session)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to create token")
+		return nil, nil, false
+	}
+
+	if err := a.refreshTokenRepo.DeleteRefreshTokenByToken(r.Context(), rt.Token); err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to clean up")
+		return nil, nil, false
+	}
+
+	return session, next, true
+}
+
+// RFC 7009. Answers the same whether or not the token was
+// any good, so it can't be used to check tokens.
+func (a *api) revokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req domain.TokenRevokeRequest
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if err := r.ParseForm(); err != nil {
+			a.sendError(w, r, http.StatusBadRequest, err, "failed to decode request body")
+			return
+		}
+
+		req = domain.TokenRevokeRequest{
+			Token:        r.PostForm.Get("token"),
+			ClientID:     r.PostForm.Get("client_id"),
+			ClientSecret: r.PostForm.Get("client_secret"),
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.sendError(w, r, http.StatusBadRequest, err, "failed to decode request body")
+		return
+	}
+
+	if id, secret, ok := r.BasicAuth(); ok {
+		req.ClientID = id
+		req.ClientSecret = secret
+	}
+
+	client, ok := a.authenticateClient(w, r, &domain.AccessTokenRequest{
+		ClientID:     req.ClientID,
+		ClientSecret: req.ClientSecret,
+	})
+	if !ok {
+		return
+	}
+
+	// Clients can only revoke their own tokens, ones from
+	// before there were clients belong to any of them
+	owns := func(clientID string) bool {
+		return clientID == "" || clientID == client.ID
+	}
+
+	if rt, err := a.refreshTokenRepo.FindRefreshTokenByToken(r.Context(), req.Token); err == nil {
+		// Revoking a refresh token ends its session
+		var revokeErr error
+		if rt.SessionID == "" {
+			if owns(rt.ClientID) {
+				revokeErr = a.refreshTokenRepo.DeleteRefreshTokenByToken(r.Context(), rt.Token)
+			}
+		} else if session, err := a.sessionRepo.FindOne(r.Context(), rt.SessionID); err == nil && owns(session.ClientID) {
+			revokeErr = a.sessionRepo.Delete(r.Context(), session.ID)
+		}
+
+		if revokeErr != nil {
+			a.sendError(w, r, http.StatusInternalServerError, revokeErr, "failed to revoke token")
+			return
+		}
+	} else if at, err := a.accessTokenRepo.ParseAccessToken(req.Token); err == nil && owns(at.ClientID) {
+		if err := a.accessTokenRepo.RevokeAccessToken(r.Context(), at); err != nil {
+			a.sendError(w, r, http.StatusInternalServerError, err, "failed to revoke token")
+			return
+		}
+	}
+
+	a.sendJson(w, http.StatusOK, struct{}{})
+}
+
+// Confidential clients prove who they are with their secret,
+// public ones rely on PKCE instead
+func (a *api) authenticateClient(w http.ResponseWriter, r *http.Request, req *domain.AccessTokenRequest) (*domain.OAuthClient, bool) {
+	client, err := a.oauthClientRepo.FindOne(r.Context(), req.ClientID)
+	if err != nil {
+		a.sendError(w, r, http.StatusUnauthorized, err, "invalid client")
+		return nil, false
+	}
+
+	if !client.IsPublic() && !client.CheckSecret(req.ClientSecret) {
+		a.sendError(w, r, http.StatusUnauthorized, nil, "invalid client")
+		return nil, false
+	}
+
+	return client, true
+}
@@ -0,0 +1,395 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/peonii/inertia/internal/domain"
+	"github.com/peonii/inertia/internal/provider"
+	"github.com/peonii/inertia/internal/repository"
+	"go.uber.org/zap"
+)
+
+// Just enough of users, accounts and sessions in memory
+// for signing in, the rest of the repositories are nil
+type fakeAuthStore struct {
+	users    map[string]*domain.User
+	accounts []*domain.Account
+	sessions map[string][]*domain.Session
+	merged   map[string]string
+	// Users whose latest location was dropped
+	forgotten []string
+	nextID    int
+}
+
+func newFakeAuthStore() *fakeAuthStore {
+	return &fakeAuthStore{
+		users:    make(map[string]*domain.User),
+		sessions: make(map[string][]*domain.Session),
+		merged:   make(map[string]string),
+	}
+}
+
+func (s *fakeAuthStore) id() string {
+	s.nextID++
+	return strconv.Itoa(s.nextID)
+}
+
+func (s *fakeAuthStore) addUser(authRole string) *domain.User {
+	user := &domain.User{ID: s.id(), Name: "user" + strconv.Itoa(s.nextID), AuthRole: authRole}
+	s.users[user.ID] = user
+	return user
+}
+
+func (s *fakeAuthStore) addAccount(userID, accountType, accountID, email string, verified bool) {
+	s.accounts = append(s.accounts, &domain.Account{
+		ID:            s.id(),
+		UserID:        userID,
+		AccountType:   accountType,
+		AccountID:     accountID,
+		Email:         email,
+		EmailVerified: verified,
+	})
+}
+
+func (s *fakeAuthStore) api() *api {
+	return &api{
+		logger:        zap.NewNop(),
+		userRepo:      &fakeUserRepo{store: s},
+		accountRepo:   &fakeAccountRepo{store: s},
+		userStatsRepo: &fakeUserStatsRepo{},
+		sessionRepo:   &fakeSessionRepo{store: s},
+		locationRepo:  &fakeLocationRepo{store: s},
+	}
+}
+
+type fakeUserRepo struct {
+	repository.UserRepository
+	store *fakeAuthStore
+}
+
+func (r *fakeUserRepo) FindOne(ctx context.Context, id string) (*domain.User, error) {
+	user, ok := r.store.users[id]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+
+	copied := *user
+	return &copied, nil
+}
+
+func (r *fakeUserRepo) Create(ctx context.Context, create *domain.UserCreate) (*domain.User, error) {
+	user := &domain.User{
+		ID:          r.store.id(),
+		Name:        create.Name,
+		DisplayName: create.DisplayName,
+		Image:       create.Image,
+		AuthRole:    create.AuthRole,
+	}
+	r.store.users[user.ID] = user
+
+	copied := *user
+	return &copied, nil
+}
+
+func (r *fakeUserRepo) Update(ctx context.Context, user *domain.User) (*domain.User, error) {
+	if _, ok := r.store.users[user.ID]; !ok {
+		return nil, pgx.ErrNoRows
+	}
+
+	copied := *user
+	r.store.users[user.ID] = &copied
+	return user, nil
+}
+
+func (r *fakeUserRepo) Merge(ctx context.Context, fromID string, intoID string) error {
+	for _, acct := range r.store.accounts {
+		if acct.UserID == fromID {
+			acct.UserID = intoID
+		}
+	}
+
+	delete(r.store.users, fromID)
+	r.store.merged[fromID] = intoID
+	return nil
+}
+
+type fakeAccountRepo struct {
+	repository.AccountRepository
+	store *fakeAuthStore
+}
+
+func (r *fakeAccountRepo) FindByAccountID(ctx context.Context, id string, accountType string) (*domain.Account, error) {
+	for _, acct := range r.store.accounts {
+		if acct.AccountID == id && acct.AccountType == accountType {
+			copied := *acct
+			return &copied, nil
+		}
+	}
+
+	return nil, pgx.ErrNoRows
+}
+
+func (r *fakeAccountRepo) FindByEmail(ctx context.Context, email string) ([]*domain.Account, error) {
+	var accts []*domain.Account
+	for _, acct := range r.store.accounts {
+		if acct.Email == email {
+			copied := *acct
+			accts = append(accts, &copied)
+		}
+	}
+
+	return accts, nil
+}
+
+func (r *fakeAccountRepo) Create(ctx context.Context, create *domain.AccountCreate) (*domain.Account, error) {
+	r.store.addAccount(create.UserID, create.AccountType, create.AccountID, create.Email, create.EmailVerified)
+	return r.store.accounts[len(r.store.accounts)-1], nil
+}
+
+func (r *fakeAccountRepo) Update(ctx context.Context, account *domain.Account) (*domain.Account, error) {
+	for i, acct := range r.store.accounts {
+		if acct.ID == account.ID {
+			copied := *account
+			r.store.accounts[i] = &copied
+			return account, nil
+		}
+	}
+
+	return nil, pgx.ErrNoRows
+}
+
+type fakeUserStatsRepo struct {
+	repository.UserStatsRepository
+}
+
+func (r *fakeUserStatsRepo) Init(ctx context.Context, userID string) error {
+	return nil
+}
+
+type fakeSessionRepo struct {
+	repository.SessionRepository
+	store *fakeAuthStore
+}
+
+func (r *fakeSessionRepo) FindByUserID(ctx context.Context, userID string) ([]*domain.Session, error) {
+	return r.store.sessions[userID], nil
+}
+
+func (r *fakeSessionRepo) Delete(ctx context.Context, id string) error {
+	for userID, sessions := range r.store.sessions {
+		for i, s := range sessions {
+			if s.ID == id {
+				r.store.sessions[userID] = append(sessions[:i], sessions[i+1:]...)
+				return nil
+			}
+		}
+	}
+
+	return pgx.ErrNoRows
+}
+
+type fakeLocationRepo struct {
+	repository.LocationRepository
+	store *fakeAuthStore
+}
+
+func (r *fakeLocationRepo) Forget(ctx context.Context, userID string) error {
+	r.store.forgotten = append(r.store.forgotten, userID)
+	return nil
+}
+
+func signInAs(t *testing.T, a *api, accountType string, identity *provider.Identity, guest *domain.User) *domain.User {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/oauth2/callback/"+accountType, nil)
+
+	user, ok := a.findOrCreateUser(w, r, accountType, identity, guest)
+	if !ok {
+		t.Fatalf("sign in failed with %d: %s", w.Code, w.Body.String())
+	}
+
+	return user
+}
+
+func (s *fakeAuthStore) accountOf(t *testing.T, accountType, accountID string) *domain.Account {
+	t.Helper()
+
+	for _, acct := range s.accounts {
+		if acct.AccountType == accountType && acct.AccountID == accountID {
+			return acct
+		}
+	}
+
+	t.Fatalf("no %s account %s", accountType, accountID)
+	return nil
+}
+
+func TestFindOrCreateUserExistingAccount(t *testing.T) {
+	store := newFakeAuthStore()
+	existing := store.addUser(domain.UserAuthRoleBasic)
+	store.addAccount(existing.ID, "google", "g-1", "runner@example.com", true)
+
+	user := signInAs(t, store.api(), "google", &provider.Identity{
+		ID:            "g-1",
+		Email:         "runner@example.com",
+		EmailVerified: true,
+		Username:      "runner",
+		AccessToken:   "new",
+	}, nil)
+
+	if user.ID != existing.ID {
+		t.Fatalf("expected user %s, got %s", existing.ID, user.ID)
+	}
+
+	if len(store.accounts) != 1 || store.accounts[0].AccessToken != "new" {
+		t.Fatalf("expected the account to be updated in place, got %+v", store.accounts)
+	}
+}
+
+func TestFindOrCreateUserLinksVerifiedEmail(t *testing.T) {
+	store := newFakeAuthStore()
+	existing := store.addUser(domain.UserAuthRoleBasic)
+	store.addAccount(existing.ID, "google", "g-1", "runner@example.com", true)
+
+	user := signInAs(t, store.api(), "fake", &provider.Identity{
+		ID:            "f-1",
+		Email:         "runner@example.com",
+		EmailVerified: true,
+		Username:      "runner",
+	}, nil)
+
+	if user.ID != existing.ID {
+		t.Fatalf("expected the login to be linked to %s, got user %s", existing.ID, user.ID)
+	}
+
+	if acct := store.accountOf(t, "fake", "f-1"); acct.UserID != existing.ID {
+		t.Fatalf("expected the account on %s, got %s", existing.ID, acct.UserID)
+	}
+}
+
+func TestFindOrCreateUserSkipsUnverifiedEmail(t *testing.T) {
+	tests := []struct {
+		name             string
+		existingVerified bool
+		identityVerified bool
+	}{
+		{"existing unverified", false, true},
+		{"new unverified", true, false},
+		{"both unverified", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeAuthStore()
+			existing := store.addUser(domain.UserAuthRoleBasic)
+			store.addAccount(existing.ID, "google", "g-1", "runner@example.com", tt.existingVerified)
+
+			user := signInAs(t, store.api(), "fake", &provider.Identity{
+				ID:            "f-1",
+				Email:         "runner@example.com",
+				EmailVerified: tt.identityVerified,
+				Username:      "runner",
+			}, nil)
+
+			if user.ID == existing.ID {
+				t.Fatal("an unverified email took over the existing user")
+			}
+
+			if user.AuthRole != domain.UserAuthRoleBasic || user.Name != "runner" {
+				t.Fatalf("unexpected new user %+v", user)
+			}
+
+			if acct := store.accountOf(t, "fake", "f-1"); acct.UserID != user.ID {
+				t.Fatalf("expected the account on %s, got %s", user.ID, acct.UserID)
+			}
+		})
+	}
+}
+
+func TestFindOrCreateUserMergesGuest(t *testing.T) {
+	store := newFakeAuthStore()
+	existing := store.addUser(domain.UserAuthRoleBasic)
+	store.addAccount(existing.ID, "google", "g-1", "runner@example.com", true)
+	guest := store.addUser(domain.UserAuthRoleGuest)
+	store.sessions[guest.ID] = []*domain.Session{{ID: "s-1"}, {ID: "s-2"}}
+
+	user := signInAs(t, store.api(), "fake", &provider.Identity{
+		ID:            "f-1",
+		Email:         "runner@example.com",
+		EmailVerified: true,
+		Username:      "runner",
+	}, guest)
+
+	if user.ID != existing.ID {
+		t.Fatalf("expected the guest to end up as %s, got %s", existing.ID, user.ID)
+	}
+
+	if store.merged[guest.ID] != existing.ID {
+		t.Fatalf("expected the guest to be merged into %s, got %+v", existing.ID, store.merged)
+	}
+
+	if len(store.forgotten) != 1 || store.forgotten[0] != guest.ID {
+		t.Fatalf("expected the guest's location to be dropped, got %+v", store.forgotten)
+	}
+
+	if len(store.sessions[guest.ID]) != 0 {
+		t.Fatalf("expected the guest's sessions to be deleted, %d left", len(store.sessions[guest.ID]))
+	}
+
+	if acct := store.accountOf(t, "fake", "f-1"); acct.UserID != existing.ID {
+		t.Fatalf("expected the account on %s, got %s", existing.ID, acct.UserID)
+	}
+}
+
+func TestFindOrCreateUserMergesGuestIntoExistingAccount(t *testing.T) {
+	store := newFakeAuthStore()
+	existing := store.addUser(domain.UserAuthRoleBasic)
+	store.addAccount(existing.ID, "fake", "f-1", "runner@example.com", false)
+	guest := store.addUser(domain.UserAuthRoleGuest)
+
+	user := signInAs(t, store.api(), "fake", &provider.Identity{
+		ID:       "f-1",
+		Username: "runner",
+	}, guest)
+
+	if user.ID != existing.ID || store.merged[guest.ID] != existing.ID {
+		t.Fatalf("expected the guest to be merged into %s, got user %s and %+v", existing.ID, user.ID, store.merged)
+	}
+}
+
+func TestFindOrCreateUserUpgradesGuest(t *testing.T) {
+	store := newFakeAuthStore()
+	existing := store.addUser(domain.UserAuthRoleBasic)
+	store.addAccount(existing.ID, "google", "g-1", "runner@example.com", false)
+	guest := store.addUser(domain.UserAuthRoleGuest)
+
+	user := signInAs(t, store.api(), "fake", &provider.Identity{
+		ID:            "f-1",
+		Email:         "runner@example.com",
+		EmailVerified: true,
+		Username:      "runner",
+		DisplayName:   "Runner",
+	}, guest)
+
+	if user.ID != guest.ID {
+		t.Fatalf("expected the guest to keep their user, got %s", user.ID)
+	}
+
+	if len(store.merged) != 0 {
+		t.Fatalf("nothing should've been merged, got %+v", store.merged)
+	}
+
+	if stored := store.users[guest.ID]; stored.IsGuest() || stored.Name != "runner" || stored.DisplayName != "Runner" {
+		t.Fatalf("expected the guest to become a normal user, got %+v", stored)
+	}
+
+	if acct := store.accountOf(t, "fake", "f-1"); acct.UserID != guest.ID {
+		t.Fatalf("expected the account on %s, got %s", guest.ID, acct.UserID)
+	}
+}
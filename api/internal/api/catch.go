@@ -0,0 +1,236 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/peonii/inertia/internal/domain"
+	"github.com/peonii/inertia/internal/repository"
+)
+
+func (a *api) catchesByGameIDHandler(w http.ResponseWriter, r *http.Request) {
+	gid := chi.URLParam(r, "id")
+
+	if _, ok := a.authorizeGame(w, r, gid, domain.GamePermissionView); !ok {
+		return
+	}
+
+	catches, err := a.catchRepo.FindByGameID(r.Context(), gid)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find catches")
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, catches)
+}
+
+func (a *api) confirmCatchHandler(w http.ResponseWriter, r *http.Request) {
+	a.resolveCatch(w, r, domain.CatchStatusAccepted)
+}
+
+func (a *api) rejectCatchHandler(w http.ResponseWriter, r *http.Request) {
+	a.resolveCatch(w, r, domain.CatchStatusRejected)
+}
+
+// Pending catches can be resolved by the caught team, the host or a referee
+func (a *api) resolveCatch(w http.ResponseWriter, r *http.Request, status string) {
+	uid := a.session(r)
+	cid := chi.URLParam(r, "id")
+
+	catch, err := a.catchRepo.FindOne(r.Context(), cid)
+	if err != nil {
+		a.sendError(w, r, http.StatusNotFound, err, "failed to find catch")
+		return
+	}
+
+	access, ok := a.authorizeGame(w, r, catch.GameID, domain.GamePermissionView)
+	if !ok {
+		return
+	}
+	game := access.Game
+
+	runner, err := a.teamRepo.FindOne(r.Context(), catch.RunnerID)
+	if err != nil {
+		a.sendError(w, r, http.StatusNotFound, err, "failed to find team")
+		return
+	}
+
+	catcher, err := a.teamRepo.FindOne(r.Context(), catch.CatcherID)
+	if err != nil {
+		a.sendError(w, r, http.StatusNotFound, err, "failed to find team")
+		return
+	}
+
+	if !access.Can(domain.GamePermissionReferee) && !access.CanPlayFor(runner.ID) {
+		a.sendError(w, r, http.StatusForbidden, nil, "only the caught team, the host or a referee can resolve a catch")
+		return
+	}
+
+	if !catch.IsPending() {
+		a.sendError(w, r, http.StatusConflict, nil, "catch has already been resolved")
+		return
+	}
+
+	if status == domain.CatchStatusAccepted && !game.IsRunning() {
+		a.sendError(w, r, http.StatusForbidden, nil, "game is not running")
+		return
+	}
+
+	// Comes back rejected if someone else caught the runners in the meantime
+	catch, err = a.catchRepo.Resolve(r.Context(), cid, status, uid)
+	if errors.Is(err, repository.ErrCatchNotPending) {
+		a.sendError(w, r, http.StatusConflict, err, "catch has already been resolved")
+		return
+	}
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to resolve catch")
+		return
+	}
+
+	if catch.Status == domain.CatchStatusRejected {
+		a.sendJson(w, http.StatusOK, catch)
+
+		a.notifyTeam(r.Context(), catcher.ID, domain.Notification{
+			Title:    "Catch rejected",
+			Body:     fmt.Sprintf("Your catch of the team %s was rejected", runner.Name),
+			Priority: 10,
+		})
+		return
+	}
+
+	a.publishCatch(r.Context(), catch)
+
+	a.sendJson(w, http.StatusOK, catch)
+
+	a.notifyCatch(r.Context(), catcher)
+}
+
+// Returns the closest distance in meters between members of the two
+// teams, only using locations sent in the last window. Returns nil if
+// either team has no recent locations.
+func (a *api) closestDistance(ctx context.Context, catcher, runner *domain.Team, window time.Duration) (*float64, error) {
+	catcherLocs, err := a.recentTeamLocations(ctx, catcher.ID, window)
+	if err != nil {
+		return nil, err
+	}
+
+	runnerLocs, err := a.recentTeamLocations(ctx, runner.ID, window)
+	if err != nil {
+		return nil, err
+	}
+
+	var closest *float64
+	for _, cl := range catcherLocs {
+		for _, rl := range runnerLocs {
+			d := cl.DistanceTo(rl)
+			if closest == nil || d < *closest {
+				closest = &d
+			}
+		}
+	}
+
+	return closest, nil
+}
+
+func (a *api) recentTeamLocations(ctx context.Context, teamID string, window time.Duration) ([]*domain.Location, error) {
+	members, err := a.teamRepo.FindMembers(ctx, teamID)
+	if err != nil {
+		return nil, err
+	}
+
+	locs := []*domain.Location{}
+	for _, member := range members {
+		loc, err := a.locationRepo.GetUserLatest(ctx, member.ID)
+		if err != nil {
+			continue // member hasn't sent a location yet
+		}
+
+		if time.Since(loc.CreatedAt) > window {
+			continue
+		}
+
+		locs = append(locs, loc)
+	}
+
+	return locs, nil
+}
+
+// Tells the game the catching team are the runners now,
+// the repository already swapped them with the catch
+func (a *api) publishCatch(ctx context.Context, catch *domain.Catch) {
+	a.WsHub.Publish(ctx, catch.GameID, domain.GameEventCatch, wsCatchMsg{
+		NewRunnerID: catch.CatcherID,
+		GameID:      catch.GameID,
+	})
+}
+
+// Tells everyone in the game except the new runners about the catch
+func (a *api) notifyCatch(ctx context.Context, team *domain.Team) {
+	members, err := a.teamRepo.FindMembers(ctx, team.ID)
+	if err != nil {
+		return
+	}
+
+	users, err := a.gameRepo.FindAllUsersIDs(ctx, team.GameID)
+	if err != nil {
+		return
+	}
+
+	// Remove all team members from notified users
+	for _, member := range members {
+		for i, user := range users {
+			if user == member.ID {
+				users = append(users[:i], users[i+1:]...)
+				break
+			}
+		}
+	}
+
+	devices, err := a.notifRepo.GetDevicesForUsers(ctx, users)
+	if err != nil {
+		return
+	}
+
+	for _, device := range devices {
+		notif := domain.Notification{
+			Title:    "Team caught",
+			Body:     fmt.Sprintf("The team %s just caught the runners!", team.Name),
+			Priority: 10,
+			DeviceID: device.ID,
+		}
+
+		if err := a.scheduleNotification(&notif); err != nil {
+			continue
+		}
+	}
+}
+
+func (a *api) notifyTeam(ctx context.Context, teamID string, notif domain.Notification) {
+	members, err := a.teamRepo.FindMembers(ctx, teamID)
+	if err != nil {
+		return
+	}
+
+	users := make([]string, 0, len(members))
+	for _, member := range members {
+		users = append(users, member.ID)
+	}
+
+	devices, err := a.notifRepo.GetDevicesForUsers(ctx, users)
+	if err != nil {
+		return
+	}
+
+	for _, device := range devices {
+		n := notif
+		n.DeviceID = device.ID
+
+		if err := a.scheduleNotification(&n); err != nil {
+			continue
+		}
+	}
+}
@@ -2,14 +2,30 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/peonii/inertia/internal/domain"
+	"go.uber.org/zap"
 )
 
+// Admins see every game, everyone else only the ones they're in
 func (a *api) allGamesHandler(w http.ResponseWriter, r *http.Request) {
-	games, err := a.gameRepo.FindAll(r.Context())
+	uid := a.session(r)
+
+	user, err := a.userRepo.FindOne(r.Context(), uid)
+	if err != nil {
+		a.sendError(w, r, http.StatusNotFound, err, "failed to find user")
+		return
+	}
+
+	var games []*domain.Game
+	if user.AuthRole == domain.UserAuthRoleAdmin {
+		games, err = a.gameRepo.FindAll(r.Context())
+	} else {
+		games, err = a.gameRepo.FindAllForUser(r.Context(), uid)
+	}
 	if err != nil {
 		a.sendError(w, r, http.StatusNotFound, err, "failed to find games")
 		return
@@ -31,31 +47,22 @@ func (a *api) hostedGamesHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *api) gameByIdHandler(w http.ResponseWriter, r *http.Request) {
-	uid := chi.URLParam(r, "id")
-
-	game, err := a.gameRepo.FindOne(r.Context(), uid)
-	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find game")
+	access, ok := a.authorizeGame(w, r, chi.URLParam(r, "id"), domain.GamePermissionView)
+	if !ok {
 		return
 	}
 
-	a.sendJson(w, http.StatusOK, game)
+	a.sendJson(w, http.StatusOK, access.Game)
 }
 
 func (a *api) updateGameHandler(w http.ResponseWriter, r *http.Request) {
-	uid := a.session(r)
 	gid := chi.URLParam(r, "id")
 
-	game, err := a.gameRepo.FindOne(r.Context(), gid)
-	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find game")
-		return
-	}
-
-	if game.HostID != uid {
-		a.sendError(w, r, http.StatusForbidden, nil, "cannot edit someone else's game")
+	access, ok := a.authorizeGame(w, r, gid, domain.GamePermissionManage)
+	if !ok {
 		return
 	}
+	game := access.Game
 
 	var gameu domain.GameUpdate
 	if err := json.NewDecoder(r.Body).Decode(&gameu); err != nil {
@@ -63,7 +70,17 @@ func (a *api) updateGameHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	game, err = a.gameRepo.Update(r.Context(), gid, &gameu)
+	if gameu.Ranking != nil && !domain.IsValidGameRanking(*gameu.Ranking) {
+		a.sendError(w, r, http.StatusBadRequest, nil, "ranking must be 'xp', 'balance' or 'runner_time'")
+		return
+	}
+
+	if gameu.Ranking != nil && game.Status == domain.GameStatusFinished {
+		a.sendError(w, r, http.StatusConflict, nil, "cannot change the ranking of a finished game")
+		return
+	}
+
+	game, err := a.gameRepo.Update(r.Context(), gid, &gameu)
 	if err != nil {
 		a.sendError(w, r, http.StatusInternalServerError, err, "failed to update game")
 		return
@@ -72,18 +89,76 @@ func (a *api) updateGameHandler(w http.ResponseWriter, r *http.Request) {
 	a.sendJson(w, http.StatusOK, game)
 }
 
-func (a *api) deleteGameHandler(w http.ResponseWriter, r *http.Request) {
-	uid := a.session(r)
+func (a *api) updateGameStatusHandler(w http.ResponseWriter, r *http.Request) {
 	gid := chi.URLParam(r, "id")
 
-	game, err := a.gameRepo.FindOne(r.Context(), gid)
+	access, ok := a.authorizeGame(w, r, gid, domain.GamePermissionManage)
+	if !ok {
+		return
+	}
+	game := access.Game
+
+	var body domain.GameStatusUpdate
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		a.sendError(w, r, http.StatusBadRequest, err, "failed to decode status")
+		return
+	}
+
+	if !game.CanTransition(body.Status) {
+		a.sendError(w, r, http.StatusConflict, nil, fmt.Sprintf("cannot move game from %s to %s", game.Status, body.Status))
+		return
+	}
+
+	from := game.Status
+	game, err := a.gameRepo.UpdateStatus(r.Context(), gid, from, body.Status)
 	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find game")
+		a.sendError(w, r, http.StatusConflict, err, "game status changed in the meantime")
 		return
 	}
 
-	if game.HostID != uid {
-		a.sendError(w, r, http.StatusForbidden, nil, "cannot delete someone else's game")
+	a.WsHub.Publish(r.Context(), game.ID, domain.GameEventStatus, wsStatusMsg{
+		Game: game,
+		From: from,
+	})
+
+	if game.Status == domain.GameStatusFinished {
+		// The status worker settles it later if this fails,
+		// so it doesn't fail the request
+		if _, err := a.gameResultRepo.Settle(r.Context(), game); err != nil {
+			a.logger.Error("failed to settle game", zap.String("game_id", game.ID), zap.Error(err))
+		}
+	}
+
+	a.sendJson(w, http.StatusOK, game)
+}
+
+func (a *api) gameResultsHandler(w http.ResponseWriter, r *http.Request) {
+	access, ok := a.authorizeGame(w, r, chi.URLParam(r, "id"), domain.GamePermissionView)
+	if !ok {
+		return
+	}
+	game := access.Game
+
+	if game.Status != domain.GameStatusFinished {
+		a.sendError(w, r, http.StatusConflict, nil, "game has not finished yet")
+		return
+	}
+
+	// Settled when the game finished, or by the status worker
+	// if that failed, so these can still be missing for a bit
+	results, err := a.gameResultRepo.FindByGameID(r.Context(), game.ID)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find results")
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, results)
+}
+
+func (a *api) deleteGameHandler(w http.ResponseWriter, r *http.Request) {
+	gid := chi.URLParam(r, "id")
+
+	if _, ok := a.authorizeGame(w, r, gid, domain.GamePermissionOwn); !ok {
 		return
 	}
 
@@ -92,7 +167,7 @@ func (a *api) deleteGameHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	a.sendJson(w, http.StatusNoContent, nil)
+	a.sendNoContent(w)
 }
 
 func (a *api) createGameHandler(w http.ResponseWriter, r *http.Request) {
@@ -106,6 +181,26 @@ func (a *api) createGameHandler(w http.ResponseWriter, r *http.Request) {
 
 	gamec.HostID = uid
 
+	user, err := a.userRepo.FindOne(r.Context(), uid)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find user")
+		return
+	}
+
+	if !user.CanHost() {
+		a.sendError(w, r, http.StatusForbidden, nil, "guests can't host games")
+		return
+	}
+
+	if gamec.Ranking == "" {
+		gamec.Ranking = domain.GameRankingXP
+	}
+
+	if !domain.IsValidGameRanking(gamec.Ranking) {
+		a.sendError(w, r, http.StatusBadRequest, nil, "ranking must be 'xp', 'balance' or 'runner_time'")
+		return
+	}
+
 	game, err := a.gameRepo.Create(r.Context(), &gamec)
 	if err != nil {
 		a.sendError(w, r, http.StatusInternalServerError, err, "failed to create game")
@@ -116,17 +211,9 @@ func (a *api) createGameHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *api) generateMainQuestsHandler(w http.ResponseWriter, r *http.Request) {
-	uid := a.session(r)
 	gid := chi.URLParam(r, "id")
 
-	game, err := a.gameRepo.FindOne(r.Context(), gid)
-	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find game")
-		return
-	}
-
-	if game.HostID != uid {
-		a.sendError(w, r, http.StatusForbidden, nil, "cannot generate quests for someone else's game")
+	if _, ok := a.authorizeGame(w, r, gid, domain.GamePermissionManage); !ok {
 		return
 	}
 
@@ -135,27 +222,32 @@ func (a *api) generateMainQuestsHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	a.sendJson(w, http.StatusNoContent, nil)
+	a.sendNoContent(w)
 }
 
 func (a *api) createGameInvite(w http.ResponseWriter, r *http.Request) {
-	uid := a.session(r)
 	gid := chi.URLParam(r, "id")
 
-	game, err := a.gameRepo.FindOne(r.Context(), gid)
-	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find game")
+	if _, ok := a.authorizeGame(w, r, gid, domain.GamePermissionManage); !ok {
 		return
 	}
 
-	if game.HostID != uid {
-		a.sendError(w, r, http.StatusForbidden, nil, "cannot invite to someone else's game")
-		return
+	invite := domain.GameInviteCreate{
+		Kind: domain.GameInviteKindPlayer,
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&invite); err != nil {
+			a.sendError(w, r, http.StatusBadRequest, err, "failed to decode invite")
+			return
+		}
 	}
-
-	var invite domain.GameInviteCreate
 	invite.GameID = gid
 
+	if !domain.IsValidGameInviteKind(invite.Kind) {
+		a.sendError(w, r, http.StatusBadRequest, nil, "invite kind must be player or spectator")
+		return
+	}
+
 	inv, err := a.gameInviteRepo.Create(r.Context(), &invite)
 	if err != nil {
 		a.sendError(w, r, http.StatusInternalServerError, err, "failed to create invite")
@@ -166,24 +258,51 @@ func (a *api) createGameInvite(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *api) purgeActiveQuestsHandler(w http.ResponseWriter, r *http.Request) {
-	uid := a.session(r)
 	gid := chi.URLParam(r, "id")
 
-	game, err := a.gameRepo.FindOne(r.Context(), gid)
-	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find game")
+	if _, ok := a.authorizeGame(w, r, gid, domain.GamePermissionManage); !ok {
 		return
 	}
 
-	if game.HostID != uid {
-		a.sendError(w, r, http.StatusForbidden, nil, "cannot purge someone else's game")
+	if err := a.questRepo.PurgeAllActive(r.Context(), gid); err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to purge active quests")
 		return
 	}
 
-	if err := a.questRepo.PurgeAllActive(r.Context(), gid); err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to purge active quests")
+	a.sendNoContent(w)
+}
+
+func (a *api) updatePlayAreaHandler(w http.ResponseWriter, r *http.Request) {
+	var area domain.GeoJSONPolygon
+	if err := json.NewDecoder(r.Body).Decode(&area); err != nil {
+		a.sendError(w, r, http.StatusBadRequest, err, "failed to decode play area")
+		return
+	}
+
+	if err := area.Validate(); err != nil {
+		a.sendError(w, r, http.StatusBadRequest, err, err.Error())
 		return
 	}
 
-	a.sendJson(w, http.StatusNoContent, nil)
+	a.setPlayArea(w, r, &area)
+}
+
+func (a *api) deletePlayAreaHandler(w http.ResponseWriter, r *http.Request) {
+	a.setPlayArea(w, r, nil)
+}
+
+func (a *api) setPlayArea(w http.ResponseWriter, r *http.Request, area *domain.GeoJSONPolygon) {
+	gid := chi.URLParam(r, "id")
+
+	if _, ok := a.authorizeGame(w, r, gid, domain.GamePermissionManage); !ok {
+		return
+	}
+
+	game, err := a.gameRepo.UpdatePlayArea(r.Context(), gid, area)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to update play area")
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, game)
 }
@@ -1,10 +1,17 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/peonii/inertia/internal/domain"
+	"github.com/peonii/inertia/internal/repository"
+	"go.uber.org/zap"
 )
 
 type LocationPayload struct {
@@ -22,23 +29,188 @@ func (a *api) updateLocationHandler(w http.ResponseWriter, r *http.Request) {
 
 	loc.Location.UserID = uid
 
-	_, err := a.teamRepo.FindByGameUser(r.Context(), loc.GameID, uid)
+	access, ok := a.authorizeGame(w, r, loc.GameID, domain.GamePermissionPlay)
+	if !ok {
+		return
+	}
+
+	if access.Team == nil {
+		a.sendError(w, r, http.StatusNotFound, nil, "failed to find team")
+		return
+	}
+
+	if _, err := a.shareLocation(r.Context(), loc.GameID, &loc.Location); err != nil {
+		var rejection *domain.LocationRejection
+		if errors.As(err, &rejection) {
+			a.sendError(w, r, http.StatusUnprocessableEntity, err, rejection.Error())
+			return
+		}
+
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to update location")
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, loc)
+}
+
+// Saves the location and sends it to the rest of the game.
+// Returns the seq of the location event, or a *domain.LocationRejection
+// if the location doesn't look right.
+func (a *api) shareLocation(ctx context.Context, gameID string, loc *domain.LocationCreate) (int64, error) {
+	game, err := a.gameRepo.FindOne(ctx, gameID)
 	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find team")
+		return 0, err
+	}
+
+	// Nothing to compare against on the first location
+	prev, _ := a.locationRepo.GetUserLatest(ctx, loc.UserID)
+
+	if rejection := domain.CheckLocation(game, prev, loc, time.Now()); rejection != nil {
+		if rejection.Suspicious {
+			a.reportSuspicious(ctx, gameID, loc, rejection)
+		}
+
+		return 0, rejection
+	}
+
+	if err := a.locationRepo.Store(ctx, loc); err != nil {
+		return 0, err
+	}
+
+	seq := a.WsHub.Publish(ctx, gameID, domain.GameEventLocation, wsLocationMsg{
+		GameID:   gameID,
+		Location: *loc,
+		UserID:   loc.UserID,
+	})
+
+	a.checkBounds(ctx, game, prev, loc)
+
+	return seq, nil
+}
+
+// Tells the player and the host when the player leaves the play area
+// and applies the game's penalty. Only leaving counts, so staying
+// out doesn't pile up fines.
+func (a *api) checkBounds(ctx context.Context, game *domain.Game, prev *domain.Location, loc *domain.LocationCreate) {
+	if game.PlayArea == nil || !game.IsRunning() {
 		return
 	}
 
-	err = a.locationRepo.Store(r.Context(), &loc.Location)
+	if prev != nil && !game.InPlayArea(prev) {
+		return // already out
+	}
+
+	if game.InPlayArea(&domain.Location{Lat: loc.Lat, Lng: loc.Lng}) {
+		return
+	}
+
+	rules, err := a.gameRulesRepo.FindByGameID(ctx, game.ID)
 	if err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to update location")
+		a.logger.Error("failed to find game rules", zap.String("game_id", game.ID), zap.Error(err))
+		return
+	}
+
+	team, err := a.teamRepo.FindByGameUser(ctx, game.ID, loc.UserID)
+	if err != nil {
+		a.logger.Error("failed to find team", zap.String("user_id", loc.UserID), zap.Error(err))
 		return
 	}
 
-	a.WsHub.BroadcastLoc <- wsLocationMsg{
-		GameID:   loc.GameID,
-		Location: loc.Location,
-		UserID:   uid,
+	user, err := a.userRepo.FindOne(ctx, loc.UserID)
+	if err != nil {
+		a.logger.Error("failed to find user", zap.String("user_id", loc.UserID), zap.Error(err))
+		return
 	}
 
-	a.sendJson(w, http.StatusOK, loc)
+	// Fines don't go below zero
+	fine := 0
+	if rules.OutOfBoundsPenalty == domain.OutOfBoundsPenaltyFine {
+		fine = min(rules.OutOfBoundsFine, team.Balance)
+	}
+
+	if fine > 0 {
+		_, err := a.teamTransactionRepo.Apply(ctx, &domain.TeamTransactionCreate{
+			TeamID: team.ID,
+			Kind:   domain.TeamTransactionOutOfBounds,
+			Amount: -fine,
+			Note:   fmt.Sprintf("%s left the play area", user.DisplayName),
+			UserID: user.ID,
+		})
+		if err != nil {
+			a.logger.Error("failed to fine team", zap.String("team_id", team.ID), zap.Error(err))
+			fine = 0
+		}
+	}
+
+	a.WsHub.Publish(ctx, game.ID, domain.GameEventOutOfBounds, wsOutOfBoundsMsg{
+		Location: *loc,
+		UserID:   user.ID,
+		GameID:   game.ID,
+		Penalty:  rules.OutOfBoundsPenalty,
+		Fine:     fine,
+	})
+
+	body := "Get back in before the host notices!"
+	switch {
+	case fine > 0:
+		body = fmt.Sprintf("Your team was fined %d", fine)
+	case rules.OutOfBoundsPenalty == domain.OutOfBoundsPenaltyReveal:
+		body = "Your location was revealed to everyone"
+	}
+	a.notifyUser(ctx, user.ID, "You left the play area", body)
+
+	if game.HostID != user.ID {
+		a.notifyUser(ctx, game.HostID, "Player out of bounds",
+			fmt.Sprintf("%s from team %s left the play area", user.DisplayName, team.Name))
+	}
+}
+
+func (a *api) notifyUser(ctx context.Context, userID, title, body string) {
+	devices, err := a.notifRepo.GetDevicesForUser(ctx, userID)
+	if err != nil {
+		return
+	}
+
+	for _, device := range devices {
+		notif := domain.Notification{
+			Title:    title,
+			Body:     body,
+			Priority: 10,
+			DeviceID: device.ID,
+		}
+
+		if err := a.scheduleNotification(&notif); err != nil {
+			continue
+		}
+	}
+}
+
+func (a *api) reportSuspicious(ctx context.Context, gameID string, loc *domain.LocationCreate, rejection *domain.LocationRejection) {
+	_, err := a.suspiciousActivityRepo.Create(ctx, &domain.SuspiciousActivityCreate{
+		GameID: gameID,
+		UserID: loc.UserID,
+		Kind:   rejection.Reason,
+		Detail: rejection.Detail,
+		Lat:    loc.Lat,
+		Lng:    loc.Lng,
+	})
+	if err != nil && !errors.Is(err, repository.ErrSuspiciousActivityThrottled) {
+		a.logger.Error("failed to log suspicious activity", zap.String("user_id", loc.UserID), zap.Error(err))
+	}
+}
+
+func (a *api) suspiciousActivityHandler(w http.ResponseWriter, r *http.Request) {
+	gid := chi.URLParam(r, "id")
+
+	if _, ok := a.authorizeGame(w, r, gid, domain.GamePermissionReferee); !ok {
+		return
+	}
+
+	activity, err := a.suspiciousActivityRepo.FindByGameID(r.Context(), gid, r.URL.Query().Get("user_id"))
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find suspicious activity")
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, activity)
 }
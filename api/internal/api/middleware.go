@@ -3,10 +3,9 @@ package api
 import (
 	"context"
 	"net/http"
-	"os"
 	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/peonii/inertia/internal/domain"
 	"go.uber.org/zap"
 )
 
@@ -24,7 +23,10 @@ func (a *api) loggingMiddleware(next http.Handler) http.Handler {
 
 type UserIDContextKey string
 
-const UserIDKey UserIDContextKey = "uid"
+const (
+	UserIDKey      UserIDContextKey = "uid"
+	AccessTokenKey UserIDContextKey = "tok"
+)
 
 func (a *api) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -41,25 +43,24 @@ func (a *api) authMiddleware(next http.Handler) http.Handler {
 
 		token = strings.TrimPrefix(token, "Bearer ")
 
-		jt, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+		jt, err := a.authenticate(r.Context(), token)
 		if err != nil {
-			a.sendError(w, r, http.StatusUnauthorized, err, "failed to parse jwt")
+			a.sendError(w, r, http.StatusUnauthorized, err, "invalid token")
+			return
 		}
 
-		if !jt.Valid {
-			a.sendError(w, r, http.StatusUnauthorized, nil, "invalid jwt")
+		// Tokens given to other apps only get at what the user allowed
+		scope := domain.RequiredScope(r.Method, strings.TrimPrefix(r.URL.Path, "/api/v5"))
+		if !jt.Allows(scope) {
+			a.sendError(w, r, http.StatusForbidden, nil, "token is missing the "+scope+" scope")
 			return
 		}
 
-		uid, err := jt.Claims.GetSubject()
-		if err != nil {
-			a.sendError(w, r, http.StatusUnauthorized, err, "failed to get subject from jwt")
-		}
+		uid := jt.Subject
 
 		ctx := r.Context()
 		ctx = context.WithValue(ctx, UserIDKey, uid)
+		ctx = context.WithValue(ctx, AccessTokenKey, jt)
 
 		a.logger.Info("authenticated user",
 			zap.String("uid", uid),
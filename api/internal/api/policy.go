@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/peonii/inertia/internal/domain"
+)
+
+// Works out who the user is in the game. Everything that reads or
+// changes a game's data goes through this, so the rules for who can
+// do what live in domain.GameAccess and nowhere else.
+func (a *api) gameAccess(ctx context.Context, game *domain.Game, user *domain.User) (*domain.GameAccess, error) {
+	access := &domain.GameAccess{
+		Game: game,
+		User: user,
+	}
+
+	// The host can be playing too, so the team is looked up either way
+	team, err := a.teamRepo.FindByGameUser(ctx, game.ID, user.ID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	if err == nil {
+		access.Team = team
+		access.Elevate(domain.GameRolePlayer)
+	}
+
+	role, err := a.gameRoleRepo.FindOne(ctx, game.ID, user.ID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	if err == nil {
+		access.Elevate(role.Role)
+	}
+
+	if game.HostID == user.ID {
+		access.Elevate(domain.GameRoleHost)
+	}
+
+	return access, nil
+}
+
+// An invite for the game lets its holder look at it before joining,
+// it doesn't make them a player until they actually join a team
+func (a *api) useInvite(ctx context.Context, access *domain.GameAccess, code string) (*domain.GameInvite, error) {
+	invite, err := a.gameInviteRepo.FindBySlug(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if invite.GameID != access.Game.ID {
+		return nil, pgx.ErrNoRows
+	}
+
+	if invite.IsSpectator() {
+		access.Elevate(domain.GameRoleSpectator)
+	} else {
+		access.Elevate(domain.GameRolePlayer)
+	}
+
+	return invite, nil
+}
+
+// Loads the user, the game and who they are in it, without
+// checking anything yet
+func (a *api) loadGameAccess(w http.ResponseWriter, r *http.Request, gameID string) (*domain.GameAccess, bool) {
+	uid := a.session(r)
+
+	// Guests only get to see the game they were invited to
+	if !a.accessToken(r).AllowsGame(gameID) {
+		a.sendError(w, r, http.StatusNotFound, nil, "failed to find game")
+		return nil, false
+	}
+
+	user, err := a.userRepo.FindOne(r.Context(), uid)
+	if err != nil {
+		a.sendError(w, r, http.StatusNotFound, err, "failed to find user")
+		return nil, false
+	}
+
+	game, err := a.gameRepo.FindOne(r.Context(), gameID)
+	if err != nil {
+		a.sendError(w, r, http.StatusNotFound, err, "failed to find game")
+		return nil, false
+	}
+
+	access, err := a.gameAccess(r.Context(), game, user)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to check game access")
+		return nil, false
+	}
+
+	return access, true
+}
+
+// Loads the user and the game and makes sure the user can do what
+// the handler is about to do. Sends the error itself, so handlers
+// just return when it fails.
+func (a *api) authorizeGame(w http.ResponseWriter, r *http.Request, gameID string, permission domain.GamePermission) (*domain.GameAccess, bool) {
+	access, ok := a.loadGameAccess(w, r, gameID)
+	if !ok {
+		return nil, false
+	}
+
+	// Someone opening an invite link hasn't joined yet
+	if code := r.URL.Query().Get("invite"); code != "" && !access.Can(permission) {
+		if _, err := a.useInvite(r.Context(), access, code); err != nil {
+			a.sendError(w, r, http.StatusNotFound, err, "failed to find invite")
+			return nil, false
+		}
+	}
+
+	if !access.Can(permission) {
+		// Not telling outsiders the game exists
+		if !access.Can(domain.GamePermissionView) {
+			a.sendError(w, r, http.StatusNotFound, nil, "failed to find game")
+			return nil, false
+		}
+
+		a.sendError(w, r, http.StatusForbidden, nil, "not allowed in this game")
+		return nil, false
+	}
+
+	return access, true
+}
+
+// Same as authorizeGame, for routes that only have a team
+func (a *api) authorizeTeam(w http.ResponseWriter, r *http.Request, teamID string, permission domain.GamePermission) (*domain.GameAccess, *domain.Team, bool) {
+	team, err := a.teamRepo.FindOne(r.Context(), teamID)
+	if err != nil {
+		a.sendError(w, r, http.StatusNotFound, err, "failed to find team")
+		return nil, nil, false
+	}
+
+	access, ok := a.authorizeGame(w, r, team.GameID, permission)
+	if !ok {
+		return nil, nil, false
+	}
+
+	return access, team, true
+}
+
+// For actions a team takes, only its own players can
+func (a *api) authorizePlayer(w http.ResponseWriter, r *http.Request, teamID string) (*domain.GameAccess, *domain.Team, bool) {
+	access, team, ok := a.authorizeTeam(w, r, teamID, domain.GamePermissionPlay)
+	if !ok {
+		return nil, nil, false
+	}
+
+	if !access.InTeam(team.ID) {
+		a.sendError(w, r, http.StatusForbidden, nil, "user is not a member of the team")
+		return nil, nil, false
+	}
+
+	return access, team, true
+}
@@ -1,17 +1,26 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/peonii/inertia/internal/domain"
+	"github.com/peonii/inertia/internal/repository"
+	"go.uber.org/zap"
 )
 
 func (a *api) getPowerupsForGameHandler(w http.ResponseWriter, r *http.Request) {
 	gameID := chi.URLParam(r, "id")
 
+	if _, ok := a.authorizeGame(w, r, gameID, domain.GamePermissionView); !ok {
+		return
+	}
+
 	powerups, err := a.powerupRepo.GetActiveByGameID(r.Context(), gameID)
 	if err != nil {
 		a.sendError(w, r, http.StatusNotFound, err, "failed to find powerups")
@@ -30,70 +39,98 @@ func (a *api) usePowerupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	team, err := a.teamRepo.FindOne(r.Context(), body.CasterID)
-	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find team")
+	access, team, ok := a.authorizePlayer(w, r, body.CasterID)
+	if !ok {
+		return
+	}
+	game := access.Game
+
+	if !game.IsRunning() {
+		a.sendError(w, r, http.StatusForbidden, nil, "game is not running")
 		return
 	}
 
-	user, err := a.userRepo.FindOne(r.Context(), uid)
+	freeze, err := a.findFreeze(r.Context(), team)
 	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find user")
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to check powerups")
 		return
 	}
 
-	isMember, err := a.teamRepo.IsTeamMember(r.Context(), team, user)
+	if freeze != nil {
+		a.sendFrozen(w, r, freeze)
+		return
+	}
+
+	rules, err := a.gameRulesRepo.FindByGameID(r.Context(), game.ID)
 	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to check team membership")
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find game rules")
+		return
+	}
+
+	rule, ok := rules.Powerup(body.Type)
+	if !ok {
+		a.sendError(w, r, http.StatusBadRequest, nil, "powerup is not available in this game")
 		return
 	}
 
-	if !isMember {
-		a.sendError(w, r, http.StatusUnauthorized, nil, "user is not a member of the team")
+	// Runners and hunters each have their own set of powerups
+	if domain.IsRunnerPowerup(body.Type) != team.IsRunner {
+		a.sendError(w, r, http.StatusForbidden, nil, "team can't use this powerup")
 		return
 	}
 
-	cost := 0
 	switch body.Type {
 	case domain.PowerupTypeBlacklist:
-		cost = 600
-	case domain.PowerupTypeFreezeHunters:
-		cost = 1000
-	case domain.PowerupTypeFreezeRunners:
-		cost = 1000
+		if _, ok := rules.TicketPrice(body.Target); !ok {
+			a.sendError(w, r, http.StatusBadRequest, nil, "blacklist target must be a ticket type")
+			return
+		}
 	case domain.PowerupTypeHunt:
-		cost = 200
-	case domain.PowerupTypeHideTracker:
-		cost = 500
-	case domain.PowerupTypeRevealHunters:
-		cost = 400
-	}
+		teams, err := a.teamRepo.FindByGameID(r.Context(), game.ID)
+		if err != nil {
+			a.sendError(w, r, http.StatusInternalServerError, err, "failed to find teams")
+			return
+		}
 
-	if team.Balance < cost {
-		a.sendError(w, r, http.StatusUnauthorized, nil, "team does not have enough balance")
-		return
-	}
+		hasRunner := false
+		for _, t := range teams {
+			if t.IsRunner {
+				hasRunner = true
+				break
+			}
+		}
+
+		if !hasRunner {
+			a.sendError(w, r, http.StatusBadRequest, nil, "there are no runners to hunt")
+			return
+		}
 
-	newBalance := team.Balance - cost
-	tu := domain.TeamUpdate{
-		Balance: &newBalance,
+	default:
+		body.Target = ""
 	}
 
-	team, err = a.teamRepo.Update(r.Context(), body.CasterID, &tu)
-	if err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to update team balance")
+	body.Duration = rule.Length()
+
+	pow, err := a.powerupRepo.Create(r.Context(), &body, &domain.TeamTransactionCreate{
+		TeamID:    team.ID,
+		Kind:      domain.TeamTransactionPowerupPurchase,
+		Amount:    -rule.Cost,
+		Reference: body.Type,
+		UserID:    uid,
+	})
+	if errors.Is(err, repository.ErrInsufficientBalance) {
+		a.sendError(w, r, http.StatusUnauthorized, err, "team does not have enough balance")
 		return
 	}
-
-	pow, err := a.powerupRepo.Create(r.Context(), &body)
 	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to create powerup")
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to create powerup")
 		return
 	}
 
-	a.WsHub.BroadcastPwp <- wsPowerupMsg{
+	a.WsHub.Publish(r.Context(), game.ID, domain.GameEventPowerup, wsPowerupMsg{
 		Powerup: pow,
-	}
+		GameID:  game.ID,
+	})
 
 	a.sendJson(w, http.StatusOK, nil)
 
@@ -135,3 +172,71 @@ func (a *api) usePowerupHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
+
+type frozenError struct {
+	Error       string    `json:"error"`
+	Code        int       `json:"code"`
+	FrozenUntil time.Time `json:"frozen_until"`
+	// In seconds
+	Remaining int `json:"remaining"`
+}
+
+// Returns the freeze affecting the team, or nil if it isn't frozen
+func (a *api) findFreeze(ctx context.Context, team *domain.Team) (*domain.Powerup, error) {
+	powerups, err := a.powerupRepo.GetActiveByGameID(ctx, team.GameID)
+	if err != nil {
+		return nil, err
+	}
+
+	return domain.ActiveFreeze(team, powerups), nil
+}
+
+func (a *api) sendFrozen(w http.ResponseWriter, r *http.Request, freeze *domain.Powerup) {
+	remaining := time.Until(freeze.EndsAt).Round(time.Second)
+	msg := fmt.Sprintf("team is frozen for another %s", remaining)
+
+	a.logger.Info(msg,
+		zap.String("powerup_id", freeze.ID),
+		zap.String("path", r.URL.Path),
+	)
+
+	a.sendJson(w, http.StatusForbidden, frozenError{
+		Error:       msg,
+		Code:        http.StatusForbidden,
+		FrozenUntil: freeze.EndsAt,
+		Remaining:   int(remaining.Seconds()),
+	})
+}
+
+// Fills in FrozenUntil for every team, fetching
+// active powerups once per game
+func (a *api) attachFreezes(ctx context.Context, teams []*domain.Team) error {
+	powerupsByGameID := make(map[string][]*domain.Powerup)
+
+	for _, team := range teams {
+		powerups, ok := powerupsByGameID[team.GameID]
+		if !ok {
+			var err error
+			powerups, err = a.powerupRepo.GetActiveByGameID(ctx, team.GameID)
+			if err != nil {
+				return err
+			}
+
+			powerupsByGameID[team.GameID] = powerups
+		}
+
+		team.ApplyFreeze(powerups)
+	}
+
+	return nil
+}
+
+// Returns the blacklist banning the ticket type for the team, or nil
+func (a *api) findBlacklist(ctx context.Context, team *domain.Team, ticketType string) (*domain.Powerup, error) {
+	powerups, err := a.powerupRepo.GetActiveByGameID(ctx, team.GameID)
+	if err != nil {
+		return nil, err
+	}
+
+	return domain.ActiveBlacklist(team, ticketType, powerups), nil
+}
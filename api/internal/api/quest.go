@@ -1,19 +1,33 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/peonii/inertia/internal/domain"
+	"github.com/peonii/inertia/internal/repository"
 	"go.uber.org/zap"
 )
 
+// Other teams' quests are only for the host and referees
 func (a *api) teamQuestsHandler(w http.ResponseWriter, r *http.Request) {
 	teamID := chi.URLParam(r, "id")
 
+	access, _, ok := a.authorizeTeam(w, r, teamID, domain.GamePermissionView)
+	if !ok {
+		return
+	}
+
+	if !access.CanSeeTeam(teamID) {
+		a.sendError(w, r, http.StatusForbidden, nil, "cannot see another team's quests")
+		return
+	}
+
 	quests, err := a.questRepo.FindActiveByTeamID(r.Context(), teamID)
 	if err != nil {
 		a.sendError(w, r, http.StatusNotFound, err, "failed to find quests")
@@ -32,27 +46,21 @@ func (a *api) questByIdHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, ok := a.authorizeGame(w, r, quest.GameID, domain.GamePermissionView); !ok {
+		return
+	}
+
 	a.sendJson(w, http.StatusOK, quest)
 }
 
 func (a *api) createQuestGroupHandler(w http.ResponseWriter, r *http.Request) {
-	uid := a.session(r)
-	u, err := a.userRepo.FindOne(r.Context(), uid)
-
 	var group domain.QuestGroupCreate
 	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
 		a.sendError(w, r, http.StatusBadRequest, err, "failed to decode quest group")
 		return
 	}
 
-	game, err := a.gameRepo.FindOne(r.Context(), group.GameID)
-	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find game")
-		return
-	}
-
-	if !game.CanEdit(u) {
-		a.sendError(w, r, http.StatusForbidden, nil, "cannot edit someone else's game")
+	if _, ok := a.authorizeGame(w, r, group.GameID, domain.GamePermissionManage); !ok {
 		return
 	}
 
@@ -66,9 +74,6 @@ func (a *api) createQuestGroupHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *api) createQuestHandler(w http.ResponseWriter, r *http.Request) {
-	uid := a.session(r)
-	u, err := a.userRepo.FindOne(r.Context(), uid)
-
 	var questc domain.QuestCreate
 	if err := json.NewDecoder(r.Body).Decode(&questc); err != nil {
 		a.sendError(w, r, http.StatusBadRequest, err, "failed to decode quest")
@@ -80,20 +85,18 @@ func (a *api) createQuestHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = a.questRepo.FindGroup(r.Context(), questc.GroupID)
-	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find quest group")
+	if questc.Radius < 0 {
+		a.sendError(w, r, http.StatusBadRequest, nil, "radius can't be negative")
 		return
 	}
 
-	game, err := a.gameRepo.FindOne(r.Context(), questc.GameID)
+	_, err := a.questRepo.FindGroup(r.Context(), questc.GroupID)
 	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find game")
+		a.sendError(w, r, http.StatusNotFound, err, "failed to find quest group")
 		return
 	}
 
-	if !game.CanEdit(u) {
-		a.sendError(w, r, http.StatusForbidden, nil, "you are not the host of this game")
+	if _, ok := a.authorizeGame(w, r, questc.GameID, domain.GamePermissionManage); !ok {
 		return
 	}
 
@@ -106,9 +109,71 @@ func (a *api) createQuestHandler(w http.ResponseWriter, r *http.Request) {
 	a.sendJson(w, http.StatusCreated, quest)
 }
 
+type questTooFarError struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+	// In meters, nil if nobody in the team sent a recent location
+	Distance *float64 `json:"distance"`
+	Radius   float64  `json:"radius"`
+}
+
+// activeID is the active quest's ID, like for questReward
+func (a *api) sendTooFar(w http.ResponseWriter, r *http.Request, quest *domain.ActiveQuestFull, activeID string, distance *float64) {
+	msg := "no recent location from your team, make sure location sharing is on"
+	if distance != nil {
+		msg = fmt.Sprintf("your team is %.0fm away from the quest, get within %.0fm to complete it", *distance, quest.Radius)
+	}
+
+	a.logger.Info(msg,
+		zap.String("active_quest_id", activeID),
+		zap.String("team_id", quest.TeamID),
+	)
+
+	a.sendJson(w, http.StatusForbidden, questTooFarError{
+		Error:    msg,
+		Code:     http.StatusForbidden,
+		Distance: distance,
+		Radius:   quest.Radius,
+	})
+}
+
+func (a *api) updateQuestGeofenceHandler(w http.ResponseWriter, r *http.Request) {
+	questID := chi.URLParam(r, "id")
+
+	var body domain.QuestGeofenceUpdate
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		a.sendError(w, r, http.StatusBadRequest, err, "failed to decode geofence")
+		return
+	}
+
+	if body.Radius < 0 {
+		a.sendError(w, r, http.StatusBadRequest, nil, "radius can't be negative")
+		return
+	}
+
+	quest, err := a.questRepo.FindOne(r.Context(), questID)
+	if err != nil {
+		a.sendError(w, r, http.StatusNotFound, err, "failed to find quest")
+		return
+	}
+
+	if _, ok := a.authorizeGame(w, r, quest.GameID, domain.GamePermissionManage); !ok {
+		return
+	}
+
+	quest.Radius = body.Radius
+	quest.HonourBased = body.HonourBased
+
+	if err := a.questRepo.Update(r.Context(), quest); err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to update quest")
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, quest)
+}
+
 func (a *api) completeQuestHandler(w http.ResponseWriter, r *http.Request) {
 	uid := a.session(r)
-	u, err := a.userRepo.FindOne(r.Context(), uid)
 
 	id := chi.URLParam(r, "id")
 	quest, err := a.questRepo.FindActive(r.Context(), id)
@@ -122,52 +187,104 @@ func (a *api) completeQuestHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	team, err := a.teamRepo.FindOne(r.Context(), quest.TeamID)
-	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find team")
+	if quest.Submitted {
+		a.sendError(w, r, http.StatusConflict, nil, "quest is waiting for the host's review")
 		return
 	}
 
-	isMember, err := a.teamRepo.IsTeamMember(r.Context(), team, u)
-	if err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to check team membership")
+	access, team, ok := a.authorizePlayer(w, r, quest.TeamID)
+	if !ok {
 		return
 	}
+	game := access.Game
 
-	if !isMember {
-		a.sendError(w, r, http.StatusForbidden, nil, "you are not a member of this team")
+	if !game.IsRunning() {
+		a.sendError(w, r, http.StatusForbidden, nil, "game is not running")
 		return
 	}
 
-	err = a.questRepo.Complete(r.Context(), id)
+	freeze, err := a.findFreeze(r.Context(), team)
 	if err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to complete quest")
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to check powerups")
 		return
 	}
 
-	newXp := team.XP + quest.XP
-	newBalance := team.Balance + quest.Money
+	if freeze != nil {
+		a.sendFrozen(w, r, freeze)
+		return
+	}
 
-	teamUpdate := domain.TeamUpdate{
-		XP:      &newXp,
-		Balance: &newBalance,
+	if quest.IsGeofenced() {
+		locs, err := a.recentTeamLocations(r.Context(), team.ID, domain.QuestLocationWindow)
+		if err != nil {
+			a.sendError(w, r, http.StatusInternalServerError, err, "failed to check team locations")
+			return
+		}
+
+		var closest *float64
+		for _, loc := range locs {
+			d := loc.DistanceTo(quest.Location())
+			if closest == nil || d < *closest {
+				closest = &d
+			}
+		}
+
+		if closest == nil || *closest > quest.Radius {
+			a.sendTooFar(w, r, quest, id, closest)
+			return
+		}
+	}
+
+	if quest.RequiresEvidence {
+		a.submitEvidence(w, r, team, quest, id)
+		return
+	}
+
+	err = a.questRepo.Complete(r.Context(), id, questReward(team, quest, id, uid))
+	if errors.Is(err, repository.ErrQuestNotActive) {
+		a.sendError(w, r, http.StatusBadRequest, err, "quest is already completed")
+		return
 	}
-	_, err = a.teamRepo.Update(r.Context(), team.ID, &teamUpdate)
 	if err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to update team")
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to complete quest")
 		return
 	}
 
 	a.sendJson(w, http.StatusOK, nil)
 
+	a.announceQuest(r.Context(), team, quest)
+}
+
+// What completing the quest pays, it goes through together with the completion.
+// activeID is the active quest's ID, FindActive fills quest.ID with the quest's own ID
+func questReward(team *domain.Team, quest *domain.ActiveQuestFull, activeID, uid string) *domain.TeamTransactionCreate {
+	return &domain.TeamTransactionCreate{
+		TeamID:    team.ID,
+		Kind:      domain.TeamTransactionQuestReward,
+		Amount:    quest.Money,
+		XP:        quest.XP,
+		Reference: activeID,
+		Note:      quest.Title,
+		UserID:    uid,
+	}
+}
+
+// Notifies the other teams and updates the members' stats
+func (a *api) announceQuest(ctx context.Context, team *domain.Team, quest *domain.ActiveQuestFull) {
+	a.WsHub.Publish(ctx, team.GameID, domain.GameEventQuest, wsQuestMsg{
+		Quest:  quest,
+		TeamID: team.ID,
+		GameID: team.GameID,
+	})
+
 	a.logger.Info("sending notif", zap.Any("quest", quest))
 
-	members, err := a.teamRepo.FindMembers(r.Context(), team.ID)
+	members, err := a.teamRepo.FindMembers(ctx, team.ID)
 	if err != nil {
 		return
 	}
 
-	users, err := a.gameRepo.FindAllUsersIDs(r.Context(), team.GameID)
+	users, err := a.gameRepo.FindAllUsersIDs(ctx, team.GameID)
 	if err != nil {
 		return
 	}
@@ -184,7 +301,7 @@ func (a *api) completeQuestHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	devices, err := a.notifRepo.GetDevicesForUsers(r.Context(), users)
+	devices, err := a.notifRepo.GetDevicesForUsers(ctx, users)
 	if err != nil {
 		return
 	}
@@ -203,7 +320,7 @@ func (a *api) completeQuestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, member := range members {
-		stats, err := a.userStatsRepo.Get(r.Context(), member.ID)
+		stats, err := a.userStatsRepo.Get(ctx, member.ID)
 		if err != nil {
 			continue
 		}
@@ -211,15 +328,12 @@ func (a *api) completeQuestHandler(w http.ResponseWriter, r *http.Request) {
 		stats.XP += int64(quest.XP)
 		stats.Quests += 1
 
-		a.userStatsRepo.Update(r.Context(), member.ID, stats)
+		a.userStatsRepo.Update(ctx, member.ID, stats)
 	}
 
 }
 
 func (a *api) vetoQuestHandler(w http.ResponseWriter, r *http.Request) {
-	uid := a.session(r)
-	u, err := a.userRepo.FindOne(r.Context(), uid)
-
 	id := chi.URLParam(r, "id")
 	quest, err := a.questRepo.FindActive(r.Context(), id)
 	if err != nil {
@@ -232,30 +346,39 @@ func (a *api) vetoQuestHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	team, err := a.teamRepo.FindOne(r.Context(), quest.TeamID)
-	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find team")
+	if quest.Submitted {
+		a.sendError(w, r, http.StatusConflict, nil, "quest is waiting for the host's review")
 		return
 	}
 
-	isMember, err := a.teamRepo.IsTeamMember(r.Context(), team, u)
-	if err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to check team membership")
+	access, team, ok := a.authorizePlayer(w, r, quest.TeamID)
+	if !ok {
 		return
 	}
+	game := access.Game
 
-	if !isMember {
-		a.sendError(w, r, http.StatusForbidden, nil, "you are not a member of this team")
+	if !game.IsRunning() {
+		a.sendError(w, r, http.StatusForbidden, nil, "game is not running")
 		return
 	}
 
-	err = a.questRepo.Complete(r.Context(), id)
+	rules, err := a.gameRulesRepo.FindByGameID(r.Context(), game.ID)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find game rules")
+		return
+	}
+
+	err = a.questRepo.Complete(r.Context(), id, nil)
+	if errors.Is(err, repository.ErrQuestNotActive) {
+		a.sendError(w, r, http.StatusBadRequest, err, "quest is already completed")
+		return
+	}
 	if err != nil {
 		a.sendError(w, r, http.StatusInternalServerError, err, "failed to complete quest")
 		return
 	}
 
-	newVetoPeriodEnd := time.Now().Add(20 * time.Minute)
+	newVetoPeriodEnd := time.Now().Add(rules.VetoPeriod())
 	teamUpdate := domain.TeamUpdate{
 		VetoPeriodEnd: &newVetoPeriodEnd,
 	}
@@ -269,24 +392,16 @@ func (a *api) vetoQuestHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *api) generateNewSideQuestHandler(w http.ResponseWriter, r *http.Request) {
-	uid := a.session(r)
 	tid := chi.URLParam(r, "id")
-	u, err := a.userRepo.FindOne(r.Context(), uid)
 
-	team, err := a.teamRepo.FindOne(r.Context(), tid)
-	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find team")
-		return
-	}
-
-	isMember, err := a.teamRepo.IsTeamMember(r.Context(), team, u)
-	if err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to check team membership")
+	access, team, ok := a.authorizePlayer(w, r, tid)
+	if !ok {
 		return
 	}
+	game := access.Game
 
-	if !isMember {
-		a.sendError(w, r, http.StatusForbidden, nil, "you are not a member of this team")
+	if !game.IsRunning() {
+		a.sendError(w, r, http.StatusForbidden, nil, "game is not running")
 		return
 	}
 
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/peonii/inertia/internal/domain"
+)
+
+func (a *api) gameReplayHandler(w http.ResponseWriter, r *http.Request) {
+	gid := chi.URLParam(r, "id")
+
+	access, ok := a.authorizeGame(w, r, gid, domain.GamePermissionView)
+	if !ok {
+		return
+	}
+
+	teams, err := a.teamRepo.FindByGameID(r.Context(), gid)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find teams")
+		return
+	}
+
+	replay, ok := a.buildReplay(w, r, access, teams)
+	if !ok {
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, replay)
+}
+
+func (a *api) teamReplayGPXHandler(w http.ResponseWriter, r *http.Request) {
+	replay, ok := a.teamReplay(w, r)
+	if !ok {
+		return
+	}
+
+	out, err := replay.Teams[0].GPX(replay.From)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to encode gpx")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gpx+xml")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.gpx"`, replay.Teams[0].Team.ID))
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
+
+func (a *api) teamReplayGeoJSONHandler(w http.ResponseWriter, r *http.Request) {
+	replay, ok := a.teamReplay(w, r)
+	if !ok {
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, replay.Teams[0].GeoJSON())
+}
+
+func (a *api) teamReplay(w http.ResponseWriter, r *http.Request) (*domain.Replay, bool) {
+	access, team, ok := a.authorizeTeam(w, r, chi.URLParam(r, "id"), domain.GamePermissionView)
+	if !ok {
+		return nil, false
+	}
+
+	return a.buildReplay(w, r, access, []*domain.Team{team})
+}
+
+// Replays are open to everyone in the game once it's over, before
+// that only the host and referees get to see where everyone went
+func (a *api) buildReplay(w http.ResponseWriter, r *http.Request, access *domain.GameAccess, teams []*domain.Team) (*domain.Replay, bool) {
+	game := access.Game
+	if game.Status != domain.GameStatusFinished && !access.Can(domain.GamePermissionViewAll) {
+		a.sendError(w, r, http.StatusForbidden, nil, "game has not finished yet")
+		return nil, false
+	}
+
+	opts, err := replayOptions(r)
+	if err != nil {
+		a.sendError(w, r, http.StatusBadRequest, err, err.Error())
+		return nil, false
+	}
+
+	from, to := game.ReplayWindow()
+	replay := &domain.Replay{
+		GameID: game.ID,
+		From:   from,
+		To:     to,
+		Teams:  []*domain.ReplayTeam{},
+	}
+
+	userIDs := []string{}
+	tracks := map[string]*domain.Track{}
+	for _, team := range teams {
+		members, err := a.teamRepo.FindMembers(r.Context(), team.ID)
+		if err != nil {
+			a.sendError(w, r, http.StatusInternalServerError, err, "failed to find team members")
+			return nil, false
+		}
+
+		rt := &domain.ReplayTeam{
+			Team:   team,
+			Tracks: []*domain.Track{},
+		}
+
+		for _, member := range members {
+			track := &domain.Track{
+				User:   member,
+				Points: []*domain.Location{},
+			}
+
+			rt.Tracks = append(rt.Tracks, track)
+			tracks[member.ID] = track
+			userIDs = append(userIDs, member.ID)
+		}
+
+		replay.Teams = append(replay.Teams, rt)
+	}
+
+	if len(userIDs) == 0 || !to.After(from) {
+		return replay, true
+	}
+
+	locations, err := a.locationRepo.FindHistory(r.Context(), userIDs, from, to)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find locations")
+		return nil, false
+	}
+
+	for _, loc := range locations {
+		if track, ok := tracks[loc.UserID]; ok {
+			track.Points = append(track.Points, loc)
+		}
+	}
+
+	for _, track := range tracks {
+		track.Points = opts.Downsample(track.Points)
+	}
+
+	return replay, true
+}
+
+// ?interval= in seconds and ?tolerance= in meters
+func replayOptions(r *http.Request) (domain.ReplayOptions, error) {
+	var opts domain.ReplayOptions
+	query := r.URL.Query()
+
+	if v := query.Get("interval"); v != "" {
+		interval, err := strconv.Atoi(v)
+		if err != nil || interval < 0 || interval > domain.MaxReplayInterval {
+			return opts, fmt.Errorf("interval must be between 0 and %d seconds", domain.MaxReplayInterval)
+		}
+		opts.Interval = time.Duration(interval) * time.Second
+	}
+
+	if v := query.Get("tolerance"); v != "" {
+		tolerance, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(tolerance >= 0 && tolerance <= domain.MaxReplayTolerance) {
+			return opts, fmt.Errorf("tolerance must be between 0 and %d meters", domain.MaxReplayTolerance)
+		}
+		opts.Tolerance = tolerance
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/peonii/inertia/internal/domain"
+)
+
+type spectateRequest struct {
+	Invite string `json:"invite"`
+}
+
+// Keeps the spectator role around, so the invite doesn't
+// have to be sent with everything afterwards
+func (a *api) spectateGameHandler(w http.ResponseWriter, r *http.Request) {
+	gid := chi.URLParam(r, "id")
+
+	var body spectateRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		a.sendError(w, r, http.StatusBadRequest, err, "failed to decode body")
+		return
+	}
+
+	access, ok := a.loadGameAccess(w, r, gid)
+	if !ok {
+		return
+	}
+
+	if access.Role != "" {
+		a.sendError(w, r, http.StatusConflict, nil, "already in this game")
+		return
+	}
+
+	invite, err := a.useInvite(r.Context(), access, body.Invite)
+	if err != nil {
+		a.sendError(w, r, http.StatusUnauthorized, err, "failed to verify invite")
+		return
+	}
+
+	if !invite.IsSpectator() {
+		a.sendError(w, r, http.StatusForbidden, nil, "only spectator invites can be used to spectate")
+		return
+	}
+
+	role, err := a.gameRoleRepo.Grant(r.Context(), &domain.GameRole{
+		GameID: gid,
+		UserID: access.User.ID,
+		Role:   domain.GameRoleSpectator,
+	})
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to spectate game")
+		return
+	}
+
+	a.sendJson(w, http.StatusCreated, role)
+}
+
+func (a *api) gameRolesHandler(w http.ResponseWriter, r *http.Request) {
+	gid := chi.URLParam(r, "id")
+
+	if _, ok := a.authorizeGame(w, r, gid, domain.GamePermissionManage); !ok {
+		return
+	}
+
+	roles, err := a.gameRoleRepo.FindByGameID(r.Context(), gid)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find roles")
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, roles)
+}
+
+// The host hands out referees, who can do everything but set the
+// game up, and spectators, who can look but not play
+func (a *api) grantGameRoleHandler(w http.ResponseWriter, r *http.Request) {
+	gid := chi.URLParam(r, "id")
+	target := chi.URLParam(r, "userId")
+
+	var body domain.GameRoleGrant
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		a.sendError(w, r, http.StatusBadRequest, err, "failed to decode role")
+		return
+	}
+
+	if !domain.IsGrantableGameRole(body.Role) {
+		a.sendError(w, r, http.StatusBadRequest, nil, "role must be referee or spectator")
+		return
+	}
+
+	access, ok := a.authorizeGame(w, r, gid, domain.GamePermissionOwn)
+	if !ok {
+		return
+	}
+
+	if target == access.Game.HostID {
+		a.sendError(w, r, http.StatusConflict, nil, "the host already has every role")
+		return
+	}
+
+	user, err := a.userRepo.FindOne(r.Context(), target)
+	if err != nil {
+		a.sendError(w, r, http.StatusNotFound, err, "failed to find user")
+		return
+	}
+
+	if body.Role == domain.GameRoleReferee {
+		// Guests are anyone with an invite code, they don't get to judge
+		if user.IsGuest() {
+			a.sendError(w, r, http.StatusConflict, nil, "guests can't be referees")
+			return
+		}
+
+		// Referees would be judging their own team
+		targetAccess, err := a.gameAccess(r.Context(), access.Game, user)
+		if err != nil {
+			a.sendError(w, r, http.StatusInternalServerError, err, "failed to check game access")
+			return
+		}
+
+		if targetAccess.Team != nil {
+			a.sendError(w, r, http.StatusConflict, nil, "players can't be referees")
+			return
+		}
+	}
+
+	role, err := a.gameRoleRepo.Grant(r.Context(), &domain.GameRole{
+		GameID: gid,
+		UserID: user.ID,
+		Role:   body.Role,
+	})
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to grant role")
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, role)
+}
+
+func (a *api) revokeGameRoleHandler(w http.ResponseWriter, r *http.Request) {
+	gid := chi.URLParam(r, "id")
+
+	if _, ok := a.authorizeGame(w, r, gid, domain.GamePermissionOwn); !ok {
+		return
+	}
+
+	if err := a.gameRoleRepo.Revoke(r.Context(), gid, chi.URLParam(r, "userId")); err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to revoke role")
+		return
+	}
+
+	a.sendNoContent(w)
+}
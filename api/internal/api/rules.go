@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/peonii/inertia/internal/domain"
+)
+
+func (a *api) gameRulesHandler(w http.ResponseWriter, r *http.Request) {
+	gid := chi.URLParam(r, "id")
+
+	if _, ok := a.authorizeGame(w, r, gid, domain.GamePermissionView); !ok {
+		return
+	}
+
+	rules, err := a.gameRulesRepo.FindByGameID(r.Context(), gid)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find rules")
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, rules)
+}
+
+func (a *api) updateGameRulesHandler(w http.ResponseWriter, r *http.Request) {
+	gid := chi.URLParam(r, "id")
+
+	access, ok := a.authorizeGame(w, r, gid, domain.GamePermissionManage)
+	if !ok {
+		return
+	}
+
+	if access.Game.Status == domain.GameStatusFinished {
+		a.sendError(w, r, http.StatusConflict, nil, "cannot edit the rules of a finished game")
+		return
+	}
+
+	var body domain.GameRulesUpdate
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		a.sendError(w, r, http.StatusBadRequest, err, "failed to decode rules")
+		return
+	}
+
+	rules := &domain.GameRules{
+		GameID:          gid,
+		StartingBalance: body.StartingBalance,
+		VetoDuration:    body.VetoDuration,
+		CatchDistance:   body.CatchDistance,
+		CatchWindow:     body.CatchWindow,
+		SpectatorDelay:  body.SpectatorDelay,
+		Tickets:         body.Tickets,
+		Powerups:        body.Powerups,
+
+		OutOfBoundsPenalty: body.OutOfBoundsPenalty,
+		OutOfBoundsFine:    body.OutOfBoundsFine,
+		RevealInterval:     body.RevealInterval,
+	}
+
+	// Older clients don't know about catch settings
+	defaults := domain.DefaultGameRules(gid)
+	if rules.CatchDistance == 0 {
+		rules.CatchDistance = defaults.CatchDistance
+	}
+
+	if rules.CatchWindow == 0 {
+		rules.CatchWindow = defaults.CatchWindow
+	}
+
+	if rules.OutOfBoundsPenalty == "" {
+		rules.OutOfBoundsPenalty = defaults.OutOfBoundsPenalty
+	}
+
+	if rules.Tickets == nil {
+		rules.Tickets = []domain.TicketType{}
+	}
+
+	if rules.Powerups == nil {
+		rules.Powerups = map[string]domain.PowerupRule{}
+	}
+
+	if err := rules.Validate(); err != nil {
+		a.sendError(w, r, http.StatusBadRequest, err, err.Error())
+		return
+	}
+
+	rules, err := a.gameRulesRepo.Upsert(r.Context(), rules)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to update rules")
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, rules)
+}
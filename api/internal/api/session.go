@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (a *api) sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	sessions, err := a.sessionRepo.FindByUserID(r.Context(), a.session(r))
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find sessions")
+		return
+	}
+
+	sid := a.sessionID(r)
+	for _, s := range sessions {
+		s.Current = s.ID == sid
+	}
+
+	a.sendJson(w, http.StatusOK, sessions)
+}
+
+// Signs out everywhere but here
+func (a *api) deleteSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	sessions, err := a.sessionRepo.FindByUserID(r.Context(), a.session(r))
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find sessions")
+		return
+	}
+
+	sid := a.sessionID(r)
+	for _, s := range sessions {
+		if s.ID == sid {
+			continue
+		}
+
+		if err := a.sessionRepo.Delete(r.Context(), s.ID); err != nil {
+			a.sendError(w, r, http.StatusInternalServerError, err, "failed to delete session")
+			return
+		}
+	}
+
+	a.sendNoContent(w)
+}
+
+func (a *api) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	session, err := a.sessionRepo.FindOne(r.Context(), id)
+	if err != nil || session.UserID != a.session(r) {
+		a.sendError(w, r, http.StatusNotFound, err, "failed to find session")
+		return
+	}
+
+	if err := a.sessionRepo.Delete(r.Context(), id); err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to delete session")
+		return
+	}
+
+	a.sendNoContent(w)
+}
+
+// Revokes the session the token belongs to, its refresh
+// token and any access token issued for it stop working
+func (a *api) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if err := a.accessTokenRepo.RevokeAccessToken(r.Context(), a.accessToken(r)); err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to revoke token")
+		return
+	}
+
+	// Tokens from before sessions only have themselves to revoke
+	if sid := a.sessionID(r); sid != "" {
+		if err := a.sessionRepo.Delete(r.Context(), sid); err != nil {
+			a.sendError(w, r, http.StatusInternalServerError, err, "failed to delete session")
+			return
+		}
+	}
+
+	a.sendNoContent(w)
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/go-chi/chi/v5"
+	"github.com/peonii/inertia/internal/domain"
+	"github.com/peonii/inertia/internal/repository"
+	"go.uber.org/zap"
+)
+
+// Stores the uploaded evidence and leaves the quest for the host to review.
+// Expects a multipart form with the file in the "evidence" field.
+func (a *api) submitEvidence(w http.ResponseWriter, r *http.Request, team *domain.Team, quest *domain.ActiveQuestFull, activeID string) {
+	uid := a.session(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, domain.MaxEvidenceSize)
+	if err := r.ParseMultipartForm(domain.MaxEvidenceSize); err != nil {
+		a.sendError(w, r, http.StatusBadRequest, err, "this quest needs evidence, upload it as multipart form field 'evidence'")
+		return
+	}
+
+	file, header, err := r.FormFile("evidence")
+	if err != nil {
+		a.sendError(w, r, http.StatusBadRequest, err, "this quest needs evidence, upload it as multipart form field 'evidence'")
+		return
+	}
+	defer file.Close()
+
+	// Sniff the type instead of trusting the client
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		a.sendError(w, r, http.StatusBadRequest, err, "failed to read evidence")
+		return
+	}
+
+	contentType := http.DetectContentType(head[:n])
+	if !strings.HasPrefix(contentType, "image/") && !strings.HasPrefix(contentType, "video/") {
+		a.sendError(w, r, http.StatusBadRequest, nil, "evidence must be a photo or a video")
+		return
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to read evidence")
+		return
+	}
+
+	node, err := snowflake.NewNode(domain.QuestSubmissionSnowflakeNode)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to store evidence")
+		return
+	}
+
+	key := fmt.Sprintf("evidence/%s/%s", team.ID, node.Generate().String())
+	if err := a.blobRepo.Put(r.Context(), key, file); err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to store evidence")
+		return
+	}
+
+	a.logger.Info("stored evidence",
+		zap.String("key", key),
+		zap.String("filename", header.Filename),
+		zap.Int64("size", header.Size),
+	)
+
+	sub, err := a.questSubmissionRepo.Create(r.Context(), &domain.QuestSubmissionCreate{
+		ActiveQuestID: activeID,
+		TeamID:        team.ID,
+		UserID:        uid,
+		BlobKey:       key,
+		ContentType:   contentType,
+	})
+	if err != nil {
+		if derr := a.blobRepo.Delete(r.Context(), key); derr != nil {
+			a.logger.Error("failed to delete evidence", zap.String("key", key), zap.Error(derr))
+		}
+
+		if errors.Is(err, repository.ErrQuestNotActive) {
+			a.sendError(w, r, http.StatusConflict, err, "quest is already completed or waiting for review")
+			return
+		}
+
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to submit quest")
+		return
+	}
+
+	a.WsHub.Publish(r.Context(), team.GameID, domain.GameEventSubmission, wsSubmissionMsg{
+		Submission: sub,
+		GameID:     team.GameID,
+	})
+
+	a.sendJson(w, http.StatusAccepted, sub)
+}
+
+func (a *api) submissionsByGameIDHandler(w http.ResponseWriter, r *http.Request) {
+	gid := chi.URLParam(r, "id")
+
+	if _, ok := a.authorizeGame(w, r, gid, domain.GamePermissionReferee); !ok {
+		return
+	}
+
+	subs, err := a.questSubmissionRepo.FindByGameID(r.Context(), gid)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find submissions")
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, subs)
+}
+
+// The host, referees and the submitting team can download the evidence
+func (a *api) submissionEvidenceHandler(w http.ResponseWriter, r *http.Request) {
+	sid := chi.URLParam(r, "id")
+
+	sub, err := a.questSubmissionRepo.FindOne(r.Context(), sid)
+	if err != nil {
+		a.sendError(w, r, http.StatusNotFound, err, "failed to find submission")
+		return
+	}
+
+	access, _, ok := a.authorizeTeam(w, r, sub.TeamID, domain.GamePermissionView)
+	if !ok {
+		return
+	}
+
+	if !access.CanSeeTeam(sub.TeamID) {
+		a.sendError(w, r, http.StatusForbidden, nil, "you can't see this submission")
+		return
+	}
+
+	blob, err := a.blobRepo.Get(r.Context(), sub.BlobKey)
+	if err != nil {
+		a.sendError(w, r, http.StatusNotFound, err, "failed to find evidence")
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", sub.ContentType)
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, blob); err != nil {
+		a.logger.Error("failed to send evidence", zap.String("submission_id", sid), zap.Error(err))
+	}
+}
+
+func (a *api) approveSubmissionHandler(w http.ResponseWriter, r *http.Request) {
+	a.reviewSubmission(w, r, domain.SubmissionStatusApproved)
+}
+
+func (a *api) rejectSubmissionHandler(w http.ResponseWriter, r *http.Request) {
+	a.reviewSubmission(w, r, domain.SubmissionStatusRejected)
+}
+
+func (a *api) reviewSubmission(w http.ResponseWriter, r *http.Request, status string) {
+	uid := a.session(r)
+	sid := chi.URLParam(r, "id")
+
+	var body domain.QuestSubmissionReview
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			a.sendError(w, r, http.StatusBadRequest, err, "failed to decode review")
+			return
+		}
+	}
+
+	sub, err := a.questSubmissionRepo.FindOne(r.Context(), sid)
+	if err != nil {
+		a.sendError(w, r, http.StatusNotFound, err, "failed to find submission")
+		return
+	}
+
+	_, team, ok := a.authorizeTeam(w, r, sub.TeamID, domain.GamePermissionReferee)
+	if !ok {
+		return
+	}
+
+	if !sub.IsPending() {
+		a.sendError(w, r, http.StatusConflict, nil, "submission has already been reviewed")
+		return
+	}
+
+	quest, err := a.questRepo.FindActive(r.Context(), sub.ActiveQuestID)
+	if err != nil {
+		a.sendError(w, r, http.StatusNotFound, err, "failed to find quest")
+		return
+	}
+
+	sub, err = a.questSubmissionRepo.Review(r.Context(), sid, status, uid, body.Note, questReward(team, quest, sub.ActiveQuestID, uid))
+	if errors.Is(err, repository.ErrSubmissionNotPending) || errors.Is(err, repository.ErrQuestNotActive) {
+		a.sendError(w, r, http.StatusConflict, err, "submission has already been reviewed")
+		return
+	}
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to review submission")
+		return
+	}
+
+	a.WsHub.Publish(r.Context(), team.GameID, domain.GameEventSubmission, wsSubmissionMsg{
+		Submission: sub,
+		GameID:     team.GameID,
+	})
+
+	a.sendJson(w, http.StatusOK, sub)
+
+	if sub.Status == domain.SubmissionStatusApproved {
+		a.announceQuest(r.Context(), team, quest)
+	}
+}
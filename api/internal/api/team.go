@@ -5,30 +5,42 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/peonii/inertia/internal/domain"
-	"go.uber.org/zap"
+	"github.com/peonii/inertia/internal/repository"
 )
 
 func (a *api) teamsByGameIDHandler(w http.ResponseWriter, r *http.Request) {
 	gid := chi.URLParam(r, "id")
 
+	if _, ok := a.authorizeGame(w, r, gid, domain.GamePermissionView); !ok {
+		return
+	}
+
 	teams, err := a.teamRepo.FindByGameID(r.Context(), gid)
 	if err != nil {
 		a.sendError(w, r, http.StatusNotFound, err, "failed to find teams")
 		return
 	}
 
+	if err := a.attachFreezes(r.Context(), teams); err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to check powerups")
+		return
+	}
+
 	a.sendJson(w, http.StatusOK, teams)
 }
 
 func (a *api) teamByIDHandler(w http.ResponseWriter, r *http.Request) {
-	tid := chi.URLParam(r, "id")
+	_, team, ok := a.authorizeTeam(w, r, chi.URLParam(r, "id"), domain.GamePermissionView)
+	if !ok {
+		return
+	}
 
-	team, err := a.teamRepo.FindOne(r.Context(), tid)
-	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find team")
+	if err := a.attachFreezes(r.Context(), []*domain.Team{team}); err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to check powerups")
 		return
 	}
 
@@ -49,6 +61,11 @@ func (a *api) joinedTeamsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := a.attachFreezes(r.Context(), teams); err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to check powerups")
+		return
+	}
+
 	a.sendJson(w, http.StatusOK, teams)
 }
 
@@ -65,15 +82,19 @@ func (a *api) joinTeamHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	invite, err := a.gameInviteRepo.FindBySlug(r.Context(), body.GameInviteCode)
+	team, err := a.teamRepo.FindOne(r.Context(), tid)
 	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find invite")
+		a.sendError(w, r, http.StatusNotFound, err, "failed to find team")
 		return
 	}
 
-	team, err := a.teamRepo.FindOne(r.Context(), tid)
-	if team.GameID != invite.GameID {
-		a.sendError(w, r, http.StatusUnauthorized, err, "failed to verify invite")
+	access, ok := a.joinAccess(w, r, team.GameID, body.GameInviteCode)
+	if !ok {
+		return
+	}
+
+	if access.Team != nil {
+		a.sendError(w, r, http.StatusConflict, nil, "already in a team in this game")
 		return
 	}
 
@@ -83,6 +104,13 @@ func (a *api) joinTeamHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The user may already be connected as a spectator
+	a.WsHub.Publish(r.Context(), team.GameID, domain.GameEventTeam, wsTeamMsg{
+		TeamID: tid,
+		UserID: uid,
+		GameID: team.GameID,
+	})
+
 	// We can bail early because user stats are a secondary thing
 	stats, err := a.userStatsRepo.Get(r.Context(), uid)
 	if err != nil {
@@ -108,17 +136,61 @@ func (a *api) createTeamHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, ok := a.joinAccess(w, r, invite.GameID, teamc.GameInvite); !ok {
+		return
+	}
+
 	teamc.GameID = invite.GameID
 
+	rules, err := a.gameRulesRepo.FindByGameID(r.Context(), invite.GameID)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find game rules")
+		return
+	}
+
+	teamc.Balance = rules.StartingBalance
+
 	team, err := a.teamRepo.Create(r.Context(), &teamc)
 	if err != nil {
 		a.sendError(w, r, http.StatusInternalServerError, err, "failed to create team")
 		return
 	}
 
+	a.WsHub.Publish(r.Context(), team.GameID, domain.GameEventTeam, wsTeamMsg{
+		TeamID: team.ID,
+		GameID: team.GameID,
+	})
+
 	a.sendJson(w, http.StatusOK, team)
 }
 
+// Joining or creating a team takes a player invite, and being
+// someone who's allowed to play, so referees can't
+func (a *api) joinAccess(w http.ResponseWriter, r *http.Request, gameID string, code string) (*domain.GameAccess, bool) {
+	access, ok := a.loadGameAccess(w, r, gameID)
+	if !ok {
+		return nil, false
+	}
+
+	invite, err := a.useInvite(r.Context(), access, code)
+	if err != nil {
+		a.sendError(w, r, http.StatusUnauthorized, err, "failed to verify invite")
+		return nil, false
+	}
+
+	if invite.IsSpectator() {
+		a.sendError(w, r, http.StatusForbidden, nil, "spectator invites can't be used to join teams")
+		return nil, false
+	}
+
+	if !access.Can(domain.GamePermissionPlay) {
+		a.sendError(w, r, http.StatusForbidden, nil, "referees can't play in the game")
+		return nil, false
+	}
+
+	return access, true
+}
+
 type ticketBuyRequest struct {
 	Amount int    `json:"amount"`
 	Type   string `json:"type"`
@@ -135,57 +207,69 @@ func (a *api) buyTicketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	team, err := a.teamRepo.FindOne(r.Context(), tid)
-	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find team")
+	if body.Amount <= 0 {
+		a.sendError(w, r, http.StatusBadRequest, nil, "amount must be positive")
 		return
 	}
 
-	user, err := a.userRepo.FindOne(r.Context(), uid)
-	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find user")
+	access, team, ok := a.authorizePlayer(w, r, tid)
+	if !ok {
 		return
 	}
+	game := access.Game
 
-	isMember, err := a.teamRepo.IsTeamMember(r.Context(), team, user)
+	if !game.IsRunning() {
+		a.sendError(w, r, http.StatusForbidden, nil, "game is not running")
+		return
+	}
+
+	freeze, err := a.findFreeze(r.Context(), team)
 	if err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to verify team membership")
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to check powerups")
 		return
 	}
 
-	if !isMember {
-		a.sendError(w, r, http.StatusUnauthorized, errors.New("user is not a member of the team"), "failed to verify team membership")
+	if freeze != nil {
+		a.sendFrozen(w, r, freeze)
 		return
 	}
 
-	cost := 0
-	switch body.Type {
-	case "bus":
-		cost = 20
-	case "tram":
-		cost = 30
-	case "m1":
-		cost = 70
-	case "m2":
-		cost = 60
-	case "km":
-		cost = 70
-	case "wkd":
-		cost = 40
+	rules, err := a.gameRulesRepo.FindByGameID(r.Context(), game.ID)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find game rules")
+		return
 	}
 
-	if team.Balance < body.Amount*cost {
-		a.sendError(w, r, http.StatusUnauthorized, errors.New("team does not have enough balance"), "failed to verify team balance")
+	cost, ok := rules.TicketPrice(body.Type)
+	if !ok {
+		a.sendError(w, r, http.StatusBadRequest, nil, "unknown ticket type")
 		return
 	}
 
-	newBalance := team.Balance - (body.Amount * cost)
+	blacklist, err := a.findBlacklist(r.Context(), team, body.Type)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to check powerups")
+		return
+	}
 
-	teamUpdate := domain.TeamUpdate{
-		Balance: &newBalance,
+	if blacklist != nil {
+		remaining := time.Until(blacklist.EndsAt).Round(time.Second)
+		a.sendError(w, r, http.StatusForbidden, nil, fmt.Sprintf("%s tickets are blacklisted for another %s", body.Type, remaining))
+		return
 	}
 
-	team, err = a.teamRepo.Update(r.Context(), tid, &teamUpdate)
+	_, err = a.teamTransactionRepo.Apply(r.Context(), &domain.TeamTransactionCreate{
+		TeamID:    tid,
+		Kind:      domain.TeamTransactionTicketPurchase,
+		Amount:    -(body.Amount * cost),
+		Reference: body.Type,
+		Note:      fmt.Sprintf("%dx %s", body.Amount, body.Type),
+		UserID:    uid,
+	})
+	if errors.Is(err, repository.ErrInsufficientBalance) {
+		a.sendError(w, r, http.StatusUnauthorized, err, "failed to verify team balance")
+		return
+	}
 	if err != nil {
 		a.sendError(w, r, http.StatusInternalServerError, err, "failed to update team balance")
 		return
@@ -199,26 +283,30 @@ func (a *api) catchTeamHandler(w http.ResponseWriter, r *http.Request) {
 
 	tid := chi.URLParam(r, "id")
 
-	team, err := a.teamRepo.FindOne(r.Context(), tid)
-	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find team")
+	access, team, ok := a.authorizePlayer(w, r, tid)
+	if !ok {
 		return
 	}
+	game := access.Game
 
-	user, err := a.userRepo.FindOne(r.Context(), uid)
-	if err != nil {
-		a.sendError(w, r, http.StatusNotFound, err, "failed to find user")
+	if !game.IsRunning() {
+		a.sendError(w, r, http.StatusForbidden, nil, "game is not running")
 		return
 	}
 
-	isMember, err := a.teamRepo.IsTeamMember(r.Context(), team, user)
+	freeze, err := a.findFreeze(r.Context(), team)
 	if err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to verify team membership")
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to check powerups")
 		return
 	}
 
-	if !isMember {
-		a.sendError(w, r, http.StatusUnauthorized, err, "user is not a member of the team")
+	if freeze != nil {
+		a.sendFrozen(w, r, freeze)
+		return
+	}
+
+	if team.IsRunner {
+		a.sendError(w, r, http.StatusUnauthorized, nil, "can't catch runner if already runner")
 		return
 	}
 
@@ -228,75 +316,70 @@ func (a *api) catchTeamHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hasEncountered := false
-
+	var runner *domain.Team
 	for _, t := range otherTeams {
-		a.logger.Info("trying to update team",
-			zap.Any("team", t),
-		)
 		if t.IsRunner {
-			err = a.teamRepo.MakeHunter(r.Context(), t.ID)
-			if err != nil {
-				a.sendError(w, r, http.StatusInternalServerError, err, "failed to make team hunter")
-				return
-			}
-
-			hasEncountered = true
+			runner = t
+			break
 		}
 	}
 
-	if !hasEncountered {
-		a.sendError(w, r, http.StatusUnauthorized, errors.New("no runners found"), "can't catch runner if already runner")
+	if runner == nil {
+		a.sendError(w, r, http.StatusUnauthorized, errors.New("no runners found"), "no runners to catch")
 		return
 	}
 
-	err = a.teamRepo.MakeRunner(r.Context(), tid)
+	rules, err := a.gameRulesRepo.FindByGameID(r.Context(), game.ID)
 	if err != nil {
-		a.sendError(w, r, http.StatusInternalServerError, err, "failed to make team runner")
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find game rules")
 		return
 	}
 
-	a.WsHub.BroadcastCat <- wsCatchMsg{
-		NewRunnerID: tid,
-	}
-
-	a.sendJson(w, http.StatusOK, nil)
-
-	members, err := a.teamRepo.FindMembers(r.Context(), tid)
+	distance, err := a.closestDistance(r.Context(), team, runner, rules.CatchPeriod())
 	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to check team locations")
 		return
 	}
 
-	users, err := a.gameRepo.FindAllUsersIDs(r.Context(), team.GameID)
+	// Too far away (or no recent locations), so the runners
+	// or the host have to confirm the catch
+	status := domain.CatchStatusPending
+	if distance != nil && *distance <= float64(rules.CatchDistance) {
+		status = domain.CatchStatusAccepted
+	}
+
+	catch, err := a.catchRepo.Create(r.Context(), &domain.CatchCreate{
+		GameID:    game.ID,
+		CatcherID: team.ID,
+		RunnerID:  runner.ID,
+		UserID:    uid,
+		Status:    status,
+		Distance:  distance,
+	})
 	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to record catch")
 		return
 	}
 
-	// Remove all team members from notified users
-	for _, member := range members {
-		for i, user := range users {
-			if user == member.ID {
-				users = append(users[:i], users[i+1:]...)
-				break
-			}
-		}
+	if catch.IsPending() {
+		a.sendJson(w, http.StatusAccepted, catch)
+
+		a.notifyTeam(r.Context(), runner.ID, domain.Notification{
+			Title:    "Were you caught?",
+			Body:     fmt.Sprintf("The team %s says they caught you, confirm or reject it in the app", team.Name),
+			Priority: 10,
+		})
+		return
 	}
 
-	devices, err := a.notifRepo.GetDevicesForUsers(r.Context(), users)
-	if err != nil {
+	if catch.Status == domain.CatchStatusRejected {
+		a.sendError(w, r, http.StatusConflict, nil, "the runners were caught by someone else first")
 		return
 	}
 
-	for _, device := range devices {
-		notif := domain.Notification{
-			Title:    "Team caught",
-			Body:     fmt.Sprintf("The team %s just caught the runners!", team.Name),
-			Priority: 10,
-			DeviceID: device.ID,
-		}
+	a.publishCatch(r.Context(), catch)
 
-		if err := a.scheduleNotification(&notif); err != nil {
-			continue
-		}
-	}
+	a.sendJson(w, http.StatusOK, catch)
+
+	a.notifyCatch(r.Context(), team)
 }
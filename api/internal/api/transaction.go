@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/peonii/inertia/internal/domain"
+	"github.com/peonii/inertia/internal/repository"
+)
+
+func (a *api) teamTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	tid := chi.URLParam(r, "id")
+
+	access, _, ok := a.authorizeTeam(w, r, tid, domain.GamePermissionView)
+	if !ok {
+		return
+	}
+
+	if !access.CanSeeTeam(tid) {
+		a.sendError(w, r, http.StatusForbidden, nil, "you are not a member of this team")
+		return
+	}
+
+	transactions, err := a.teamTransactionRepo.FindByTeamID(r.Context(), tid)
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to find transactions")
+		return
+	}
+
+	a.sendJson(w, http.StatusOK, transactions)
+}
+
+func (a *api) adjustTeamBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	uid := a.session(r)
+	tid := chi.URLParam(r, "id")
+
+	if _, _, ok := a.authorizeTeam(w, r, tid, domain.GamePermissionReferee); !ok {
+		return
+	}
+
+	var body domain.TeamTransactionCreate
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		a.sendError(w, r, http.StatusBadRequest, err, "failed to decode transaction")
+		return
+	}
+
+	if body.Amount == 0 {
+		a.sendError(w, r, http.StatusBadRequest, nil, "amount can't be zero")
+		return
+	}
+
+	body.TeamID = tid
+	body.Kind = domain.TeamTransactionHostAdjustment
+	body.UserID = uid
+
+	transaction, err := a.teamTransactionRepo.Apply(r.Context(), &body)
+	if errors.Is(err, repository.ErrInsufficientBalance) {
+		a.sendError(w, r, http.StatusConflict, err, "team does not have enough balance")
+		return
+	}
+	if err != nil {
+		a.sendError(w, r, http.StatusInternalServerError, err, "failed to adjust balance")
+		return
+	}
+
+	a.sendJson(w, http.StatusCreated, transaction)
+}
@@ -22,6 +22,11 @@ func (a *api) sendJson(w http.ResponseWriter, code int, data interface{}) {
 	}
 }
 
+// A 204 can't have a body, not even null
+func (a *api) sendNoContent(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (a *api) sendError(w http.ResponseWriter, r *http.Request, code int, err error, msg string) {
 	a.logger.Error(msg,
 		zap.Error(err),
@@ -42,6 +47,15 @@ func (a *api) session(r *http.Request) string {
 	return uid
 }
 
+func (a *api) accessToken(r *http.Request) *domain.AccessToken {
+	return r.Context().Value(AccessTokenKey).(*domain.AccessToken)
+}
+
+// Empty for tokens from before sessions
+func (a *api) sessionID(r *http.Request) string {
+	return a.accessToken(r).SessionID
+}
+
 func (a *api) scheduleNotification(n *domain.Notification) error {
 	marshaled, err := json.Marshal(n)
 	if err != nil {
@@ -2,40 +2,265 @@ package api
 
 import (
 	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/peonii/inertia/internal/domain"
 	"github.com/peonii/inertia/internal/repository"
 	"github.com/pkgz/websocket"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
+const (
+	// How often runner locations are pushed to hunters while hunt is active
+	huntInterval = time.Second * 5
+
+	// Messages waiting to be written to a single client
+	wsSendQueueSize = 64
+	// A client that misses this many messages is disconnected
+	wsMaxDropped = 32
+	// Events waiting to be processed by a single game
+	wsInboxSize = 1024
+	// How long important events (catches, status changes) wait
+	// for room in a busy game's inbox before they're dropped
+	wsInboxTimeout = time.Second
+
+	// Clients on protocol v2 and up that don't send a heartbeat
+	// for this long are disconnected
+	wsHeartbeatTimeout = time.Minute
+
+	// How often delayed messages are checked for live map clients
+	wsLiveFlushInterval = time.Millisecond * 250
+	// Messages held back for a single delayed client
+	wsMaxDelayed = 10000
+
+	// How often games check whether a reveal is due, and how
+	// late a reveal can be before it's skipped
+	wsRevealCheckInterval = time.Second
+	wsRevealGrace         = time.Second * 5
+)
+
+// The parts of *websocket.Conn the hub uses
+type wsConn interface {
+	Send(data interface{}) error
+	Close() error
+}
+
 type wsClient struct {
-	conn     *websocket.Conn
-	user     *domain.User
-	gameID   string
-	isRunner bool
+	conn   wsConn
+	user   *domain.User
+	gameID string
+	// What the client joined with, for checking scopes
+	token *domain.AccessToken
+	// Who the user was in the game when they joined, nil
+	// for test clients
+	access *domain.GameAccess
+
+	// Protocol version the client joined with
+	version int
+	// Last seq the client has seen, events at or before
+	// it aren't sent again
+	lastSeq int64
+	// Unix millis of the last frame received from the client
+	lastSeen atomic.Int64
+
+	// Owned by the client's game actor
+	role    string
+	dropped int
+	// Collects messages while the client is resuming
+	backlog []wsMsg
+	// Watching the live map, which shows everyone. Messages
+	// are held back for delay before they're sent.
+	live    bool
+	delay   time.Duration
+	delayed []wsDelayedMsg
+
+	send      chan wsMsg
+	replay    chan []wsMsg
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-type wsHub struct {
-	Clients      map[*wsClient]bool
-	BroadcastLoc chan wsLocationMsg
-	BroadcastPwp chan wsPowerupMsg
-	BroadcastCat chan wsCatchMsg
-	Register     chan *wsClient
-	Unregister   chan *wsClient
+func newWsClient(conn wsConn, user *domain.User, gameID string) *wsClient {
+	c := &wsClient{
+		conn:   conn,
+		user:   user,
+		gameID: gameID,
+		send:   make(chan wsMsg, wsSendQueueSize),
+		replay: make(chan []wsMsg, 1),
+		done:   make(chan struct{}),
+	}
+	c.seen()
+
+	return c
+}
+
+type wsDelayedMsg struct {
+	at  time.Time
+	msg wsMsg
+}
+
+func (c *wsClient) seen() {
+	c.lastSeen.Store(time.Now().UnixMilli())
+}
+
+// Queues a reply to one of the client's frames. Unlike
+// events these don't count towards the client being slow.
+func (c *wsClient) reply(msg wsMsg) {
+	select {
+	case c.send <- msg:
+	default:
+	}
+}
+
+// Writes queued messages to the connection, so one slow
+// connection never holds up its game
+func (c *wsClient) writeLoop(logger *zap.Logger) {
+	defer func() {
+		if r := recover(); r != nil {
+			// Writing to a connection that was closed under us panics
+			logger.Error("websocket writer panicked", zap.Any("panic", r))
+		}
+	}()
+
+	write := func(msg wsMsg) bool {
+		if err := c.conn.Send(msg); err != nil {
+			logger.Info("failed to write to client", zap.String("user_id", c.user.ID), zap.Error(err))
+			c.close()
+			return false
+		}
+
+		return true
+	}
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case msgs := <-c.replay:
+			for _, msg := range msgs {
+				if !write(msg) {
+					return
+				}
+			}
+		case msg := <-c.send:
+			if !write(msg) {
+				return
+			}
+		}
+	}
+}
+
+// Stops writing to the connection, but leaves it open
+func (c *wsClient) stop() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+func (c *wsClient) close() {
+	c.stop()
+	c.conn.Close()
+}
 
-	logger      *zap.Logger
-	powerupRepo repository.PowerupRepository
-	teamRepo    repository.TeamRepository
-	userRepo    repository.UserRepository
+type wsHub struct {
+	Unregister chan *wsClient
+	Disconnect chan *websocket.Conn
+
+	logger *zap.Logger
+	// nil when the hub isn't connected to Redis (tests)
+	pubsub *redis.PubSub
+
+	gameRepo      repository.GameRepository
+	powerupRepo   repository.PowerupRepository
+	teamRepo      repository.TeamRepository
+	locationRepo  repository.LocationRepository
+	gameEventRepo repository.GameEventRepository
+	gameRulesRepo repository.GameRulesRepository
+
+	// Games with clients connected to this replica. We're only
+	// subscribed to the events of these.
+	mu      sync.Mutex
+	games   map[string]*wsGame
+	clients map[wsConn]*wsClient
 }
 
+// Latest version of the protocol. Clients that don't send
+// a version when joining are on v1, which replies to the
+// join with a bare "ok"/"invalid" and doesn't resume.
+const wsProtocolVersion = 2
+
+// Seq is only set on game events, which can be resumed.
+// Replies to the client's own frames have none.
 type wsMsg struct {
+	Seq  int64       `json:"seq,omitempty"`
 	Type string      `json:"typ"`
 	Data interface{} `json:"dat"`
 }
 
+// Frames only sent to a single client
+const (
+	wsMsgWelcome = "wel"
+	wsMsgAck     = "ack"
+	wsMsgError   = "err"
+)
+
+const (
+	wsErrBadRequest   = "bad_request"
+	wsErrUnauthorized = "unauthorized"
+	wsErrNotJoined    = "not_joined"
+	wsErrForbidden    = "forbidden"
+	wsErrInternal     = "internal"
+	// The location didn't pass the sanity checks
+	wsErrRejected = "rejected"
+)
+
+type wsWelcomePayload struct {
+	Version int          `json:"v"`
+	User    *domain.User `json:"user"`
+	Role    string       `json:"role"`
+	// Seq of the last event in the game
+	Seq int64 `json:"seq"`
+	// False if some of the events since the client's last seq
+	// are gone, the client should refetch the game's state
+	Resumed bool `json:"resumed"`
+}
+
+type wsAckPayload struct {
+	// ID of the client's frame
+	Ref string `json:"ref"`
+	// Seq of the event the frame caused, if any
+	Seq int64 `json:"seq,omitempty"`
+}
+
+type wsLiveAckPayload struct {
+	Ref string `json:"ref"`
+	// Seconds the live map is behind, at least the game's spectator delay
+	Delay int `json:"delay"`
+}
+
+type wsErrorPayload struct {
+	Ref     string `json:"ref,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"msg"`
+}
+
+func wsError(ref, code, message string) wsMsg {
+	return wsMsg{
+		Type: wsMsgError,
+		Data: wsErrorPayload{
+			Ref:     ref,
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
 type wsLocationMsg struct {
 	Location domain.LocationCreate `json:"loc"`
 	UserID   string                `json:"uid"`
@@ -50,6 +275,7 @@ type wsLocationPayload struct {
 
 type wsPowerupMsg struct {
 	Powerup *domain.Powerup `json:"pwp"`
+	GameID  string          `json:"gid"`
 }
 
 type wsPowerupPayload struct {
@@ -59,176 +285,335 @@ type wsPowerupPayload struct {
 
 type wsCatchMsg struct {
 	NewRunnerID string `json:"nrid"`
+	GameID      string `json:"gid"`
 }
 
 type wsCatchPayload struct {
 	NewRunner *domain.Team `json:"nrt"`
 }
 
-func NewWsHub(logger *zap.Logger, db *pgxpool.Pool) *wsHub {
-	return &wsHub{
-		BroadcastLoc: make(chan wsLocationMsg),
-		BroadcastPwp: make(chan wsPowerupMsg),
-		BroadcastCat: make(chan wsCatchMsg),
-		Register:     make(chan *wsClient),
-		Unregister:   make(chan *wsClient),
-		Clients:      make(map[*wsClient]bool),
+type wsStatusMsg struct {
+	Game *domain.Game `json:"game"`
+	From string       `json:"from"`
+}
+
+type wsStatusPayload struct {
+	Game *domain.Game `json:"game"`
+	From string       `json:"from"`
+}
+
+type wsSubmissionMsg struct {
+	Submission *domain.QuestSubmission `json:"sub"`
+	GameID     string                  `json:"gid"`
+}
+
+type wsSubmissionPayload struct {
+	Submission *domain.QuestSubmission `json:"sub"`
+}
+
+type wsTeamMsg struct {
+	TeamID string `json:"tid"`
+	// Empty when the team was just created
+	UserID string `json:"uid"`
+	GameID string `json:"gid"`
+}
+
+type wsTeamPayload struct {
+	Team *domain.Team `json:"team"`
+	User *domain.User `json:"user"`
+}
+
+type wsQuestMsg struct {
+	Quest  *domain.ActiveQuestFull `json:"qst"`
+	TeamID string                  `json:"tid"`
+	GameID string                  `json:"gid"`
+}
+
+type wsQuestPayload struct {
+	Quest *domain.ActiveQuestFull `json:"qst"`
+	Team  *domain.Team            `json:"team"`
+}
+
+type wsOutOfBoundsMsg struct {
+	Location domain.LocationCreate `json:"loc"`
+	UserID   string                `json:"uid"`
+	GameID   string                `json:"gid"`
+	Penalty  string                `json:"pen"`
+	Fine     int                   `json:"fin"`
+}
+
+type wsOutOfBoundsPayload struct {
+	Location domain.LocationCreate `json:"loc"`
+	Team     *domain.Team          `json:"team"`
+	User     *domain.User          `json:"user"`
+	// One of the OutOfBoundsPenalty* values
+	Penalty string `json:"penalty"`
+	// Taken off the team's balance, 0 if it wasn't fined
+	Fine int `json:"fine"`
+}
+
+// Sent at each tick of the game's reveal schedule
+type wsRevealPayload struct {
+	Locations []wsLocationPayload `json:"locs"`
+	// Teams that weren't revealed because of hide_tracker
+	Hidden []*domain.Team `json:"hidden"`
+	Next   time.Time      `json:"next"`
+}
 
-		logger:      logger,
-		powerupRepo: repository.MakePostgresPowerupRepository(db),
-		teamRepo:    repository.MakePostgresTeamRepository(db),
-		userRepo:    repository.MakePostgresUserRepository(db),
+const wsMsgReveal = "rev"
+
+// What a client gets to see depends on its role in the game
+const (
+	// Only see hunters when reveal_hunters is active
+	wsRoleRunner = "runner"
+	// See runners unless they're hidden by hide_tracker
+	wsRoleHunter = "hunter"
+	// The host or a referee, when not in a team. Sees everyone.
+	wsRoleHost = "host"
+	// Anyone else, doesn't get locations outside the live map
+	wsRoleSpectator = "spectator"
+)
+
+// Sent to a client when its role changes, e.g. after a catch
+type wsRolePayload struct {
+	Role string       `json:"role"`
+	Team *domain.Team `json:"team"`
+}
+
+const wsMsgRole = "rol"
+
+func NewWsHub(logger *zap.Logger, db *pgxpool.Pool, rdc *redis.Client) *wsHub {
+	h := newWsHub(logger,
+		repository.MakePostgresGameRepository(db),
+		repository.MakePostgresPowerupRepository(db),
+		repository.MakePostgresTeamRepository(db),
+		// Only reads the latest locations, so no writer
+		repository.MakePostgresLocationRepository(db, rdc, nil),
+		repository.MakeRedisGameEventRepository(rdc),
+		repository.MakePostgresGameRulesRepository(db),
+	)
+
+	// Games are subscribed to as clients join them
+	h.pubsub = rdc.Subscribe(context.Background())
+
+	return h
+}
+
+func newWsHub(logger *zap.Logger, gameRepo repository.GameRepository, powerupRepo repository.PowerupRepository, teamRepo repository.TeamRepository, locationRepo repository.LocationRepository, gameEventRepo repository.GameEventRepository, gameRulesRepo repository.GameRulesRepository) *wsHub {
+	return &wsHub{
+		Unregister: make(chan *wsClient),
+		Disconnect: make(chan *websocket.Conn),
+
+		logger:        logger,
+		gameRepo:      gameRepo,
+		powerupRepo:   powerupRepo,
+		teamRepo:      teamRepo,
+		locationRepo:  locationRepo,
+		gameEventRepo: gameEventRepo,
+		gameRulesRepo: gameRulesRepo,
+
+		games:   make(map[string]*wsGame),
+		clients: make(map[wsConn]*wsClient),
 	}
 }
 
+// Only handles clients going away, each game's events are
+// processed by that game's own actor. Clients are added right
+// away on join, so their next frames find them registered.
 func (h *wsHub) Run() {
 	for {
 		select {
-		case client := <-h.Register:
-			h.logger.Info("registering client", zap.Any("client", client))
-			h.Clients[client] = true
 		case client := <-h.Unregister:
-			h.logger.Info("unregistering client", zap.Any("client", client))
-			if _, ok := h.Clients[client]; ok {
-				client.conn.Close()
-				delete(h.Clients, client)
-			}
-		case message := <-h.BroadcastLoc:
-			h.logger.Info("broadcasting location", zap.Any("message", message))
-			var powerupsByGameID map[string][]*domain.Powerup
+			h.logger.Info("unregistering client", zap.String("user_id", client.user.ID), zap.String("game_id", client.gameID))
+			h.remove(client)
+		case conn := <-h.Disconnect:
+			h.removeConn(conn)
+		}
+	}
+}
 
-			sender, err := h.userRepo.FindOne(context.Background(), message.UserID)
-			if err != nil {
-				h.logger.Error("failed to find user", zap.Error(err))
-				return
-			}
+func (h *wsHub) add(client *wsClient) {
+	h.logger.Info("registering client", zap.String("user_id", client.user.ID), zap.String("game_id", client.gameID))
 
-			senderTeam, err := h.teamRepo.FindByGameUser(context.Background(), message.GameID, message.UserID)
-			if err != nil {
-				h.logger.Error("failed to find team", zap.Error(err))
-				return
-			}
+	h.mu.Lock()
+	// Joining again on the same connection replaces the old client
+	var old *wsGame
+	prev, joined := h.clients[client.conn]
+	if joined {
+		old, _ = h.removeLocked(prev)
+		prev.stop()
+	}
+	h.clients[client.conn] = client
 
-			for client := range h.Clients {
-				h.logger.Info("sending to client", zap.Any("client", client))
-				if client.gameID != message.GameID {
-					continue
-				}
+	g, ok := h.games[client.gameID]
+	if !ok {
+		g = newWsGame(h, client.gameID)
+		h.games[client.gameID] = g
+		go g.run()
 
-				if client.user.ID == message.UserID {
-					continue // don't send location updates to the user that sent it
-				}
+		h.subscribe(client.gameID)
+	}
+	g.conns[client] = true
+	h.mu.Unlock()
 
-				if powerupsByGameID == nil {
-					powerupsByGameID = make(map[string][]*domain.Powerup)
-					powerups, _ := h.powerupRepo.GetActiveByGameID(context.Background(), message.GameID)
-					for _, powerup := range powerups {
-						powerupsByGameID[client.gameID] = append(powerupsByGameID[client.gameID], powerup)
-					}
-				}
+	if old != nil {
+		old.mustDo(func() { old.leave(prev) })
+	}
 
-				powerups := powerupsByGameID[client.gameID]
-				team, err := h.teamRepo.FindByGameUser(context.Background(), client.gameID, client.user.ID)
-				if err != nil {
-					h.logger.Error("failed to find team", zap.Error(err))
-					continue
-				}
+	go client.writeLoop(h.logger)
+	g.mustDo(func() { g.join(client) })
+}
 
-				override := false
-				neverShow := false
-
-				for _, powerup := range powerups {
-					if powerup.CasterID == team.ID {
-						if powerup.Type == domain.PowerupTypeRevealHunters {
-							override = true
-						}
-					} else if powerup.CasterID == senderTeam.ID {
-						if powerup.Type == domain.PowerupTypeHideTracker {
-							neverShow = true
-						}
-					}
-				}
+// The client that joined on the connection, if any
+func (h *wsHub) client(conn wsConn) *wsClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-				if neverShow {
-					continue
-				}
+	return h.clients[conn]
+}
 
-				if client.isRunner && !override {
-					continue // only hunters/spectators should receive location updates
-				}
+func (h *wsHub) remove(client *wsClient) {
+	h.mu.Lock()
+	g, removed := h.removeLocked(client)
+	h.mu.Unlock()
 
-				payload := wsLocationPayload{
-					Location: message.Location,
-					User:     sender,
-					Team:     senderTeam,
-				}
+	// The client may have been replaced already,
+	// then the connection belongs to the new one
+	if !removed {
+		return
+	}
 
-				h.logger.Info("sending payload",
-					zap.Any("payload", payload),
-				)
+	client.close()
 
-				client.conn.Send(wsMsg{
-					Type: "loc",
-					Data: payload,
-				})
-			}
-		case message := <-h.BroadcastPwp:
-			h.logger.Info("broadcasting powerup", zap.Any("message", message))
+	if g != nil {
+		g.mustDo(func() { g.leave(client) })
+	}
+}
 
-			caster, err := h.teamRepo.FindOne(context.Background(), message.Powerup.CasterID)
-			if err != nil {
-				h.logger.Error("failed to find team", zap.Error(err))
-				return
-			}
+// Returns the client's game if it still has to be told
+// about the client leaving, and whether the client was
+// still registered at all
+func (h *wsHub) removeLocked(client *wsClient) (*wsGame, bool) {
+	g, ok := h.games[client.gameID]
+	if !ok || !g.conns[client] {
+		return nil, false
+	}
 
-			for client := range h.Clients {
-				h.logger.Info("sending to client", zap.Any("client", client))
-				if client.gameID != caster.GameID {
-					continue
-				}
+	delete(g.conns, client)
+	if h.clients[client.conn] == client {
+		delete(h.clients, client.conn)
+	}
 
-				payload := wsPowerupPayload{
-					Powerup: message.Powerup,
-					Caster:  caster,
-				}
+	if len(g.conns) > 0 {
+		return g, true
+	}
 
-				h.logger.Info("sending payload",
-					zap.Any("payload", payload),
-				)
+	// Last client of the game on this replica
+	delete(h.games, client.gameID)
+	g.stop()
 
-				client.conn.Send(wsMsg{
-					Type: "pwp",
-					Data: payload,
-				})
-			}
-		case message := <-h.BroadcastCat:
-			h.logger.Info("broadcasting catch", zap.Any("message", message))
+	h.unsubscribe(client.gameID)
 
-			newRunner, err := h.teamRepo.FindOne(context.Background(), message.NewRunnerID)
-			if err != nil {
-				h.logger.Error("failed to find team", zap.Error(err))
-				return
-			}
+	return nil, true
+}
 
-			for client := range h.Clients {
-				h.logger.Info("sending to client", zap.Any("client", client))
-				if client.gameID != newRunner.GameID {
-					continue
-				}
+func (h *wsHub) removeConn(conn *websocket.Conn) {
+	if client := h.client(conn); client != nil {
+		h.remove(client)
+	}
+}
 
-				payload := wsCatchPayload{
-					NewRunner: newRunner,
-				}
+func (h *wsHub) subscribe(gameID string) {
+	if h.pubsub == nil {
+		return
+	}
+
+	if err := h.pubsub.Subscribe(context.Background(), domain.GameEventsChannel(gameID)); err != nil {
+		h.logger.Error("failed to subscribe to game events", zap.String("game_id", gameID), zap.Error(err))
+	}
+}
+
+func (h *wsHub) unsubscribe(gameID string) {
+	if h.pubsub == nil {
+		return
+	}
 
-				h.logger.Info("sending payload",
-					zap.Any("payload", payload),
-				)
+	if err := h.pubsub.Unsubscribe(context.Background(), domain.GameEventsChannel(gameID)); err != nil {
+		h.logger.Error("failed to unsubscribe from game events", zap.String("game_id", gameID), zap.Error(err))
+	}
+}
 
-				client.conn.Send(wsMsg{
-					Type: "cat",
-					Data: payload,
-				})
+// Hands an event to the game's actor, if the game
+// has any clients on this replica
+func (h *wsHub) deliver(gameID string, important bool, fn func(g *wsGame)) {
+	h.mu.Lock()
+	g, ok := h.games[gameID]
+	h.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if important {
+		g.mustDo(func() { fn(g) })
+	} else {
+		g.do(func() { fn(g) })
+	}
+}
+
+// Sends an event to every client of the game, no matter which
+// API replica they're connected to. Each replica delivers it to
+// its own clients once it comes back through Listen. Returns
+// the event's seq, or 0 if it couldn't be published.
+func (h *wsHub) Publish(ctx context.Context, gameID, typ string, msg interface{}) int64 {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		h.logger.Error("failed to marshal event", zap.String("type", typ), zap.Error(err))
+		return 0
+	}
+
+	seq, err := h.gameEventRepo.Publish(ctx, gameID, typ, data)
+	if err != nil {
+		h.logger.Error("failed to publish event",
+			zap.String("game_id", gameID),
+			zap.String("type", typ),
+			zap.Error(err),
+		)
+		return 0
+	}
+
+	return seq
+}
+
+// Receives game events from Redis and hands them to the games' actors
+func (h *wsHub) Listen(ctx context.Context) {
+	defer h.pubsub.Close()
+
+	ch := h.pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
 			}
+
+			var ev domain.GameEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &ev); err != nil {
+				h.logger.Error("failed to unmarshal event", zap.Error(err))
+				continue
+			}
+
+			gameID := strings.TrimPrefix(msg.Channel, domain.GameEventsChannel(""))
+
+			// Locations are sent often, so it's fine to lose one
+			important := ev.Type != domain.GameEventLocation
+			h.deliver(gameID, important, func(g *wsGame) {
+				if err := g.dispatch(&ev, g.clients); err != nil {
+					h.logger.Error("failed to dispatch event", zap.String("type", ev.Type), zap.Error(err))
+				}
+			})
 		}
 	}
 }
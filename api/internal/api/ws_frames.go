@@ -0,0 +1,282 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/peonii/inertia/internal/domain"
+	"github.com/pkgz/websocket"
+	"go.uber.org/zap"
+)
+
+// Frames sent by clients, besides "join"
+const (
+	wsFrameLocation  = "loc"
+	wsFrameHeartbeat = "hb"
+	wsFrameLive      = "live"
+)
+
+// The client picks the ID, it comes back in the ack or error
+type wsLocationFrame struct {
+	ID       string                `json:"id"`
+	Location domain.LocationCreate `json:"loc"`
+}
+
+type wsHeartbeatFrame struct {
+	ID string `json:"id"`
+}
+
+type wsLiveFrame struct {
+	ID string `json:"id"`
+	// Spectator invite, the host doesn't need one
+	Invite string `json:"invite"`
+	// Seconds to hold the live map back by
+	Delay int `json:"delay"`
+}
+
+func (a *api) wsJoinHandler(c *websocket.Conn, msg *websocket.Message) {
+	ctx := context.Background()
+
+	a.logger.Info("received join message",
+		zap.String("message", string(msg.Data)),
+	)
+
+	p := &wsAuthPayload{}
+	if err := json.Unmarshal(msg.Data, p); err != nil || p.GameID == "" {
+		if p.Version >= 2 {
+			c.Send(wsError("", wsErrBadRequest, "failed to decode join"))
+		} else {
+			c.Send("invalid")
+		}
+		return
+	}
+
+	u, token, err := a.getUserFromPayload(ctx, p)
+	if err != nil {
+		if p.Version >= 2 {
+			c.Send(wsError("", wsErrUnauthorized, "invalid token"))
+		} else {
+			c.Send("invalid")
+		}
+		return
+	}
+
+	if !token.Allows(domain.ScopeGamesRead) {
+		if p.Version >= 2 {
+			c.Send(wsError("", wsErrForbidden, "token is missing the "+domain.ScopeGamesRead+" scope"))
+		} else {
+			c.Send("invalid")
+		}
+		return
+	}
+
+	if !token.AllowsGame(p.GameID) {
+		if p.Version >= 2 {
+			c.Send(wsError("", wsErrForbidden, "not allowed in this game"))
+		} else {
+			c.Send("invalid")
+		}
+		return
+	}
+
+	access, err := a.wsGameAccess(ctx, u, p.GameID, p.Invite)
+	if err != nil || !access.Can(domain.GamePermissionView) {
+		if p.Version >= 2 {
+			c.Send(wsError("", wsErrForbidden, "not allowed in this game"))
+		} else {
+			c.Send("invalid")
+		}
+		return
+	}
+
+	a.logger.Info("attempting to register user",
+		zap.String("user", u.ID),
+	)
+
+	// The game's actor works out whether the user is a runner,
+	// and welcomes v2 clients once it has replayed what they missed
+	client := newWsClient(c, u, p.GameID)
+	client.token = token
+	client.access = access
+	client.version = p.Version
+	client.lastSeq = p.Seq
+
+	a.WsHub.add(client)
+
+	if p.Version < 2 {
+		c.Send("ok")
+	}
+}
+
+// Same as authorizeGame, without the HTTP bits
+func (a *api) wsGameAccess(ctx context.Context, user *domain.User, gameID string, invite string) (*domain.GameAccess, error) {
+	game, err := a.gameRepo.FindOne(ctx, gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	access, err := a.gameAccess(ctx, game, user)
+	if err != nil {
+		return nil, err
+	}
+
+	if invite != "" && !access.Can(domain.GamePermissionView) {
+		if _, err := a.useInvite(ctx, access, invite); err != nil {
+			return nil, err
+		}
+	}
+
+	return access, nil
+}
+
+func (a *api) wsLocationHandler(c *websocket.Conn, msg *websocket.Message) {
+	ctx := context.Background()
+
+	var frame wsLocationFrame
+	if err := json.Unmarshal(msg.Data, &frame); err != nil {
+		c.Send(wsError("", wsErrBadRequest, "failed to decode location"))
+		return
+	}
+
+	client := a.WsHub.client(c)
+	if client == nil {
+		c.Send(wsError(frame.ID, wsErrNotJoined, "join a game first"))
+		return
+	}
+	client.seen()
+
+	if !client.token.Allows(domain.ScopeLocation) {
+		client.reply(wsError(frame.ID, wsErrForbidden, "token is missing the "+domain.ScopeLocation+" scope"))
+		return
+	}
+
+	frame.Location.UserID = client.user.ID
+
+	// Spectators don't have a location worth sharing
+	if _, err := a.teamRepo.FindByGameUser(ctx, client.gameID, client.user.ID); err != nil {
+		client.reply(wsError(frame.ID, wsErrForbidden, "you're not in a team in this game"))
+		return
+	}
+
+	seq, err := a.shareLocation(ctx, client.gameID, &frame.Location)
+	var rejection *domain.LocationRejection
+	if errors.As(err, &rejection) {
+		client.reply(wsError(frame.ID, wsErrRejected, rejection.Error()))
+		return
+	}
+	if err != nil {
+		a.logger.Error("failed to update location", zap.String("user_id", client.user.ID), zap.Error(err))
+		client.reply(wsError(frame.ID, wsErrInternal, "failed to update location"))
+		return
+	}
+
+	client.reply(wsMsg{
+		Type: wsMsgAck,
+		Data: wsAckPayload{
+			Ref: frame.ID,
+			Seq: seq,
+		},
+	})
+}
+
+func (a *api) wsHeartbeatHandler(c *websocket.Conn, msg *websocket.Message) {
+	var frame wsHeartbeatFrame
+	if err := json.Unmarshal(msg.Data, &frame); err != nil {
+		c.Send(wsError("", wsErrBadRequest, "failed to decode heartbeat"))
+		return
+	}
+
+	client := a.WsHub.client(c)
+	if client == nil {
+		c.Send(wsError(frame.ID, wsErrNotJoined, "join a game first"))
+		return
+	}
+	client.seen()
+
+	client.reply(wsMsg{
+		Type: wsMsgAck,
+		Data: wsAckPayload{
+			Ref: frame.ID,
+		},
+	})
+}
+
+// Hosts and referees can watch everyone at once, spectators
+// no sooner than the game's spectator delay allows
+func (a *api) wsLiveHandler(c *websocket.Conn, msg *websocket.Message) {
+	ctx := context.Background()
+
+	var frame wsLiveFrame
+	if err := json.Unmarshal(msg.Data, &frame); err != nil {
+		c.Send(wsError("", wsErrBadRequest, "failed to decode live"))
+		return
+	}
+
+	client := a.WsHub.client(c)
+	if client == nil {
+		c.Send(wsError(frame.ID, wsErrNotJoined, "join a game first"))
+		return
+	}
+	client.seen()
+
+	if frame.Delay < 0 || frame.Delay > domain.MaxSpectatorDelay {
+		client.reply(wsError(frame.ID, wsErrBadRequest, "invalid delay"))
+		return
+	}
+
+	game, err := a.gameRepo.FindOne(ctx, client.gameID)
+	if err != nil {
+		client.reply(wsError(frame.ID, wsErrInternal, "failed to find game"))
+		return
+	}
+
+	// Worked out again, roles may have changed since the join
+	access, err := a.gameAccess(ctx, game, client.user)
+	if err != nil {
+		client.reply(wsError(frame.ID, wsErrInternal, "failed to check game access"))
+		return
+	}
+
+	if access.Team != nil {
+		client.reply(wsError(frame.ID, wsErrForbidden, "players can't watch the live map"))
+		return
+	}
+
+	delay := frame.Delay
+	if !access.Can(domain.GamePermissionViewAll) {
+		// Spectators that haven't been given the role still
+		// bring their invite along
+		spectator := access.Role == domain.GameRoleSpectator
+		if !spectator && frame.Invite != "" {
+			invite, err := a.useInvite(ctx, access, frame.Invite)
+			spectator = err == nil && invite.IsSpectator()
+		}
+
+		if !spectator {
+			client.reply(wsError(frame.ID, wsErrForbidden, "a spectator invite is required"))
+			return
+		}
+
+		rules, err := a.gameRulesRepo.FindByGameID(ctx, game.ID)
+		if err != nil {
+			client.reply(wsError(frame.ID, wsErrInternal, "failed to find game rules"))
+			return
+		}
+
+		delay = max(delay, rules.SpectatorDelay)
+	}
+
+	a.WsHub.deliver(client.gameID, true, func(g *wsGame) {
+		g.watch(client, time.Duration(delay)*time.Second)
+	})
+
+	client.reply(wsMsg{
+		Type: wsMsgAck,
+		Data: wsLiveAckPayload{
+			Ref:   frame.ID,
+			Delay: delay,
+		},
+	})
+}
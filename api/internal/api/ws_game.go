@@ -0,0 +1,990 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/peonii/inertia/internal/domain"
+	"go.uber.org/zap"
+)
+
+// How long cached teams and powerups are trusted before
+// they're loaded again
+const wsCacheTTL = time.Second * 30
+
+// Processes every event of a single game in its own goroutine,
+// so a busy or slow game never holds up the others. Only the
+// actor goroutine touches the fields below conns.
+type wsGame struct {
+	id  string
+	hub *wsHub
+
+	inbox   chan func()
+	stopped chan struct{}
+
+	// Guarded by hub.mu
+	conns map[*wsClient]bool
+
+	clients map[*wsClient]bool
+
+	game       *domain.Game
+	teams      map[string]*domain.Team
+	userTeams  map[string]*domain.Team
+	users      map[string]*domain.User
+	members    map[string][]*domain.User
+	powerups   []*domain.Powerup
+	rules      *domain.GameRules
+	teamsAt    time.Time
+	powerupsAt time.Time
+	rulesAt    time.Time
+	// The last tick of the reveal schedule that was sent out
+	revealedAt time.Time
+}
+
+func newWsGame(hub *wsHub, id string) *wsGame {
+	return &wsGame{
+		id:      id,
+		hub:     hub,
+		inbox:   make(chan func(), wsInboxSize),
+		stopped: make(chan struct{}),
+		conns:   make(map[*wsClient]bool),
+		clients: make(map[*wsClient]bool),
+	}
+}
+
+func (g *wsGame) run() {
+	huntTicker := time.NewTicker(huntInterval)
+	defer huntTicker.Stop()
+
+	heartbeatTicker := time.NewTicker(wsHeartbeatTimeout / 2)
+	defer heartbeatTicker.Stop()
+
+	liveTicker := time.NewTicker(wsLiveFlushInterval)
+	defer liveTicker.Stop()
+
+	revealTicker := time.NewTicker(wsRevealCheckInterval)
+	defer revealTicker.Stop()
+
+	for {
+		select {
+		case <-g.stopped:
+			return
+		case fn := <-g.inbox:
+			g.safely(fn)
+		case <-huntTicker.C:
+			g.safely(g.hunt)
+		case <-heartbeatTicker.C:
+			g.safely(g.reap)
+		case <-liveTicker.C:
+			g.safely(g.flush)
+		case <-revealTicker.C:
+			g.safely(g.reveal)
+		}
+	}
+}
+
+func (g *wsGame) stop() {
+	close(g.stopped)
+}
+
+// A bad event only loses that event, never the game
+func (g *wsGame) safely(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			g.hub.logger.Error("websocket game actor panicked",
+				zap.String("game_id", g.id),
+				zap.Any("panic", r),
+			)
+		}
+	}()
+
+	fn()
+}
+
+// Queues fn, dropping it if the game is too busy
+func (g *wsGame) do(fn func()) {
+	select {
+	case g.inbox <- fn:
+	default:
+		g.hub.logger.Warn("game inbox full, dropping event", zap.String("game_id", g.id))
+	}
+}
+
+// Queues fn, waiting a bit for room if the game is busy
+func (g *wsGame) mustDo(fn func()) {
+	timer := time.NewTimer(wsInboxTimeout)
+	defer timer.Stop()
+
+	select {
+	case g.inbox <- fn:
+	case <-g.stopped:
+	case <-timer.C:
+		g.hub.logger.Error("game inbox full, dropping important event", zap.String("game_id", g.id))
+	}
+}
+
+// Queues a message for the client. Slow clients lose messages and
+// get disconnected once they've lost too many.
+func (g *wsGame) send(client *wsClient, msg wsMsg) {
+	if msg.Seq != 0 {
+		// Already sent while resuming
+		if msg.Seq <= client.lastSeq {
+			return
+		}
+		client.lastSeq = msg.Seq
+	}
+
+	if client.backlog != nil {
+		client.backlog = append(client.backlog, msg)
+		return
+	}
+
+	if client.delay > 0 {
+		if len(client.delayed) >= wsMaxDelayed {
+			g.hub.logger.Warn("too many delayed messages, dropping",
+				zap.String("game_id", g.id),
+				zap.String("user_id", client.user.ID),
+			)
+			return
+		}
+
+		client.delayed = append(client.delayed, wsDelayedMsg{
+			at:  time.Now().Add(client.delay),
+			msg: msg,
+		})
+		return
+	}
+
+	g.enqueue(client, msg)
+}
+
+func (g *wsGame) enqueue(client *wsClient, msg wsMsg) {
+	select {
+	case client.send <- msg:
+	default:
+		client.dropped++
+		if client.dropped == wsMaxDropped {
+			g.hub.logger.Info("disconnecting slow client",
+				zap.String("game_id", g.id),
+				zap.String("user_id", client.user.ID),
+			)
+
+			go g.hub.remove(client)
+		}
+	}
+}
+
+// Sends the delayed messages that are due
+func (g *wsGame) flush() {
+	now := time.Now()
+
+	for client := range g.clients {
+		due := 0
+		for due < len(client.delayed) && !client.delayed[due].at.After(now) {
+			g.enqueue(client, client.delayed[due].msg)
+			due++
+		}
+
+		if due > 0 {
+			client.delayed = append(client.delayed[:0], client.delayed[due:]...)
+		}
+	}
+}
+
+func (g *wsGame) broadcast(to map[*wsClient]bool, msg wsMsg) {
+	for client := range to {
+		g.send(client, msg)
+	}
+}
+
+// Hands a game event to its handler, only sending
+// the resulting messages to the given clients
+func (g *wsGame) dispatch(ev *domain.GameEvent, to map[*wsClient]bool) error {
+	switch ev.Type {
+	case domain.GameEventLocation:
+		var msg wsLocationMsg
+		if err := json.Unmarshal(ev.Data, &msg); err != nil {
+			return err
+		}
+		g.location(msg, ev.Seq, to)
+	case domain.GameEventPowerup:
+		var msg wsPowerupMsg
+		if err := json.Unmarshal(ev.Data, &msg); err != nil {
+			return err
+		}
+		g.powerup(msg, ev.Seq, to)
+	case domain.GameEventCatch:
+		var msg wsCatchMsg
+		if err := json.Unmarshal(ev.Data, &msg); err != nil {
+			return err
+		}
+		g.catch(msg, ev.Seq, to)
+	case domain.GameEventStatus:
+		var msg wsStatusMsg
+		if err := json.Unmarshal(ev.Data, &msg); err != nil {
+			return err
+		}
+		if msg.Game == nil {
+			return nil
+		}
+		g.status(msg, ev.Seq, to)
+	case domain.GameEventSubmission:
+		var msg wsSubmissionMsg
+		if err := json.Unmarshal(ev.Data, &msg); err != nil {
+			return err
+		}
+		g.submission(msg, ev.Seq, to)
+	case domain.GameEventQuest:
+		var msg wsQuestMsg
+		if err := json.Unmarshal(ev.Data, &msg); err != nil {
+			return err
+		}
+		g.quest(msg, ev.Seq, to)
+	case domain.GameEventOutOfBounds:
+		var msg wsOutOfBoundsMsg
+		if err := json.Unmarshal(ev.Data, &msg); err != nil {
+			return err
+		}
+		g.outOfBounds(msg, ev.Seq, to)
+	case domain.GameEventTeam:
+		var msg wsTeamMsg
+		if err := json.Unmarshal(ev.Data, &msg); err != nil {
+			return err
+		}
+		g.team(msg, ev.Seq, to)
+	default:
+		return fmt.Errorf("unknown event type %q", ev.Type)
+	}
+
+	return nil
+}
+
+func (g *wsGame) refreshTeams(ctx context.Context) error {
+	teams, err := g.hub.teamRepo.FindByGameID(ctx, g.id)
+	if err != nil {
+		return err
+	}
+
+	g.teams = make(map[string]*domain.Team, len(teams))
+	g.userTeams = make(map[string]*domain.Team)
+	g.users = make(map[string]*domain.User)
+	g.members = make(map[string][]*domain.User, len(teams))
+
+	for _, team := range teams {
+		members, err := g.hub.teamRepo.FindMembers(ctx, team.ID)
+		if err != nil {
+			return err
+		}
+
+		g.teams[team.ID] = team
+		g.members[team.ID] = members
+		for _, member := range members {
+			g.users[member.ID] = member
+			g.userTeams[member.ID] = team
+		}
+	}
+
+	g.teamsAt = time.Now()
+
+	g.updateRoles()
+
+	return nil
+}
+
+func (g *wsGame) roleOf(client *wsClient) string {
+	if team, ok := g.userTeams[client.user.ID]; ok {
+		if team.IsRunner {
+			return wsRoleRunner
+		}
+		return wsRoleHunter
+	}
+
+	if client.access != nil && client.access.Can(domain.GamePermissionViewAll) {
+		return wsRoleHost
+	}
+
+	return wsRoleSpectator
+}
+
+// Works out every client's role again, letting the clients
+// whose role changed know about it
+func (g *wsGame) updateRoles() {
+	for client := range g.clients {
+		role := g.roleOf(client)
+		if role == client.role {
+			continue
+		}
+
+		// Nothing to tell on join, the welcome has the role
+		changed := client.role != ""
+		client.role = role
+
+		// Players don't get to watch the live map
+		if client.live && (role == wsRoleRunner || role == wsRoleHunter) {
+			client.live = false
+			client.delay = 0
+			client.delayed = nil
+		}
+
+		if changed && client.version >= 2 {
+			g.send(client, wsMsg{
+				Type: wsMsgRole,
+				Data: wsRolePayload{
+					Role: role,
+					Team: g.userTeams[client.user.ID],
+				},
+			})
+		}
+	}
+}
+
+func (g *wsGame) refreshPowerups(ctx context.Context) error {
+	powerups, err := g.hub.powerupRepo.GetActiveByGameID(ctx, g.id)
+	if err != nil {
+		return err
+	}
+
+	g.powerups = powerups
+	g.powerupsAt = time.Now()
+
+	return nil
+}
+
+// Loads whatever isn't cached or has gone stale
+func (g *wsGame) ensure(ctx context.Context) error {
+	// Only needed for the host, which doesn't change
+	if g.game == nil {
+		game, err := g.hub.gameRepo.FindOne(ctx, g.id)
+		if err != nil {
+			return err
+		}
+		g.game = game
+	}
+
+	if g.teams == nil || time.Since(g.teamsAt) > wsCacheTTL {
+		if err := g.refreshTeams(ctx); err != nil {
+			return err
+		}
+	}
+
+	if g.powerups == nil || time.Since(g.powerupsAt) > wsCacheTTL {
+		if err := g.refreshPowerups(ctx); err != nil {
+			return err
+		}
+	}
+
+	if g.rules == nil || time.Since(g.rulesAt) > wsCacheTTL {
+		rules, err := g.hub.gameRulesRepo.FindByGameID(ctx, g.id)
+		if err != nil {
+			return err
+		}
+		g.rules = rules
+		g.rulesAt = time.Now()
+	}
+
+	return nil
+}
+
+// Whether hunters only see runners at the ticks of the reveal schedule
+func (g *wsGame) scheduled() bool {
+	return g.rules != nil && g.rules.HasRevealSchedule()
+}
+
+// Powerups that haven't run out since they were cached
+func (g *wsGame) activePowerups() []*domain.Powerup {
+	now := time.Now()
+
+	active := make([]*domain.Powerup, 0, len(g.powerups))
+	for _, p := range g.powerups {
+		if now.Before(p.EndsAt) {
+			active = append(active, p)
+		}
+	}
+
+	return active
+}
+
+func (g *wsGame) join(client *wsClient) {
+	g.clients[client] = true
+
+	// The client may have just joined a team
+	g.teams = nil
+	if err := g.ensure(context.Background()); err != nil {
+		g.hub.logger.Error("failed to load game state", zap.String("game_id", g.id), zap.Error(err))
+	}
+
+	if client.version >= 2 {
+		g.resume(client)
+	}
+}
+
+// Welcomes the client and replays the events it missed since
+// its last seq. They're written in one go, so a long replay
+// doesn't count as the client being slow.
+func (g *wsGame) resume(client *wsClient) {
+	ctx := context.Background()
+
+	welcome := wsWelcomePayload{
+		Version: wsProtocolVersion,
+		User:    client.user,
+		Role:    client.role,
+		Resumed: true,
+	}
+
+	latest, err := g.hub.gameEventRepo.Latest(ctx, g.id)
+	if err != nil {
+		g.hub.logger.Error("failed to find latest event", zap.String("game_id", g.id), zap.Error(err))
+		welcome.Resumed = false
+	}
+	welcome.Seq = latest
+
+	events := []*domain.GameEvent{}
+	if client.lastSeq > 0 && err == nil {
+		missed, complete, err := g.hub.gameEventRepo.Since(ctx, g.id, client.lastSeq)
+		if err != nil {
+			g.hub.logger.Error("failed to find missed events", zap.String("game_id", g.id), zap.Error(err))
+			complete = false
+		}
+
+		events = missed
+		welcome.Resumed = complete
+	}
+
+	client.backlog = []wsMsg{{Type: wsMsgWelcome, Data: welcome}}
+
+	to := map[*wsClient]bool{client: true}
+	for _, ev := range events {
+		if err := g.dispatch(ev, to); err != nil {
+			g.hub.logger.Error("failed to replay event", zap.String("type", ev.Type), zap.Error(err))
+		}
+	}
+
+	backlog := client.backlog
+	client.backlog = nil
+
+	client.replay <- backlog
+}
+
+// Disconnects clients that stopped sending heartbeats
+func (g *wsGame) reap() {
+	deadline := time.Now().Add(-wsHeartbeatTimeout).UnixMilli()
+
+	for client := range g.clients {
+		if client.version < 2 || client.lastSeen.Load() > deadline {
+			continue
+		}
+
+		g.hub.logger.Info("disconnecting silent client",
+			zap.String("game_id", g.id),
+			zap.String("user_id", client.user.ID),
+		)
+
+		go g.hub.remove(client)
+	}
+}
+
+func (g *wsGame) leave(client *wsClient) {
+	delete(g.clients, client)
+}
+
+func (g *wsGame) location(message wsLocationMsg, seq int64, to map[*wsClient]bool) {
+	ctx := context.Background()
+	if err := g.ensure(ctx); err != nil {
+		g.hub.logger.Error("failed to load game state", zap.String("game_id", g.id), zap.Error(err))
+		return
+	}
+
+	sender, ok := g.users[message.UserID]
+	if !ok {
+		// Probably joined a team after we cached them
+		if err := g.refreshTeams(ctx); err != nil {
+			g.hub.logger.Error("failed to load teams", zap.String("game_id", g.id), zap.Error(err))
+			return
+		}
+
+		if sender, ok = g.users[message.UserID]; !ok {
+			g.hub.logger.Info("location from a user without a team", zap.String("user_id", message.UserID))
+			return
+		}
+	}
+
+	senderTeam := g.userTeams[message.UserID]
+	powerups := g.activePowerups()
+
+	payload := wsLocationPayload{
+		Location: message.Location,
+		User:     sender,
+		Team:     senderTeam,
+	}
+
+	for client := range to {
+		if client.user.ID == message.UserID {
+			continue // don't send location updates to the user that sent it
+		}
+
+		if !g.canSee(client, senderTeam, powerups) {
+			continue
+		}
+
+		g.send(client, wsMsg{
+			Seq:  seq,
+			Type: domain.GameEventLocation,
+			Data: payload,
+		})
+	}
+}
+
+// Whether the client gets to see the locations of the team's members
+func (g *wsGame) canSee(client *wsClient, sender *domain.Team, powerups []*domain.Powerup) bool {
+	if client.live || client.role == wsRoleHost {
+		return true
+	}
+
+	// Spectators only get locations through the live map,
+	// which holds them back by the spectator delay
+	if client.role == wsRoleSpectator {
+		return false
+	}
+
+	team := g.userTeams[client.user.ID]
+
+	override := false
+	neverShow := false
+	hunted := false
+
+	for _, powerup := range powerups {
+		switch {
+		// Whoever cast it, the casting team included
+		case powerup.Type == domain.PowerupTypeHunt:
+			if sender.IsRunner {
+				hunted = true
+			}
+		case team != nil && powerup.CasterID == team.ID:
+			if powerup.Type == domain.PowerupTypeRevealHunters {
+				override = true
+			}
+		case powerup.CasterID == sender.ID:
+			if powerup.Type == domain.PowerupTypeHideTracker {
+				neverShow = true
+			}
+		}
+	}
+
+	// Hunt beats hide_tracker
+	if neverShow && !hunted {
+		return false
+	}
+
+	// Only hunters and spectators see everyone else
+	if client.role == wsRoleRunner && !override {
+		return false
+	}
+
+	// With a reveal schedule, the other side only shows up at the
+	// ticks (see reveal), unless runners are being hunted
+	if g.scheduled() && team != sender {
+		if client.role == wsRoleRunner || (sender.IsRunner && !hunted) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (g *wsGame) powerup(message wsPowerupMsg, seq int64, to map[*wsClient]bool) {
+	ctx := context.Background()
+	if err := g.refreshPowerups(ctx); err != nil {
+		g.hub.logger.Error("failed to load powerups", zap.String("game_id", g.id), zap.Error(err))
+	}
+
+	if err := g.ensure(ctx); err != nil {
+		g.hub.logger.Error("failed to load game state", zap.String("game_id", g.id), zap.Error(err))
+		return
+	}
+
+	caster, ok := g.teams[message.Powerup.CasterID]
+	if !ok {
+		g.hub.logger.Error("powerup cast by unknown team", zap.String("team_id", message.Powerup.CasterID))
+		return
+	}
+
+	g.broadcast(to, wsMsg{
+		Seq:  seq,
+		Type: domain.GameEventPowerup,
+		Data: wsPowerupPayload{
+			Powerup: message.Powerup,
+			Caster:  caster,
+		},
+	})
+}
+
+func (g *wsGame) catch(message wsCatchMsg, seq int64, to map[*wsClient]bool) {
+	// Runners just changed
+	if err := g.refreshTeams(context.Background()); err != nil {
+		g.hub.logger.Error("failed to load teams", zap.String("game_id", g.id), zap.Error(err))
+		return
+	}
+
+	newRunner, ok := g.teams[message.NewRunnerID]
+	if !ok {
+		g.hub.logger.Error("catch by unknown team", zap.String("team_id", message.NewRunnerID))
+		return
+	}
+
+	g.broadcast(to, wsMsg{
+		Seq:  seq,
+		Type: domain.GameEventCatch,
+		Data: wsCatchPayload{
+			NewRunner: newRunner,
+		},
+	})
+}
+
+// Switches the client to the live map, starting it off with
+// everyone's last location and the active powerups
+func (g *wsGame) watch(client *wsClient, delay time.Duration) {
+	if !g.clients[client] {
+		return
+	}
+
+	client.live = true
+	client.delay = delay
+
+	ctx := context.Background()
+	if err := g.ensure(ctx); err != nil {
+		g.hub.logger.Error("failed to load game state", zap.String("game_id", g.id), zap.Error(err))
+		return
+	}
+
+	for _, team := range g.teams {
+		for _, member := range g.members[team.ID] {
+			loc, err := g.hub.locationRepo.GetUserLatest(ctx, member.ID)
+			if err != nil {
+				continue // no location yet
+			}
+
+			g.send(client, wsMsg{
+				Type: domain.GameEventLocation,
+				Data: wsLocationPayload{
+					Location: loc.Create(),
+					Team:     team,
+					User:     member,
+				},
+			})
+		}
+	}
+
+	for _, powerup := range g.activePowerups() {
+		g.send(client, wsMsg{
+			Type: domain.GameEventPowerup,
+			Data: wsPowerupPayload{
+				Powerup: powerup,
+				Caster:  g.teams[powerup.CasterID],
+			},
+		})
+	}
+}
+
+// Quest completions give away where the team is, so
+// only the live map and the host get them
+func (g *wsGame) quest(message wsQuestMsg, seq int64, to map[*wsClient]bool) {
+	if err := g.ensure(context.Background()); err != nil {
+		g.hub.logger.Error("failed to load game state", zap.String("game_id", g.id), zap.Error(err))
+		return
+	}
+
+	msg := wsMsg{
+		Seq:  seq,
+		Type: domain.GameEventQuest,
+		Data: wsQuestPayload{
+			Quest: message.Quest,
+			Team:  g.teams[message.TeamID],
+		},
+	}
+
+	for client := range to {
+		if !client.live && client.role != wsRoleHost {
+			continue
+		}
+
+		g.send(client, msg)
+	}
+}
+
+// The player's team and the host are told when someone leaves the
+// play area. With the reveal penalty, every player gets their location.
+func (g *wsGame) outOfBounds(message wsOutOfBoundsMsg, seq int64, to map[*wsClient]bool) {
+	ctx := context.Background()
+	if err := g.ensure(ctx); err != nil {
+		g.hub.logger.Error("failed to load game state", zap.String("game_id", g.id), zap.Error(err))
+		return
+	}
+
+	team := g.userTeams[message.UserID]
+	if team == nil {
+		return
+	}
+
+	msg := wsMsg{
+		Seq:  seq,
+		Type: domain.GameEventOutOfBounds,
+		Data: wsOutOfBoundsPayload{
+			Location: message.Location,
+			Team:     team,
+			User:     g.users[message.UserID],
+			Penalty:  message.Penalty,
+			Fine:     message.Fine,
+		},
+	}
+
+	for client := range to {
+		involved := client.live || client.role == wsRoleHost || g.userTeams[client.user.ID] == team
+		// Spectators only see the reveal on the live map
+		revealed := message.Penalty == domain.OutOfBoundsPenaltyReveal && client.role != wsRoleSpectator
+		if !involved && !revealed {
+			continue
+		}
+
+		g.send(client, msg)
+	}
+}
+
+// Someone joined a team, or a team was created
+func (g *wsGame) team(message wsTeamMsg, seq int64, to map[*wsClient]bool) {
+	if err := g.refreshTeams(context.Background()); err != nil {
+		g.hub.logger.Error("failed to load teams", zap.String("game_id", g.id), zap.Error(err))
+		return
+	}
+
+	team, ok := g.teams[message.TeamID]
+	if !ok {
+		g.hub.logger.Error("unknown team", zap.String("team_id", message.TeamID))
+		return
+	}
+
+	g.broadcast(to, wsMsg{
+		Seq:  seq,
+		Type: domain.GameEventTeam,
+		Data: wsTeamPayload{
+			Team: team,
+			User: g.users[message.UserID],
+		},
+	})
+}
+
+func (g *wsGame) status(message wsStatusMsg, seq int64, to map[*wsClient]bool) {
+	g.game = message.Game
+
+	g.broadcast(to, wsMsg{
+		Seq:  seq,
+		Type: domain.GameEventStatus,
+		Data: wsStatusPayload{
+			Game: message.Game,
+			From: message.From,
+		},
+	})
+}
+
+func (g *wsGame) submission(message wsSubmissionMsg, seq int64, to map[*wsClient]bool) {
+	if err := g.ensure(context.Background()); err != nil {
+		g.hub.logger.Error("failed to load game state", zap.String("game_id", g.id), zap.Error(err))
+		return
+	}
+
+	// Only the submitting team and the host get to see submissions
+	recipients := map[string]bool{g.game.HostID: true}
+	for _, member := range g.members[message.Submission.TeamID] {
+		recipients[member.ID] = true
+	}
+
+	for client := range to {
+		if !recipients[client.user.ID] && client.role != wsRoleHost {
+			continue
+		}
+
+		g.send(client, wsMsg{
+			Seq:  seq,
+			Type: domain.GameEventSubmission,
+			Data: wsSubmissionPayload{
+				Submission: message.Submission,
+			},
+		})
+	}
+}
+
+// Pushes the latest location of every runner to the hunters
+// while hunt is active, regardless of hide_tracker
+func (g *wsGame) hunt() {
+	if len(g.clients) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	if err := g.ensure(ctx); err != nil {
+		g.hub.logger.Error("failed to load game state", zap.String("game_id", g.id), zap.Error(err))
+		return
+	}
+
+	active := false
+	for _, powerup := range g.activePowerups() {
+		if powerup.Type == domain.PowerupTypeHunt {
+			active = true
+			break
+		}
+	}
+
+	if !active {
+		return
+	}
+
+	for _, team := range g.teams {
+		if !team.IsRunner {
+			continue
+		}
+
+		for _, member := range g.members[team.ID] {
+			loc, err := g.hub.locationRepo.GetUserLatest(ctx, member.ID)
+			if err != nil {
+				continue // no location yet
+			}
+
+			msg := wsMsg{
+				Type: domain.GameEventLocation,
+				Data: wsLocationPayload{
+					Location: loc.Create(),
+					Team:     team,
+					User:     member,
+				},
+			}
+
+			for client := range g.clients {
+				// Spectators off the live map don't get locations
+				if client.role == wsRoleRunner || (client.role == wsRoleSpectator && !client.live) {
+					continue
+				}
+
+				g.send(client, msg)
+			}
+		}
+	}
+}
+
+// Shows where everyone on the other side is at each tick of the reveal
+// schedule. Hunters get the runners and runners get
+// the hunters if they cast reveal_hunters, leaving out teams hiding
+// behind hide_tracker. During a hunt runners are tracked anyway, so
+// hunters only get told when the next tick is.
+func (g *wsGame) reveal() {
+	if len(g.clients) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	if err := g.ensure(ctx); err != nil {
+		g.hub.logger.Error("failed to load game state", zap.String("game_id", g.id), zap.Error(err))
+		return
+	}
+
+	if !g.scheduled() || !g.game.IsRunning() {
+		return
+	}
+
+	now := time.Now()
+	tick := g.rules.LastReveal(g.game.TimeStart, now)
+	if !tick.After(g.revealedAt) {
+		return
+	}
+	g.revealedAt = tick
+
+	// Started or stalled past the tick, wait for the next one
+	if now.Sub(tick) > wsRevealGrace {
+		return
+	}
+
+	hunting := false
+	hidden := map[string]bool{}
+	revealHunters := map[string]bool{}
+	for _, powerup := range g.activePowerups() {
+		switch powerup.Type {
+		case domain.PowerupTypeHunt:
+			hunting = true
+		case domain.PowerupTypeHideTracker:
+			hidden[powerup.CasterID] = true
+		case domain.PowerupTypeRevealHunters:
+			revealHunters[powerup.CasterID] = true
+		}
+	}
+
+	runners := []wsLocationPayload{}
+	hunters := []wsLocationPayload{}
+	hiddenRunners := []*domain.Team{}
+	hiddenHunters := []*domain.Team{}
+	for _, team := range g.teams {
+		if team.IsRunner && hunting {
+			continue
+		}
+
+		if hidden[team.ID] {
+			if team.IsRunner {
+				hiddenRunners = append(hiddenRunners, team)
+			} else {
+				hiddenHunters = append(hiddenHunters, team)
+			}
+			continue
+		}
+
+		for _, member := range g.members[team.ID] {
+			loc, err := g.hub.locationRepo.GetUserLatest(ctx, member.ID)
+			if err != nil {
+				continue // no location yet
+			}
+
+			payload := wsLocationPayload{
+				Location: loc.Create(),
+				Team:     team,
+				User:     member,
+			}
+
+			if team.IsRunner {
+				runners = append(runners, payload)
+			} else {
+				hunters = append(hunters, payload)
+			}
+		}
+	}
+
+	next := g.rules.NextReveal(g.game.TimeStart, now)
+	for client := range g.clients {
+		// They see everything as it happens, spectators
+		// only through the live map
+		if client.live || client.role == wsRoleHost || client.role == wsRoleSpectator {
+			continue
+		}
+
+		payload := wsRevealPayload{
+			Locations: runners,
+			Hidden:    hiddenRunners,
+			Next:      next,
+		}
+
+		if client.role == wsRoleRunner {
+			team := g.userTeams[client.user.ID]
+			if team == nil || !revealHunters[team.ID] {
+				continue
+			}
+
+			payload = wsRevealPayload{
+				Locations: hunters,
+				Hidden:    hiddenHunters,
+				Next:      next,
+			}
+		}
+
+		g.send(client, wsMsg{
+			Type: wsMsgReveal,
+			Data: payload,
+		})
+	}
+}
@@ -0,0 +1,433 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/peonii/inertia/internal/domain"
+	"github.com/peonii/inertia/internal/repository"
+	"go.uber.org/zap"
+)
+
+// Runs the hub against in-memory games without Postgres or Redis, to
+// check that slow and broken games don't hold up the healthy ones
+type hubLoadConfig struct {
+	// Healthy games, these are the ones we measure
+	Games          int
+	ClientsPerGame int
+	// Location updates sent to each game
+	Events   int
+	Interval time.Duration
+
+	// Games where every repository lookup takes SlowLatency.
+	// They get powerups, which always hit the repository.
+	SlowGames   int
+	SlowLatency time.Duration
+	// Games where every repository lookup fails
+	BrokenGames int
+	// Clients in each healthy game that take SlowLatency per write
+	SlowClients int
+}
+
+type hubLoadResult struct {
+	Sent      int
+	Expected  int
+	Delivered int
+
+	// Slow clients the hub disconnected
+	Disconnected int
+	SlowClients  int
+
+	P50 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+// In-memory state shared by all the fake repositories
+type hubLoadState struct {
+	latency time.Duration
+	slow    map[string]bool
+	broken  map[string]bool
+
+	teams   map[string][]*domain.Team
+	members map[string][]*domain.User
+	// Team ID to game ID
+	teamGames map[string]string
+}
+
+var errHubLoadBroken = errors.New("broken game")
+
+func (s *hubLoadState) lookup(gameID string) error {
+	if s.broken[gameID] {
+		return errHubLoadBroken
+	}
+
+	if s.slow[gameID] {
+		time.Sleep(s.latency)
+	}
+
+	return nil
+}
+
+type hubLoadTeamRepo struct {
+	repository.TeamRepository
+	*hubLoadState
+}
+
+func (r *hubLoadTeamRepo) FindByGameID(ctx context.Context, gameID string) ([]*domain.Team, error) {
+	if err := r.lookup(gameID); err != nil {
+		return nil, err
+	}
+
+	return r.teams[gameID], nil
+}
+
+func (r *hubLoadTeamRepo) FindMembers(ctx context.Context, teamID string) ([]*domain.User, error) {
+	if err := r.lookup(r.teamGames[teamID]); err != nil {
+		return nil, err
+	}
+
+	return r.members[teamID], nil
+}
+
+type hubLoadPowerupRepo struct {
+	repository.PowerupRepository
+	*hubLoadState
+}
+
+func (r *hubLoadPowerupRepo) GetActiveByGameID(ctx context.Context, gameID string) ([]*domain.Powerup, error) {
+	if err := r.lookup(gameID); err != nil {
+		return nil, err
+	}
+
+	return []*domain.Powerup{}, nil
+}
+
+type hubLoadGameRepo struct {
+	repository.GameRepository
+	*hubLoadState
+}
+
+func (r *hubLoadGameRepo) FindOne(ctx context.Context, id string) (*domain.Game, error) {
+	if err := r.lookup(id); err != nil {
+		return nil, err
+	}
+
+	return &domain.Game{ID: id}, nil
+}
+
+type hubLoadLocationRepo struct {
+	repository.LocationRepository
+}
+
+func (r *hubLoadLocationRepo) GetUserLatest(ctx context.Context, userID string) (*domain.Location, error) {
+	return nil, errors.New("no location")
+}
+
+type hubLoadGameEventRepo struct {
+	repository.GameEventRepository
+}
+
+type hubLoadGameRulesRepo struct {
+	repository.GameRulesRepository
+}
+
+func (r *hubLoadGameRulesRepo) FindByGameID(ctx context.Context, gameID string) (*domain.GameRules, error) {
+	return domain.DefaultGameRules(gameID), nil
+}
+
+// Records how long location updates took to get to the client.
+// The time they were sent at is smuggled in the latitude.
+type hubLoadConn struct {
+	start   time.Time
+	latency time.Duration
+
+	mu        *sync.Mutex
+	latencies *[]time.Duration
+	closed    atomic.Bool
+}
+
+func (c *hubLoadConn) Send(data interface{}) error {
+	if c.closed.Load() {
+		return errors.New("connection closed")
+	}
+
+	// Slow clients are only there to be cut off, we don't measure them
+	if c.latency > 0 {
+		time.Sleep(c.latency)
+		return nil
+	}
+
+	msg, ok := data.(wsMsg)
+	if !ok {
+		return nil
+	}
+
+	payload, ok := msg.Data.(wsLocationPayload)
+	if !ok {
+		return nil
+	}
+
+	sentAt := time.Duration(payload.Location.Lat) * time.Microsecond
+	took := time.Since(c.start) - sentAt
+
+	c.mu.Lock()
+	*c.latencies = append(*c.latencies, took)
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *hubLoadConn) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+func runHubLoad(t testing.TB, cfg hubLoadConfig) *hubLoadResult {
+	t.Helper()
+
+	state := &hubLoadState{
+		latency:   cfg.SlowLatency,
+		slow:      make(map[string]bool),
+		broken:    make(map[string]bool),
+		teams:     make(map[string][]*domain.Team),
+		members:   make(map[string][]*domain.User),
+		teamGames: make(map[string]string),
+	}
+
+	// The hub logs every failed lookup of the broken games
+	h := newWsHub(zap.NewNop(),
+		&hubLoadGameRepo{hubLoadState: state},
+		&hubLoadPowerupRepo{hubLoadState: state},
+		&hubLoadTeamRepo{hubLoadState: state},
+		&hubLoadLocationRepo{},
+		// Test clients are on v1, so they never resume
+		&hubLoadGameEventRepo{},
+		&hubLoadGameRulesRepo{},
+	)
+
+	start := time.Now()
+
+	var mu sync.Mutex
+	latencies := []time.Duration{}
+
+	type hubLoadGame struct {
+		id     string
+		runner *domain.User
+		kind   string
+	}
+
+	games := []*hubLoadGame{}
+	clients := []*wsClient{}
+	slowConns := []*hubLoadConn{}
+
+	// The state has to be complete before the first client joins,
+	// the games' actors read it from then on
+
+	// Each game has a team of runners with a single member sending
+	// locations, and a team of hunters receiving them
+	addGame := func(kind string, slowClients int) {
+		gid := fmt.Sprintf("%s-%d", kind, len(games))
+
+		runnerTeam := &domain.Team{ID: gid + "-runners", GameID: gid, IsRunner: true}
+		hunterTeam := &domain.Team{ID: gid + "-hunters", GameID: gid}
+		runner := &domain.User{ID: gid + "-runner"}
+
+		hunters := make([]*domain.User, cfg.ClientsPerGame)
+		for i := range hunters {
+			hunters[i] = &domain.User{ID: fmt.Sprintf("%s-hunter-%d", gid, i)}
+		}
+
+		state.teams[gid] = []*domain.Team{runnerTeam, hunterTeam}
+		state.members[runnerTeam.ID] = []*domain.User{runner}
+		state.members[hunterTeam.ID] = hunters
+		state.teamGames[runnerTeam.ID] = gid
+		state.teamGames[hunterTeam.ID] = gid
+
+		switch kind {
+		case "slow":
+			state.slow[gid] = true
+		case "broken":
+			state.broken[gid] = true
+		}
+
+		games = append(games, &hubLoadGame{id: gid, runner: runner, kind: kind})
+
+		for i, hunter := range hunters {
+			conn := &hubLoadConn{start: start, mu: &mu, latencies: &latencies}
+			if i < slowClients {
+				conn.latency = cfg.SlowLatency
+				slowConns = append(slowConns, conn)
+			}
+
+			clients = append(clients, newWsClient(conn, hunter, gid))
+		}
+	}
+
+	for i := 0; i < cfg.Games; i++ {
+		addGame("fast", cfg.SlowClients)
+	}
+	for i := 0; i < cfg.SlowGames; i++ {
+		addGame("slow", 0)
+	}
+	for i := 0; i < cfg.BrokenGames; i++ {
+		addGame("broken", 0)
+	}
+
+	for _, client := range clients {
+		h.add(client)
+	}
+
+	var sent atomic.Int64
+	var wg sync.WaitGroup
+
+	for _, game := range games {
+		wg.Add(1)
+		go func(game *hubLoadGame) {
+			defer wg.Done()
+
+			for i := 0; i < cfg.Events; i++ {
+				switch game.kind {
+				case "slow":
+					msg := wsPowerupMsg{
+						Powerup: &domain.Powerup{CasterID: game.id + "-runners"},
+						GameID:  game.id,
+					}
+					h.deliver(game.id, true, func(g *wsGame) { g.powerup(msg, 0, g.clients) })
+				default:
+					msg := wsLocationMsg{
+						Location: domain.LocationCreate{
+							Lat:    float64(time.Since(start).Microseconds()),
+							UserID: game.runner.ID,
+						},
+						UserID: game.runner.ID,
+						GameID: game.id,
+					}
+					h.deliver(game.id, false, func(g *wsGame) { g.location(msg, 0, g.clients) })
+
+					if game.kind == "fast" {
+						sent.Add(1)
+					}
+				}
+
+				time.Sleep(cfg.Interval)
+			}
+		}(game)
+	}
+
+	wg.Wait()
+
+	// Every healthy client that's still connected should get every update
+	fastClients := cfg.ClientsPerGame - cfg.SlowClients
+	expected := int(sent.Load()) * fastClients
+
+	deadline := time.Now().Add(time.Second * 10)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(latencies)
+		mu.Unlock()
+
+		if n >= expected {
+			break
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	disconnected := 0
+	for _, conn := range slowConns {
+		if conn.closed.Load() {
+			disconnected++
+		}
+	}
+
+	for _, client := range clients {
+		h.remove(client)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	res := &hubLoadResult{
+		Sent:         int(sent.Load()),
+		Expected:     expected,
+		Delivered:    len(latencies),
+		Disconnected: disconnected,
+		SlowClients:  len(slowConns),
+	}
+
+	if len(latencies) > 0 {
+		res.P50 = latencies[len(latencies)*50/100]
+		res.P99 = latencies[len(latencies)*99/100]
+		res.Max = latencies[len(latencies)-1]
+	}
+
+	return res
+}
+
+func checkHubLoad(t testing.TB, res *hubLoadResult) {
+	t.Helper()
+
+	if res.Delivered != res.Expected {
+		t.Errorf("healthy clients got %d/%d location updates", res.Delivered, res.Expected)
+	}
+
+	if res.Disconnected != res.SlowClients {
+		t.Errorf("%d/%d slow clients were disconnected", res.Disconnected, res.SlowClients)
+	}
+}
+
+// Slow and broken games, and slow clients, don't hold up anyone else
+func TestHubDropsSlowClients(t *testing.T) {
+	cfg := hubLoadConfig{
+		Games:          20,
+		ClientsPerGame: 5,
+		Events:         100,
+		Interval:       time.Millisecond * 5,
+		SlowGames:      5,
+		SlowLatency:    time.Millisecond * 200,
+		BrokenGames:    5,
+		SlowClients:    1,
+	}
+
+	if testing.Short() {
+		cfg.Games = 5
+		cfg.SlowGames = 1
+		cfg.BrokenGames = 1
+	}
+
+	res := runHubLoad(t, cfg)
+	checkHubLoad(t, res)
+
+	t.Logf("delivered %d messages, p50 %s, p99 %s, max %s", res.Delivered, res.P50, res.P99, res.Max)
+}
+
+// go test ./internal/api -run '^$' -bench Hub -benchtime 1x
+// for the numbers at the size of a busy evening
+func BenchmarkHubLoad(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		res := runHubLoad(b, hubLoadConfig{
+			Games:          200,
+			ClientsPerGame: 10,
+			Events:         200,
+			Interval:       time.Millisecond * 10,
+			SlowGames:      20,
+			SlowLatency:    time.Millisecond * 200,
+			BrokenGames:    20,
+			SlowClients:    1,
+		})
+		checkHubLoad(b, res)
+
+		b.ReportMetric(float64(res.P50.Microseconds()), "p50-us")
+		b.ReportMetric(float64(res.P99.Microseconds()), "p99-us")
+		b.ReportMetric(float64(res.Max.Microseconds()), "max-us")
+	}
+}
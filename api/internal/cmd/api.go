@@ -2,7 +2,12 @@ package cmd
 
 import (
 	"context"
+	"expvar"
+	"fmt"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/adjust/rmq/v5"
 	"github.com/golang-migrate/migrate/v4"
@@ -10,6 +15,8 @@ import (
 	_ "github.com/golang-migrate/migrate/v4/source/file"       // required for file://
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/peonii/inertia/internal/api"
+	"github.com/peonii/inertia/internal/domain"
+	"github.com/peonii/inertia/internal/provider"
 	"github.com/redis/go-redis/v9"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
@@ -25,9 +32,28 @@ func APICmd(ctx context.Context) *cobra.Command {
 			}
 
 			cfg := &api.APIConfig{
-				DiscordClientID:     os.Getenv("DISCORD_CLIENT_ID"),
-				DiscordClientSecret: os.Getenv("DISCORD_CLIENT_SECRET"),
-				DiscordRedirectURI:  os.Getenv("DISCORD_REDIRECT_URI"),
+				Providers:    providerConfigs(),
+				BlobDir:      os.Getenv("BLOB_DIR"),
+				JWTAlgorithm: os.Getenv("JWT_ALGORITHM"),
+				JWTRotation:  domain.DefaultSigningKeyRotation,
+				JWTSecret:    os.Getenv("JWT_SECRET"),
+			}
+
+			if cfg.BlobDir == "" {
+				cfg.BlobDir = "data/blobs"
+			}
+
+			if cfg.JWTAlgorithm == "" {
+				cfg.JWTAlgorithm = domain.SigningAlgorithmEdDSA
+			}
+
+			if v := os.Getenv("JWT_ROTATION_INTERVAL"); v != "" {
+				rotation, err := time.ParseDuration(v)
+				// Has to outlast a key's publishing period
+				if err != nil || rotation <= domain.SigningKeyPublishLead {
+					return fmt.Errorf("invalid JWT_ROTATION_INTERVAL %q", v)
+				}
+				cfg.JWTRotation = rotation
 			}
 
 			db, err := pgxpool.New(ctx, os.Getenv("DATABASE_URL"))
@@ -62,18 +88,41 @@ func APICmd(ctx context.Context) *cobra.Command {
 			}
 
 			a := api.MakeAPI(ctx, cfg, db, rdc, logger, queue)
+
+			// Makes the first key if there isn't one yet
+			if err := a.KeyRing.Refresh(ctx); err != nil {
+				return err
+			}
+			go a.KeyRing.Run(ctx)
+
 			srv := a.MakeServer(ctx, 3001)
 
 			go func() { _ = srv.ListenAndServe() }()
 			logger.Info("Started HTTP server")
 			go func() { a.WsHub.Run() }()
+			go func() { a.WsHub.Listen(ctx) }()
 			logger.Info("Started WebSocket server")
+			go func() { a.LocationWriter.Run() }()
+
+			if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+				go func() { _ = http.ListenAndServe(addr, expvar.Handler()) }()
+				logger.Info("Started metrics server", zap.String("addr", addr))
+			}
 
 			<-ctx.Done()
 
+			// ctx is done by now
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+			defer cancel()
+
 			logger.Info("Shutting down HTTP server")
+			srv.Shutdown(shutdownCtx)
+
+			logger.Info("Flushing location history")
+			if err := a.LocationWriter.Close(shutdownCtx); err != nil {
+				logger.Error("failed to flush location history", zap.Error(err))
+			}
 
-			srv.Shutdown(ctx)
 			logger.Sync()
 
 			return nil
@@ -82,3 +131,35 @@ func APICmd(ctx context.Context) *cobra.Command {
 
 	return apiCmd
 }
+
+// AUTH_PROVIDERS lists who users can sign in with, each one
+// is configured by <NAME>_CLIENT_ID and friends
+func providerConfigs() []provider.Config {
+	names := os.Getenv("AUTH_PROVIDERS")
+	if names == "" {
+		names = "discord"
+	}
+
+	configs := []provider.Config{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		configs = append(configs, provider.Config{
+			Name:         name,
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURI:  os.Getenv(prefix + "REDIRECT_URI"),
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+			TeamID:       os.Getenv(prefix + "TEAM_ID"),
+			KeyID:        os.Getenv(prefix + "KEY_ID"),
+			PrivateKey:   os.Getenv(prefix + "PRIVATE_KEY"),
+		})
+	}
+
+	return configs
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/peonii/inertia/internal/domain"
+	"github.com/peonii/inertia/internal/repository"
+	"github.com/spf13/cobra"
+)
+
+func ClientsCmd(ctx context.Context) *cobra.Command {
+	clientsCmd := &cobra.Command{
+		Use:   "clients",
+		Short: "Manage the OAuth clients that can sign users in",
+	}
+
+	clientsCmd.AddCommand(createClientCmd(ctx))
+	clientsCmd.AddCommand(listClientsCmd(ctx))
+	clientsCmd.AddCommand(deleteClientCmd(ctx))
+
+	return clientsCmd
+}
+
+func clientRepo(ctx context.Context) (*repository.PostgresOAuthClientRepository, error) {
+	db, err := pgxpool.New(ctx, os.Getenv("DATABASE_URL"))
+	if err != nil {
+		return nil, err
+	}
+
+	return repository.MakePostgresOAuthClientRepository(db), nil
+}
+
+func createClientCmd(ctx context.Context) *cobra.Command {
+	create := domain.OAuthClientCreate{}
+	confidential := false
+
+	createCmd := &cobra.Command{
+		Use:   "create <id>",
+		Short: "Register a client, confidential ones get a secret",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			create.ID = args[0]
+
+			secret := ""
+			if confidential {
+				s, hash, err := domain.NewClientSecret()
+				if err != nil {
+					return err
+				}
+
+				secret = s
+				create.SecretHash = hash
+			}
+
+			if err := create.Validate(); err != nil {
+				return err
+			}
+
+			repo, err := clientRepo(ctx)
+			if err != nil {
+				return err
+			}
+
+			client, err := repo.Create(ctx, &create)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("client_id:", client.ID)
+			if secret != "" {
+				// Only the hash is kept
+				fmt.Println("client_secret:", secret)
+			}
+
+			return nil
+		},
+	}
+
+	flags := createCmd.Flags()
+	flags.StringVar(&create.Name, "name", "", "name shown to users")
+	flags.StringSliceVar(&create.RedirectURIs, "redirect-uri", nil, "allowed redirect uri, can be repeated")
+	flags.StringSliceVar(&create.Scopes, "scope", domain.DefaultClientScopes, "allowed scope, can be repeated, account is only for our own apps")
+	flags.BoolVar(&confidential, "confidential", false, "give the client a secret, for servers that can keep one")
+
+	return createCmd
+}
+
+func listClientsCmd(ctx context.Context) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List registered clients",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := clientRepo(ctx)
+			if err != nil {
+				return err
+			}
+
+			clients, err := repo.FindAll(ctx)
+			if err != nil {
+				return err
+			}
+
+			for _, c := range clients {
+				kind := "confidential"
+				if c.IsPublic() {
+					kind = "public"
+				}
+
+				fmt.Printf("%s\t%s\t%s\t%s\t%s\n",
+					c.ID,
+					c.Name,
+					kind,
+					strings.Join(c.Scopes, " "),
+					strings.Join(c.RedirectURIs, " "),
+				)
+			}
+
+			return nil
+		},
+	}
+}
+
+func deleteClientCmd(ctx context.Context) *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete <id>",
+		Short: "Remove a client, its tokens stop refreshing",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := clientRepo(ctx)
+			if err != nil {
+				return err
+			}
+
+			return repo.Delete(ctx, args[0])
+		},
+	}
+}
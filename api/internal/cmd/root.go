@@ -24,6 +24,7 @@ func Execute(ctx context.Context) int {
 
 	rootCmd.AddCommand(APICmd(ctx))
 	rootCmd.AddCommand(WorkerCmd(ctx))
+	rootCmd.AddCommand(ClientsCmd(ctx))
 
 	if err := rootCmd.Execute(); err != nil {
 		return 1
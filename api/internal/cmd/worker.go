@@ -82,6 +82,9 @@ func WorkerCmd(ctx context.Context) *cobra.Command {
 			notifsWorker := worker.NewNotificationWorker(ctx, logger, &tok, fcmClient, rdc, db, queue, os.Getenv("RUNTIME_ENV") == "DEV", runtime.NumCPU()*8)
 			notifsWorker.Start()
 
+			statusWorker := worker.NewGameStatusWorker(ctx, logger, rdc, db, time.Second*5)
+			statusWorker.Start()
+
 			cleaner := rmq.NewCleaner(queue)
 
 			go func() {
@@ -98,6 +101,7 @@ func WorkerCmd(ctx context.Context) *cobra.Command {
 			<-ctx.Done()
 
 			notifsWorker.Stop()
+			statusWorker.Stop()
 
 			return nil
 		},
@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -8,6 +9,26 @@ import (
 
 type AccessToken struct {
 	jwt.RegisteredClaims
+	// Space separated, missing from tokens issued before
+	// there were clients, which can do anything
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	// Revoking the session revokes the token
+	SessionID string `json:"sid,omitempty"`
+	// Set for guests, who can't do anything in other games
+	GameID string `json:"gid,omitempty"`
+}
+
+func (t *AccessToken) Allows(scope string) bool {
+	if t.Scope == "" || scope == "" {
+		return true
+	}
+
+	return HasScope(strings.Fields(t.Scope), scope)
+}
+
+func (t *AccessToken) AllowsGame(gameID string) bool {
+	return t.GameID == "" || t.GameID == gameID
 }
 
 const (
@@ -20,16 +41,40 @@ type AccessTokenAuthCodeResponse struct {
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int64  `json:"expires_in"`
 	TokenType    string `json:"token_type"`
+	Scope        string `json:"scope"`
 }
 
 type AccessTokenRefreshResponse struct {
 	AccessToken string `json:"access_token"`
-	ExpiresIn   int64  `json:"expires_in"`
-	TokenType   string `json:"token_type"`
+	// The one that was sent stops working
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	TokenType    string `json:"token_type"`
+	Scope        string `json:"scope"`
 }
 
 type AccessTokenRequest struct {
 	GrantType    string `json:"grant_type"`
 	Code         string `json:"code"`
 	RefreshToken string `json:"refresh_token"`
+
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RedirectURI  string `json:"redirect_uri"`
+	CodeVerifier string `json:"code_verifier"`
+	// Shown in the user's sessions
+	DeviceName string `json:"device_name"`
+
+	// Guest grant only, the invite lets them in and
+	// the name is what others see
+	InviteCode string `json:"invite_code"`
+	Name       string `json:"name"`
+}
+
+type TokenRevokeRequest struct {
+	// An access or refresh token
+	Token string `json:"token"`
+
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
 }
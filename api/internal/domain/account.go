@@ -2,17 +2,21 @@ package domain
 
 import "time"
 
+// A login with an identity provider, a user can have
+// one for each provider they've linked
 type Account struct {
 	ID     string `json:"id"`
 	UserID string `json:"user_id"`
 
+	// The provider's name, e.g. "discord" or "google"
 	AccountType string `json:"account_type"`
 	AccountID   string `json:"account_id"`
 
-	Email string `json:"email"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
 
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	AccessToken  string `json:"-"`
+	RefreshToken string `json:"-"`
 
 	CreatedAt time.Time `json:"created_at"`
 }
@@ -23,8 +27,14 @@ type AccountCreate struct {
 	AccountType string `json:"account_type"`
 	AccountID   string `json:"account_id"`
 
-	Email string `json:"email"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
 
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 }
+
+type AccountLinkResponse struct {
+	LinkCode  string `json:"link_code"`
+	ExpiresIn int    `json:"expires_in"`
+}
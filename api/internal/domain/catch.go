@@ -0,0 +1,41 @@
+package domain
+
+import "time"
+
+const (
+	CatchStatusPending  = "pending"
+	CatchStatusAccepted = "accepted"
+	CatchStatusRejected = "rejected"
+)
+
+type Catch struct {
+	ID     string `json:"id"`
+	GameID string `json:"game_id"`
+
+	CatcherID string `json:"catcher_id"`
+	RunnerID  string `json:"runner_id"`
+	// The user that declared the catch
+	UserID string `json:"user_id"`
+
+	Status string `json:"status"`
+	// Closest distance between the teams in meters,
+	// nil if there weren't any recent locations
+	Distance *float64 `json:"distance"`
+
+	ResolvedBy *string    `json:"resolved_by"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type CatchCreate struct {
+	GameID    string   `json:"-"`
+	CatcherID string   `json:"-"`
+	RunnerID  string   `json:"-"`
+	UserID    string   `json:"-"`
+	Status    string   `json:"-"`
+	Distance  *float64 `json:"-"`
+}
+
+func (c *Catch) IsPending() bool {
+	return c.Status == CatchStatusPending
+}
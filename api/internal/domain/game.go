@@ -2,24 +2,59 @@ package domain
 
 import "time"
 
+const (
+	GameStatusDraft    = "draft"
+	GameStatusLobby    = "lobby"
+	GameStatusRunning  = "running"
+	GameStatusPaused   = "paused"
+	GameStatusFinished = "finished"
+)
+
+const (
+	GameRankingXP         = "xp"
+	GameRankingBalance    = "balance"
+	GameRankingRunnerTime = "runner_time"
+)
+
+// Every status a game can be moved to from a given status.
+// Anything not listed here is an invalid transition.
+var gameStatusTransitions = map[string][]string{
+	GameStatusDraft:    {GameStatusLobby},
+	GameStatusLobby:    {GameStatusDraft, GameStatusRunning, GameStatusFinished},
+	GameStatusRunning:  {GameStatusPaused, GameStatusFinished},
+	GameStatusPaused:   {GameStatusRunning, GameStatusFinished},
+	GameStatusFinished: {},
+}
+
+// Redis channel every WebSocket event of a game goes through,
+// so all API replicas see them
+func GameEventsChannel(gameID string) string {
+	return "events:game:" + gameID
+}
+
 type Game struct {
 	ID       string `json:"id"`
 	Name     string `json:"name"`
 	Official bool   `json:"official"`
 
-	HostID string `json:"host_id"`
+	HostID  string `json:"host_id"`
+	Status  string `json:"status"`
+	Ranking string `json:"ranking"`
 
 	TimeStart time.Time `json:"time_start"`
 	TimeEnd   time.Time `json:"time_end"`
 	LocLat    float64   `json:"loc_lat"`
 	LocLng    float64   `json:"loc_lng"`
+	// Where players have to stay, nil if they can go anywhere
+	PlayArea *GeoJSONPolygon `json:"play_area"`
 
 	CreatedAt time.Time `json:"created_at"`
 }
 
 type GameCreate struct {
-	Name   string `json:"name"`
-	HostID string `json:"host_id"`
+	Name    string `json:"name"`
+	HostID  string `json:"host_id"`
+	Ranking string `json:"ranking"`
 
 	TimeStart time.Time `json:"time_start"`
 	TimeEnd   time.Time `json:"time_end"`
@@ -28,7 +63,8 @@ type GameCreate struct {
 }
 
 type GameUpdate struct {
-	Name *string `json:"name"`
+	Name    *string `json:"name"`
+	Ranking *string `json:"ranking"`
 
 	TimeStart *time.Time `json:"time_start"`
 	TimeEnd   *time.Time `json:"time_end"`
@@ -36,10 +72,38 @@ type GameUpdate struct {
 	LocLng    *float64   `json:"loc_lng"`
 }
 
-func (g *Game) CanEdit(u *User) bool {
-	if u.AuthRole == UserAuthRoleAdmin {
+type GameStatusUpdate struct {
+	Status string `json:"status"`
+}
+
+type GameStatusEvent struct {
+	Game *Game  `json:"game"`
+	From string `json:"from"`
+}
+
+func (g *Game) InPlayArea(loc *Location) bool {
+	return g.PlayArea == nil || g.PlayArea.Contains(loc.Lat, loc.Lng)
+}
+
+func (g *Game) IsRunning() bool {
+	return g.Status == GameStatusRunning
+}
+
+func IsValidGameRanking(ranking string) bool {
+	switch ranking {
+	case GameRankingXP, GameRankingBalance, GameRankingRunnerTime:
 		return true
 	}
 
-	return g.HostID == u.ID
+	return false
+}
+
+func (g *Game) CanTransition(to string) bool {
+	for _, s := range gameStatusTransitions[g.Status] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,32 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	GameEventLocation    = "loc"
+	GameEventPowerup     = "pwp"
+	GameEventCatch       = "cat"
+	GameEventStatus      = "sts"
+	GameEventSubmission  = "sub"
+	GameEventTeam        = "tea"
+	GameEventQuest       = "qst"
+	GameEventOutOfBounds = "oob"
+)
+
+// How many events of a game are kept around for
+// clients that reconnect, and for how long
+const (
+	GameEventBufferSize = 500
+	GameEventBufferTTL  = time.Hour
+)
+
+// Something that happened in a game, as it goes through Redis.
+// Seq increases by one with every event of the game.
+type GameEvent struct {
+	Seq  int64           `json:"seq"`
+	Type string          `json:"typ"`
+	Data json.RawMessage `json:"dat"`
+}
@@ -1,12 +1,29 @@
 package domain
 
+const (
+	// Lets the holder create and join teams
+	GameInviteKindPlayer = "player"
+	// Lets the holder watch the game's live map
+	GameInviteKindSpectator = "spectator"
+)
+
 type GameInvite struct {
 	ID     string `json:"id"`
 	GameID string `json:"game_id"`
 	Slug   string `json:"code"`
 	Uses   int    `json:"uses"`
+	Kind   string `json:"kind"`
 }
 
 type GameInviteCreate struct {
-	GameID string `json:"game_id"`
+	GameID string `json:"-"`
+	Kind   string `json:"kind"`
+}
+
+func IsValidGameInviteKind(kind string) bool {
+	return kind == GameInviteKindPlayer || kind == GameInviteKindSpectator
+}
+
+func (i *GameInvite) IsSpectator() bool {
+	return i.Kind == GameInviteKindSpectator
 }
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	GameOutcomeWin  = "win"
+	GameOutcomeLoss = "loss"
+	GameOutcomeDraw = "draw"
+)
+
+type GameResult struct {
+	ID     string `json:"id"`
+	GameID string `json:"game_id"`
+	TeamID string `json:"team_id"`
+
+	Place   int    `json:"place"`
+	Score   int64  `json:"score"`
+	Outcome string `json:"outcome"`
+	Ranking string `json:"ranking"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type GameResultCreate struct {
+	TeamID string `json:"team_id"`
+	Score  int64  `json:"score"`
+
+	Place   int    `json:"place"`
+	Outcome string `json:"outcome"`
+}
+
+// Sorts teams by score and assigns places and outcomes.
+// Teams with equal scores share a place; if the top score
+// is shared, those teams draw instead of winning.
+func RankGameResults(results []*GameResultCreate) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	for i, res := range results {
+		if i > 0 && res.Score == results[i-1].Score {
+			res.Place = results[i-1].Place
+		} else {
+			res.Place = i + 1
+		}
+	}
+
+	topCount := 0
+	for _, res := range results {
+		if res.Place == 1 {
+			topCount++
+		}
+	}
+
+	for _, res := range results {
+		switch {
+		case res.Place != 1:
+			res.Outcome = GameOutcomeLoss
+		case topCount > 1:
+			res.Outcome = GameOutcomeDraw
+		default:
+			res.Outcome = GameOutcomeWin
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package domain
+
+import "testing"
+
+func TestRankGameResults(t *testing.T) {
+	type ranked struct {
+		team    string
+		place   int
+		outcome string
+	}
+
+	tests := []struct {
+		name   string
+		scores map[string]int64
+		order  []string
+		want   []ranked
+	}{
+		{
+			name:   "single team",
+			scores: map[string]int64{"a": 10},
+			order:  []string{"a"},
+			want:   []ranked{{"a", 1, GameOutcomeWin}},
+		},
+		{
+			name:   "clear winner",
+			scores: map[string]int64{"a": 10, "b": 30, "c": 20},
+			order:  []string{"a", "b", "c"},
+			want: []ranked{
+				{"b", 1, GameOutcomeWin},
+				{"c", 2, GameOutcomeLoss},
+				{"a", 3, GameOutcomeLoss},
+			},
+		},
+		{
+			name:   "shared top score",
+			scores: map[string]int64{"a": 30, "b": 10, "c": 30},
+			order:  []string{"a", "b", "c"},
+			want: []ranked{
+				{"a", 1, GameOutcomeDraw},
+				{"c", 1, GameOutcomeDraw},
+				{"b", 3, GameOutcomeLoss},
+			},
+		},
+		{
+			name:   "shared lower score",
+			scores: map[string]int64{"a": 5, "b": 20, "c": 5, "d": 1},
+			order:  []string{"a", "b", "c", "d"},
+			want: []ranked{
+				{"b", 1, GameOutcomeWin},
+				{"a", 2, GameOutcomeLoss},
+				{"c", 2, GameOutcomeLoss},
+				{"d", 4, GameOutcomeLoss},
+			},
+		},
+		{
+			name:   "everyone tied",
+			scores: map[string]int64{"a": 0, "b": 0},
+			order:  []string{"a", "b"},
+			want: []ranked{
+				{"a", 1, GameOutcomeDraw},
+				{"b", 1, GameOutcomeDraw},
+			},
+		},
+		{
+			name:   "negative scores",
+			scores: map[string]int64{"a": -10, "b": -5},
+			order:  []string{"a", "b"},
+			want: []ranked{
+				{"b", 1, GameOutcomeWin},
+				{"a", 2, GameOutcomeLoss},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := make([]*GameResultCreate, len(tt.order))
+			for i, team := range tt.order {
+				results[i] = &GameResultCreate{TeamID: team, Score: tt.scores[team]}
+			}
+
+			RankGameResults(results)
+
+			if len(results) != len(tt.want) {
+				t.Fatalf("expected %d results, got %d", len(tt.want), len(results))
+			}
+
+			for i, want := range tt.want {
+				got := results[i]
+				if got.TeamID != want.team || got.Place != want.place || got.Outcome != want.outcome {
+					t.Errorf("#%d: expected %s in place %d (%s), got %s in place %d (%s)",
+						i, want.team, want.place, want.outcome, got.TeamID, got.Place, got.Outcome)
+				}
+			}
+		})
+	}
+}
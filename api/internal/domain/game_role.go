@@ -0,0 +1,133 @@
+package domain
+
+import "time"
+
+// Someone's part in a game. The host and players are worked out
+// from the game and its teams, referees and spectators are
+// handed out by the host or come from an invite.
+const (
+	GameRoleHost = "host"
+	// Co-host, reviews and resolves things but doesn't
+	// set the game up or play in it
+	GameRoleReferee   = "referee"
+	GameRolePlayer    = "player"
+	GameRoleSpectator = "spectator"
+)
+
+// What the policy is asked about, every handler touching
+// a game checks one of these
+type GamePermission int
+
+const (
+	// The game, its rules, teams, results, powerups and catches
+	GamePermissionView GamePermission = iota
+	// Every team's quests, transactions and evidence, the live
+	// map without a delay and the replay before the game ends
+	GamePermissionViewAll
+	// Acting for a team, which also takes being in it
+	GamePermissionPlay
+	// Reviewing submissions, resolving catches, adjusting
+	// balances and looking into suspicious activity
+	GamePermissionReferee
+	// Setting the game up: details, rules, status, quests, invites
+	GamePermissionManage
+	// Deleting the game and handing out roles
+	GamePermissionOwn
+)
+
+var gameRolePermissions = map[string][]GamePermission{
+	GameRoleHost: {
+		GamePermissionView,
+		GamePermissionViewAll,
+		GamePermissionPlay,
+		GamePermissionReferee,
+		GamePermissionManage,
+		GamePermissionOwn,
+	},
+	GameRoleReferee: {
+		GamePermissionView,
+		GamePermissionViewAll,
+		GamePermissionReferee,
+	},
+	GameRolePlayer: {
+		GamePermissionView,
+		GamePermissionPlay,
+	},
+	GameRoleSpectator: {
+		GamePermissionView,
+	},
+}
+
+// Higher roles win when someone has more than one
+var gameRoleRanks = map[string]int{
+	GameRoleSpectator: 1,
+	GameRolePlayer:    2,
+	GameRoleReferee:   3,
+	GameRoleHost:      4,
+}
+
+func GameRoleCan(role string, permission GamePermission) bool {
+	for _, p := range gameRolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Only these are stored, the rest can't be handed out
+func IsGrantableGameRole(role string) bool {
+	return role == GameRoleReferee || role == GameRoleSpectator
+}
+
+type GameRole struct {
+	GameID    string    `json:"game_id"`
+	UserID    string    `json:"user_id"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type GameRoleGrant struct {
+	Role string `json:"role"`
+}
+
+// Who a user is in a game, from the policy
+type GameAccess struct {
+	Game *Game
+	User *User
+	// Empty if they have nothing to do with the game
+	Role string
+	// Their team, if they're playing
+	Team *Team
+}
+
+func (a *GameAccess) Can(permission GamePermission) bool {
+	if a.User.AuthRole == UserAuthRoleAdmin {
+		return true
+	}
+
+	return GameRoleCan(a.Role, permission)
+}
+
+func (a *GameAccess) InTeam(teamID string) bool {
+	return a.Team != nil && a.Team.ID == teamID
+}
+
+// Their own team, or any team for the host and referees
+func (a *GameAccess) CanSeeTeam(teamID string) bool {
+	return a.InTeam(teamID) || a.Can(GamePermissionViewAll)
+}
+
+// For the team's own players
+func (a *GameAccess) CanPlayFor(teamID string) bool {
+	return a.InTeam(teamID) && a.Can(GamePermissionPlay)
+}
+
+// Raises the role to what someone would get with an invite
+// for the game, so they can look before joining
+func (a *GameAccess) Elevate(role string) {
+	if gameRoleRanks[role] > gameRoleRanks[a.Role] {
+		a.Role = role
+	}
+}
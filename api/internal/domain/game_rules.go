@@ -0,0 +1,235 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type TicketType struct {
+	Type  string `json:"type"`
+	Price int    `json:"price"`
+}
+
+type PowerupRule struct {
+	Cost int `json:"cost"`
+	// In seconds
+	Duration int `json:"duration"`
+}
+
+// An hour, anything longer isn't "live" anymore
+const MaxSpectatorDelay = 60 * 60
+
+// Limits for the runner reveal schedule, in seconds
+const (
+	MinRevealInterval = 30
+	MaxRevealInterval = 2 * 60 * 60
+)
+
+// What happens to a player who leaves the play area,
+// on top of them and the host being told
+const (
+	OutOfBoundsPenaltyNone = "none"
+	// Takes OutOfBoundsFine off the team's balance
+	OutOfBoundsPenaltyFine = "fine"
+	// Shows the player's location to everyone
+	OutOfBoundsPenaltyReveal = "reveal"
+)
+
+type GameRules struct {
+	GameID string `json:"game_id"`
+
+	StartingBalance int `json:"starting_balance"`
+	// In seconds
+	VetoDuration int `json:"veto_duration"`
+
+	// Catches are accepted right away if the teams were within
+	// CatchDistance meters of each other in the last CatchWindow
+	// seconds, otherwise the runners (or the host) have to confirm
+	CatchDistance int `json:"catch_distance"`
+	CatchWindow   int `json:"catch_window"`
+
+	// How many seconds behind the live map is for spectators
+	// with a spectator invite, so streams don't give away runners
+	SpectatorDelay int `json:"spectator_delay"`
+
+	OutOfBoundsPenalty string `json:"out_of_bounds_penalty"`
+	OutOfBoundsFine    int    `json:"out_of_bounds_fine"`
+
+	// Hunters only see where the runners are every RevealInterval
+	// seconds from the start of the game, 0 tracks them all the time
+	RevealInterval int `json:"reveal_interval"`
+
+	Tickets  []TicketType           `json:"tickets"`
+	Powerups map[string]PowerupRule `json:"powerups"`
+}
+
+type GameRulesUpdate struct {
+	StartingBalance int `json:"starting_balance"`
+	VetoDuration    int `json:"veto_duration"`
+	CatchDistance   int `json:"catch_distance"`
+	CatchWindow     int `json:"catch_window"`
+	SpectatorDelay  int `json:"spectator_delay"`
+
+	OutOfBoundsPenalty string `json:"out_of_bounds_penalty"`
+	OutOfBoundsFine    int    `json:"out_of_bounds_fine"`
+
+	RevealInterval int `json:"reveal_interval"`
+
+	Tickets  []TicketType           `json:"tickets"`
+	Powerups map[string]PowerupRule `json:"powerups"`
+}
+
+// The rules every game used before they were configurable
+// (Warsaw public transport prices)
+func DefaultGameRules(gameID string) *GameRules {
+	return &GameRules{
+		GameID: gameID,
+
+		StartingBalance: 0,
+		VetoDuration:    20 * 60,
+		CatchDistance:   50,
+		CatchWindow:     60,
+
+		OutOfBoundsPenalty: OutOfBoundsPenaltyNone,
+
+		Tickets: []TicketType{
+			{Type: "bus", Price: 20},
+			{Type: "tram", Price: 30},
+			{Type: "m1", Price: 70},
+			{Type: "m2", Price: 60},
+			{Type: "km", Price: 70},
+			{Type: "wkd", Price: 40},
+		},
+		Powerups: map[string]PowerupRule{
+			PowerupTypeBlacklist:     {Cost: 600, Duration: 10 * 60},
+			PowerupTypeFreezeHunters: {Cost: 1000, Duration: 10 * 60},
+			PowerupTypeFreezeRunners: {Cost: 1000, Duration: 5 * 60},
+			PowerupTypeHunt:          {Cost: 200, Duration: 20 * 60},
+			PowerupTypeHideTracker:   {Cost: 500, Duration: 10 * 60},
+			PowerupTypeRevealHunters: {Cost: 400, Duration: 20 * 60},
+		},
+	}
+}
+
+func (r *GameRules) TicketPrice(ticketType string) (int, bool) {
+	for _, t := range r.Tickets {
+		if t.Type == ticketType {
+			return t.Price, true
+		}
+	}
+
+	return 0, false
+}
+
+func (r *GameRules) Powerup(powerupType string) (PowerupRule, bool) {
+	rule, ok := r.Powerups[powerupType]
+	return rule, ok
+}
+
+func (r *GameRules) VetoPeriod() time.Duration {
+	return time.Duration(r.VetoDuration) * time.Second
+}
+
+func (r *GameRules) CatchPeriod() time.Duration {
+	return time.Duration(r.CatchWindow) * time.Second
+}
+
+func (r *GameRules) SpectatorPeriod() time.Duration {
+	return time.Duration(r.SpectatorDelay) * time.Second
+}
+
+func (r *GameRules) RevealPeriod() time.Duration {
+	return time.Duration(r.RevealInterval) * time.Second
+}
+
+func (r *GameRules) HasRevealSchedule() bool {
+	return r.RevealInterval > 0
+}
+
+// The last reveal at or before now, counting from the start of the game
+func (r *GameRules) LastReveal(start, now time.Time) time.Time {
+	if now.Before(start) {
+		return start
+	}
+
+	period := r.RevealPeriod()
+	return start.Add(now.Sub(start) / period * period)
+}
+
+func (r *GameRules) NextReveal(start, now time.Time) time.Time {
+	if now.Before(start) {
+		return start
+	}
+
+	return r.LastReveal(start, now).Add(r.RevealPeriod())
+}
+
+func (r *PowerupRule) Length() time.Duration {
+	return time.Duration(r.Duration) * time.Second
+}
+
+func (r *GameRules) Validate() error {
+	if r.StartingBalance < 0 {
+		return errors.New("starting balance can't be negative")
+	}
+
+	if r.VetoDuration < 0 {
+		return errors.New("veto duration can't be negative")
+	}
+
+	if r.CatchDistance <= 0 || r.CatchWindow <= 0 {
+		return errors.New("catch distance and window must be positive")
+	}
+
+	if r.SpectatorDelay < 0 || r.SpectatorDelay > MaxSpectatorDelay {
+		return fmt.Errorf("spectator delay must be between 0 and %d seconds", MaxSpectatorDelay)
+	}
+
+	switch r.OutOfBoundsPenalty {
+	case OutOfBoundsPenaltyNone, OutOfBoundsPenaltyFine, OutOfBoundsPenaltyReveal:
+	default:
+		return fmt.Errorf("out of bounds penalty must be %q, %q or %q",
+			OutOfBoundsPenaltyNone, OutOfBoundsPenaltyFine, OutOfBoundsPenaltyReveal)
+	}
+
+	if r.OutOfBoundsFine < 0 {
+		return errors.New("out of bounds fine can't be negative")
+	}
+
+	if r.RevealInterval != 0 && (r.RevealInterval < MinRevealInterval || r.RevealInterval > MaxRevealInterval) {
+		return fmt.Errorf("reveal interval must be 0 or between %d and %d seconds", MinRevealInterval, MaxRevealInterval)
+	}
+
+	seen := make(map[string]bool)
+	for _, t := range r.Tickets {
+		if t.Type == "" {
+			return errors.New("ticket type can't be empty")
+		}
+
+		if seen[t.Type] {
+			return fmt.Errorf("duplicate ticket type %q", t.Type)
+		}
+		seen[t.Type] = true
+
+		if t.Price < 0 {
+			return fmt.Errorf("price of ticket %q can't be negative", t.Type)
+		}
+	}
+
+	for typ, p := range r.Powerups {
+		if !IsValidPowerupType(typ) {
+			return fmt.Errorf("unknown powerup type %q", typ)
+		}
+
+		if p.Cost < 0 {
+			return fmt.Errorf("cost of powerup %q can't be negative", typ)
+		}
+
+		if p.Duration <= 0 {
+			return fmt.Errorf("duration of powerup %q must be positive", typ)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevealSchedule(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rules := &GameRules{RevealInterval: 5 * 60}
+
+	tests := []struct {
+		name       string
+		now        time.Duration
+		last, next time.Duration
+	}{
+		{"before the start", -time.Minute, 0, 0},
+		{"at the start", 0, 0, 5 * time.Minute},
+		{"between reveals", 7 * time.Minute, 5 * time.Minute, 10 * time.Minute},
+		{"right on a reveal", 10 * time.Minute, 10 * time.Minute, 15 * time.Minute},
+		{"just before a reveal", 15*time.Minute - time.Nanosecond, 10 * time.Minute, 15 * time.Minute},
+		{"hours in", 3*time.Hour + 2*time.Minute, 3 * time.Hour, 3*time.Hour + 5*time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start.Add(tt.now)
+
+			if got := rules.LastReveal(start, now); !got.Equal(start.Add(tt.last)) {
+				t.Errorf("expected the last reveal at %s, got %s", tt.last, got.Sub(start))
+			}
+
+			if got := rules.NextReveal(start, now); !got.Equal(start.Add(tt.next)) {
+				t.Errorf("expected the next reveal at %s, got %s", tt.next, got.Sub(start))
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package domain
+
+import "errors"
+
+// Just enough of GeoJSON (RFC 7946) for exports and play areas
+type GeoJSONFeatureCollection struct {
+	Type     string            `json:"type"`
+	Features []*GeoJSONFeature `json:"features"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Positions are [lng, lat] or [lng, lat, alt]
+type GeoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+func NewGeoJSONFeatureCollection() *GeoJSONFeatureCollection {
+	return &GeoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []*GeoJSONFeature{},
+	}
+}
+
+// A polygon geometry, the first ring is the outline
+// and any others are holes in it
+type GeoJSONPolygon struct {
+	Type        string        `json:"type"`
+	Coordinates [][][]float64 `json:"coordinates"`
+}
+
+// Rings have to be closed and have at least three corners
+func (p *GeoJSONPolygon) Validate() error {
+	if p.Type != "Polygon" {
+		return errors.New("play area must be a Polygon")
+	}
+
+	if len(p.Coordinates) == 0 {
+		return errors.New("polygon has no rings")
+	}
+
+	for _, ring := range p.Coordinates {
+		if len(ring) < 4 {
+			return errors.New("polygon rings need at least 4 positions")
+		}
+
+		for _, pos := range ring {
+			if len(pos) < 2 || !isCoordinate(pos[0], 180) || !isCoordinate(pos[1], 90) {
+				return errors.New("polygon has an invalid position")
+			}
+		}
+
+		first, last := ring[0], ring[len(ring)-1]
+		if first[0] != last[0] || first[1] != last[1] {
+			return errors.New("polygon rings must be closed")
+		}
+	}
+
+	return nil
+}
+
+func (p *GeoJSONPolygon) Contains(lat, lng float64) bool {
+	if !ringContains(p.Coordinates[0], lat, lng) {
+		return false
+	}
+
+	for _, hole := range p.Coordinates[1:] {
+		if ringContains(hole, lat, lng) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Ray casting, treating coordinates as flat
+func ringContains(ring [][]float64, lat, lng float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+
+		if (yi > lat) != (yj > lat) && lng < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
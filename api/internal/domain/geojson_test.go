@@ -0,0 +1,43 @@
+package domain
+
+import "testing"
+
+func TestGeoJSONPolygonContains(t *testing.T) {
+	// Positions are [lng, lat]
+	square := [][]float64{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}
+	hole := [][]float64{{4, 4}, {6, 4}, {6, 6}, {4, 6}, {4, 4}}
+	// An L, missing its top right corner
+	concave := [][]float64{{0, 0}, {10, 0}, {10, 5}, {5, 5}, {5, 10}, {0, 10}, {0, 0}}
+
+	tests := []struct {
+		name     string
+		rings    [][][]float64
+		lat, lng float64
+		inside   bool
+	}{
+		{"centre", [][][]float64{square}, 5, 5, true},
+		{"near a corner", [][][]float64{square}, 0.1, 9.9, true},
+		{"north", [][][]float64{square}, 11, 5, false},
+		{"east", [][][]float64{square}, 5, 11, false},
+		{"south west", [][][]float64{square}, -1, -1, false},
+		{"in the hole", [][][]float64{square, hole}, 5, 5, false},
+		{"around the hole", [][][]float64{square, hole}, 2, 2, true},
+		{"concave arm", [][][]float64{concave}, 8, 2, true},
+		{"concave notch", [][][]float64{concave}, 8, 8, false},
+		// Rays cast through a vertex shouldn't count it twice
+		{"level with a vertex", [][][]float64{concave}, 5, 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &GeoJSONPolygon{Type: "Polygon", Coordinates: tt.rings}
+			if err := p.Validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := p.Contains(tt.lat, tt.lng); got != tt.inside {
+				t.Fatalf("expected inside %v for (%v, %v), got %v", tt.inside, tt.lat, tt.lng, got)
+			}
+		})
+	}
+}
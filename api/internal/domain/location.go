@@ -1,6 +1,9 @@
 package domain
 
-import "time"
+import (
+	"math"
+	"time"
+)
 
 type Location struct {
 	ID string `json:"id"`
@@ -14,7 +17,9 @@ type Location struct {
 
 	UserID string `json:"user_id"`
 
-	CreatedAt time.Time `json:"created_at"`
+	// When the device got the fix, if it said
+	RecordedAt *time.Time `json:"recorded_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
 }
 
 type LocationCreate struct {
@@ -26,4 +31,45 @@ type LocationCreate struct {
 	Speed     float64 `json:"speed"`
 
 	UserID string `json:"user_id"`
+
+	RecordedAt *time.Time `json:"recorded_at,omitempty"`
+}
+
+// The location as it was sent
+func (l *Location) Create() LocationCreate {
+	return LocationCreate{
+		Lat:       l.Lat,
+		Lng:       l.Lng,
+		Alt:       l.Alt,
+		Precision: l.Precision,
+		Heading:   l.Heading,
+		Speed:     l.Speed,
+		UserID:    l.UserID,
+
+		RecordedAt: l.RecordedAt,
+	}
+}
+
+// When the fix was taken, going by the device if it said
+func (l *Location) Time() time.Time {
+	if l.RecordedAt != nil {
+		return *l.RecordedAt
+	}
+
+	return l.CreatedAt
+}
+
+const earthRadius = 6371000.0
+
+// Great-circle distance between two locations in meters
+func (l *Location) DistanceTo(other *Location) float64 {
+	lat1 := l.Lat * math.Pi / 180
+	lat2 := other.Lat * math.Pi / 180
+	dLat := (other.Lat - l.Lat) * math.Pi / 180
+	dLng := (other.Lng - l.Lng) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+
+	return earthRadius * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
 }
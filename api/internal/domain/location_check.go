@@ -0,0 +1,134 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Why a location was turned down
+const (
+	LocationRejectInvalid    = "invalid"
+	LocationRejectImprecise  = "imprecise"
+	LocationRejectOutOfOrder = "out_of_order"
+	LocationRejectTooFast    = "too_fast"
+	LocationRejectOutOfArea  = "out_of_area"
+)
+
+const (
+	// In meters, fixes worse than this aren't worth sharing
+	MaxLocationPrecision = 250.0
+	// In m/s, a bit over a high-speed train
+	MaxLocationSpeed = 100.0
+	// How far ahead of the server a device's clock can be
+	MaxLocationClockSkew = 30 * time.Second
+	// In meters, from the game's centre
+	MaxGameCentreDistance = 50000.0
+)
+
+// A location that didn't pass the checks. Suspicious ones are
+// what a spoofed GPS would send and end up in the game's log.
+type LocationRejection struct {
+	Reason     string
+	Detail     string
+	Suspicious bool
+}
+
+func (r *LocationRejection) Error() string {
+	return "location rejected: " + r.Detail
+}
+
+// Checks the location against the user's previous one (nil if
+// there isn't any) and the game's area. Returns nil if it's fine.
+func CheckLocation(game *Game, prev *Location, next *LocationCreate, now time.Time) *LocationRejection {
+	if !isCoordinate(next.Lat, 90) || !isCoordinate(next.Lng, 180) ||
+		math.IsNaN(next.Precision) || next.Precision < 0 ||
+		math.IsNaN(next.Speed) || math.IsInf(next.Speed, 0) {
+		return &LocationRejection{
+			Reason: LocationRejectInvalid,
+			Detail: "coordinates are not valid",
+		}
+	}
+
+	if next.Precision > MaxLocationPrecision {
+		return &LocationRejection{
+			Reason: LocationRejectImprecise,
+			Detail: fmt.Sprintf("precision of %.0fm is too poor", next.Precision),
+		}
+	}
+
+	at := now
+	if next.RecordedAt != nil {
+		at = *next.RecordedAt
+
+		if at.After(now.Add(MaxLocationClockSkew)) {
+			return &LocationRejection{
+				Reason:     LocationRejectOutOfOrder,
+				Detail:     "recorded in the future",
+				Suspicious: true,
+			}
+		}
+	}
+
+	if next.Speed > MaxLocationSpeed {
+		return &LocationRejection{
+			Reason:     LocationRejectTooFast,
+			Detail:     fmt.Sprintf("reported speed of %.0fm/s", next.Speed),
+			Suspicious: true,
+		}
+	}
+
+	loc := &Location{Lat: next.Lat, Lng: next.Lng}
+	if !game.IsNearby(loc) {
+		return &LocationRejection{
+			Reason:     LocationRejectOutOfArea,
+			Detail:     "too far from the game",
+			Suspicious: true,
+		}
+	}
+
+	if prev == nil {
+		return nil
+	}
+
+	// Fixes can arrive out of order on a flaky connection,
+	// the newer one has already been shared
+	elapsed := at.Sub(prev.Time())
+	if next.RecordedAt != nil && prev.RecordedAt != nil && elapsed < 0 {
+		return &LocationRejection{
+			Reason: LocationRejectOutOfOrder,
+			Detail: "older than the previous location",
+		}
+	}
+
+	// Both fixes could be off by their precision
+	distance := math.Max(0, prev.DistanceTo(loc)-prev.Precision-next.Precision)
+	if distance == 0 {
+		return nil
+	}
+
+	if elapsed <= 0 || distance/elapsed.Seconds() > MaxLocationSpeed {
+		return &LocationRejection{
+			Reason:     LocationRejectTooFast,
+			Detail:     fmt.Sprintf("moved %.0fm in %s", distance, elapsed.Round(time.Millisecond)),
+			Suspicious: true,
+		}
+	}
+
+	return nil
+}
+
+func isCoordinate(v, limit float64) bool {
+	return !math.IsNaN(v) && v >= -limit && v <= limit
+}
+
+// Leaving the play area is part of the game, being this
+// far from it is more likely a spoofed GPS
+func (g *Game) IsNearby(loc *Location) bool {
+	if g.LocLat == 0 && g.LocLng == 0 {
+		return true
+	}
+
+	centre := &Location{Lat: g.LocLat, Lng: g.LocLng}
+	return centre.DistanceTo(loc) <= MaxGameCentreDistance
+}
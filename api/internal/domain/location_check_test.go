@@ -0,0 +1,76 @@
+package domain
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCheckLocation(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		ts := now.Add(d)
+		return &ts
+	}
+
+	game := &Game{LocLat: 52.23, LocLng: 21.01}
+	// Ten seconds before now, about 111m is 0.001 degrees of latitude
+	prev := &Location{Lat: 52.23, Lng: 21.01, Precision: 10, RecordedAt: at(-10 * time.Second)}
+
+	tests := []struct {
+		name       string
+		game       *Game
+		prev       *Location
+		next       *LocationCreate
+		reason     string
+		suspicious bool
+	}{
+		{"first location", game, nil, &LocationCreate{Lat: 52.23, Lng: 21.01}, "", false},
+		{"nan latitude", game, nil, &LocationCreate{Lat: math.NaN(), Lng: 21.01}, LocationRejectInvalid, false},
+		{"latitude out of range", game, nil, &LocationCreate{Lat: 91, Lng: 21.01}, LocationRejectInvalid, false},
+		{"longitude out of range", game, nil, &LocationCreate{Lat: 52.23, Lng: -181}, LocationRejectInvalid, false},
+		{"negative precision", game, nil, &LocationCreate{Lat: 52.23, Lng: 21.01, Precision: -1}, LocationRejectInvalid, false},
+		{"infinite speed", game, nil, &LocationCreate{Lat: 52.23, Lng: 21.01, Speed: math.Inf(1)}, LocationRejectInvalid, false},
+
+		{"poor precision", game, nil, &LocationCreate{Lat: 52.23, Lng: 21.01, Precision: MaxLocationPrecision + 1}, LocationRejectImprecise, false},
+		{"precision at the limit", game, nil, &LocationCreate{Lat: 52.23, Lng: 21.01, Precision: MaxLocationPrecision}, "", false},
+
+		{"recorded in the future", game, nil, &LocationCreate{Lat: 52.23, Lng: 21.01, RecordedAt: at(time.Minute)}, LocationRejectOutOfOrder, true},
+		{"clock slightly ahead", game, nil, &LocationCreate{Lat: 52.23, Lng: 21.01, RecordedAt: at(10 * time.Second)}, "", false},
+		{"reported speed", game, nil, &LocationCreate{Lat: 52.23, Lng: 21.01, Speed: MaxLocationSpeed + 1}, LocationRejectTooFast, true},
+
+		{"far from the game", game, nil, &LocationCreate{Lat: 52.83, Lng: 21.01}, LocationRejectOutOfArea, true},
+		{"game without a centre", &Game{}, nil, &LocationCreate{Lat: 10, Lng: 10}, "", false},
+
+		{"walking", game, prev, &LocationCreate{Lat: 52.2301, Lng: 21.01, RecordedAt: at(0)}, "", false},
+		{"cycling", game, prev, &LocationCreate{Lat: 52.231, Lng: 21.01, RecordedAt: at(0)}, "", false},
+		{"teleported", game, prev, &LocationCreate{Lat: 52.24, Lng: 21.01, RecordedAt: at(0)}, LocationRejectTooFast, true},
+		{"jitter within precision", game, prev, &LocationCreate{Lat: 52.2301, Lng: 21.01, Precision: 10, RecordedAt: at(-10 * time.Second)}, "", false},
+		{"moved without time passing", game, prev, &LocationCreate{Lat: 52.231, Lng: 21.01, RecordedAt: at(-10 * time.Second)}, LocationRejectTooFast, true},
+		{"older than the previous", game, prev, &LocationCreate{Lat: 52.23, Lng: 21.01, RecordedAt: at(-time.Minute)}, LocationRejectOutOfOrder, false},
+		// Without a device time it's taken as arriving now
+		{"no device time", game, prev, &LocationCreate{Lat: 52.231, Lng: 21.01}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejection := CheckLocation(tt.game, tt.prev, tt.next, now)
+
+			if tt.reason == "" {
+				if rejection != nil {
+					t.Fatalf("expected the location to pass, got %s (%s)", rejection.Reason, rejection.Detail)
+				}
+				return
+			}
+
+			if rejection == nil {
+				t.Fatalf("expected %s, the location passed", tt.reason)
+			}
+
+			if rejection.Reason != tt.reason || rejection.Suspicious != tt.suspicious {
+				t.Fatalf("expected %s (suspicious %v), got %s (suspicious %v)",
+					tt.reason, tt.suspicious, rejection.Reason, rejection.Suspicious)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package domain
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// An app that can sign users in, e.g. the mobile app or a bot.
+// Public clients (apps that can't keep a secret) have no secret
+// and have to use PKCE instead.
+type OAuthClient struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	// Redirect URIs have to match one of these exactly
+	RedirectURIs []string `json:"redirect_uris"`
+	// Most a token for this client can be given
+	Scopes []string `json:"scopes"`
+
+	SecretHash []byte    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type OAuthClientCreate struct {
+	// Picked by whoever registers the client, the
+	// first-party apps already send theirs
+	ID           string
+	Name         string
+	RedirectURIs []string
+	Scopes       []string
+	// Nil for public clients
+	SecretHash []byte
+}
+
+const OAuthClientSecretLength = 32
+
+var (
+	ErrInvalidScope         = errors.New("invalid scope")
+	ErrInvalidCodeChallenge = errors.New("code challenge must be S256")
+)
+
+func (c *OAuthClientCreate) Validate() error {
+	if c.ID == "" || len(c.ID) > 64 || c.Name == "" {
+		return errors.New("client needs an id and a name")
+	}
+
+	if len(c.RedirectURIs) == 0 {
+		return errors.New("client needs at least one redirect uri")
+	}
+
+	// Custom schemes are fine for the apps
+	for _, uri := range c.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || u.Scheme == "" || u.Fragment != "" {
+			return fmt.Errorf("invalid redirect uri %q", uri)
+		}
+	}
+
+	return ValidateScopes(c.Scopes)
+}
+
+func (c *OAuthClient) IsPublic() bool {
+	return c.SecretHash == nil
+}
+
+func (c *OAuthClient) AllowsRedirect(uri string) bool {
+	for _, allowed := range c.RedirectURIs {
+		if allowed == uri {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *OAuthClient) CheckSecret(secret string) bool {
+	if c.IsPublic() || secret == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(HashClientSecret(secret), c.SecretHash) == 1
+}
+
+// Splits a space separated scope parameter, an empty one
+// gets everything the client is allowed
+func (c *OAuthClient) ParseScope(scope string) ([]string, error) {
+	requested := strings.Fields(scope)
+	if len(requested) == 0 {
+		return c.Scopes, nil
+	}
+
+	for _, s := range requested {
+		if !HasScope(c.Scopes, s) {
+			return nil, ErrInvalidScope
+		}
+	}
+
+	return requested, nil
+}
+
+// Secrets are random enough that a plain hash does the job
+func HashClientSecret(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+func NewClientSecret() (string, []byte, error) {
+	b := make([]byte, OAuthClientSecretLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+
+	secret := "cs." + base64.RawURLEncoding.EncodeToString(b)
+	return secret, HashClientSecret(secret), nil
+}
+
+// RFC 7636, 43 to 128 characters of base64url
+var codeChallengeRegexp = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// Only S256 is supported, plain would give away the verifier
+func ValidateCodeChallenge(challenge, method string) error {
+	if method != "S256" || !codeChallengeRegexp.MatchString(challenge) {
+		return ErrInvalidCodeChallenge
+	}
+
+	return nil
+}
+
+func VerifyCodeChallenge(challenge, verifier string) bool {
+	if !codeChallengeRegexp.MatchString(verifier) {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
@@ -0,0 +1,60 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	// From RFC 7636, appendix B
+	const (
+		verifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+		challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	)
+
+	tests := []struct {
+		name      string
+		challenge string
+		verifier  string
+		ok        bool
+	}{
+		{"matching", challenge, verifier, true},
+		{"wrong verifier", challenge, strings.Replace(verifier, "d", "e", 1), false},
+		{"plain", verifier, verifier, false},
+		{"empty verifier", challenge, "", false},
+		{"verifier too short", challenge, verifier[:42], false},
+		{"verifier too long", challenge, strings.Repeat("a", 129), false},
+		{"verifier with invalid characters", challenge, verifier[:42] + "+", false},
+		{"padded challenge", challenge + "=", verifier, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyCodeChallenge(tt.challenge, tt.verifier); got != tt.ok {
+				t.Fatalf("expected %v, got %v", tt.ok, got)
+			}
+		})
+	}
+}
+
+func TestValidateCodeChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+		method    string
+		ok        bool
+	}{
+		{"s256", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", "S256", true},
+		{"plain", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", "plain", false},
+		{"no method", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", "", false},
+		{"too short", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw", "S256", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateCodeChallenge(tt.challenge, tt.method); (err == nil) != tt.ok {
+				t.Fatalf("expected ok %v, got %v", tt.ok, err)
+			}
+		})
+	}
+}
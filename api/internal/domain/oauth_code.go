@@ -7,9 +7,35 @@ type OAuthCode struct {
 	CreatedAt time.Time `json:"created_at"`
 	ExpiresAt time.Time `json:"expires_at"`
 	UserID    string    `json:"user_id"`
+
+	ClientID      string   `json:"client_id"`
+	RedirectURI   string   `json:"redirect_uri"`
+	Scopes        []string `json:"scopes"`
+	CodeChallenge string   `json:"code_challenge"`
+}
+
+// An authorization request waiting for the user to come
+// back from the provider, keyed by the state we sent it
+type OAuthRequest struct {
+	Key string `json:"key"`
+	// Which provider the user went to, and the nonce
+	// its ID token has to come back with
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	// The guest signing in to keep what they've played
+	LinkUserID string `json:"link_user_id"`
+
+	ClientID    string   `json:"client_id"`
+	RedirectURI string   `json:"redirect_uri"`
+	Scopes      []string `json:"scopes"`
+	// The client's own state, handed back with the code
+	State         string `json:"state"`
+	CodeChallenge string `json:"code_challenge"`
 }
 
 const (
 	OAuthCodeExpiryTime = time.Minute * 5
 	OAuthCodeLength     = 24
+	// Longer than this and it's not a CSRF token
+	OAuthStateMaxLength = 512
 )
@@ -14,6 +14,16 @@ const (
 	PowerupTypeBlacklist     = "blacklist"
 )
 
+var PowerupTypes = []string{
+	PowerupTypeFreezeHunters,
+	PowerupTypeRevealHunters,
+	PowerupTypeHideTracker,
+
+	PowerupTypeHunt,
+	PowerupTypeFreezeRunners,
+	PowerupTypeBlacklist,
+}
+
 type Powerup struct {
 	ID string `json:"id"`
 
@@ -21,11 +31,78 @@ type Powerup struct {
 
 	CasterID string `json:"caster_id"`
 	EndsAt   time.Time `json:"ends_at"`
+	// Ticket type for blacklist, empty for everything else
+	Target string `json:"target"`
 
 	CreatedAt time.Time `json:"created_at"`
 }
 
 type PowerupCreate struct {
-	Type     string `json:"type"`
-	CasterID string `json:"caster_id"`
+	Type     string        `json:"type"`
+	CasterID string        `json:"caster_id"`
+	Target   string        `json:"target"`
+	Duration time.Duration `json:"-"`
+}
+
+// Runners can only cast freeze_hunters, reveal_hunters and
+// hide_tracker; every other powerup is for hunters
+func IsRunnerPowerup(powerupType string) bool {
+	switch powerupType {
+	case PowerupTypeFreezeHunters, PowerupTypeRevealHunters, PowerupTypeHideTracker:
+		return true
+	}
+
+	return false
+}
+
+func IsValidPowerupType(powerupType string) bool {
+	for _, t := range PowerupTypes {
+		if t == powerupType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the freeze currently affecting the team, or nil if it
+// isn't frozen. freeze_hunters freezes every hunter team and
+// freeze_runners every runner team, except for the caster itself.
+func ActiveFreeze(team *Team, powerups []*Powerup) *Powerup {
+	var freeze *Powerup
+
+	for _, p := range powerups {
+		if p.CasterID == team.ID || !time.Now().Before(p.EndsAt) {
+			continue
+		}
+
+		affected := (p.Type == PowerupTypeFreezeHunters && !team.IsRunner) ||
+			(p.Type == PowerupTypeFreezeRunners && team.IsRunner)
+
+		if affected && (freeze == nil || p.EndsAt.After(freeze.EndsAt)) {
+			freeze = p
+		}
+	}
+
+	return freeze
+}
+
+// Returns the blacklist banning the ticket type for the team, or nil.
+// Blacklists only ever apply to runners.
+func ActiveBlacklist(team *Team, ticketType string, powerups []*Powerup) *Powerup {
+	if !team.IsRunner {
+		return nil
+	}
+
+	for _, p := range powerups {
+		if p.Type != PowerupTypeBlacklist || p.CasterID == team.ID {
+			continue
+		}
+
+		if p.Target == ticketType && time.Now().Before(p.EndsAt) {
+			return p
+		}
+	}
+
+	return nil
 }
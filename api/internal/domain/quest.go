@@ -26,6 +26,12 @@ type Quest struct {
 
 	Lat float64 `json:"lat"`
 	Lng float64 `json:"lng"`
+	// In meters, 0 means the quest can be completed from anywhere
+	Radius float64 `json:"radius"`
+	// Set by the host to skip the location check
+	HonourBased bool `json:"honour_based"`
+	// Completing the quest needs a photo approved by the host
+	RequiresEvidence bool `json:"requires_evidence"`
 
 	GameID string `json:"game_id"`
 
@@ -46,6 +52,12 @@ type QuestCreate struct {
 
 	Lat float64 `json:"lat"`
 	Lng float64 `json:"lng"`
+	// In meters, 0 means the quest can be completed from anywhere
+	Radius float64 `json:"radius"`
+	// Set by the host to skip the location check
+	HonourBased bool `json:"honour_based"`
+	// Completing the quest needs a photo approved by the host
+	RequiresEvidence bool `json:"requires_evidence"`
 }
 
 type ActiveQuest struct {
@@ -53,6 +65,7 @@ type ActiveQuest struct {
 	QuestID   string    `json:"quest_id"`
 	TeamID    string    `json:"team_id"`
 	Complete  bool      `json:"complete"`
+	Submitted bool      `json:"submitted"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -70,8 +83,16 @@ type ActiveQuestFull struct {
 
 	Lat float64 `json:"lat"`
 	Lng float64 `json:"lng"`
+	// In meters, 0 means the quest can be completed from anywhere
+	Radius float64 `json:"radius"`
+	// Set by the host to skip the location check
+	HonourBased bool `json:"honour_based"`
+	// Completing the quest needs a photo approved by the host
+	RequiresEvidence bool `json:"requires_evidence"`
 
 	Complete bool `json:"complete"`
+	// Evidence was uploaded and is waiting for the host
+	Submitted bool `json:"submitted"`
 
 	GameID string `json:"game_id"`
 	TeamID string `json:"team_id"`
@@ -85,3 +106,22 @@ type ActiveQuestCreate struct {
 	TeamID   string `json:"team_id"`
 	Complete bool   `json:"complete"`
 }
+
+type QuestGeofenceUpdate struct {
+	Radius      float64 `json:"radius"`
+	HonourBased bool    `json:"honour_based"`
+}
+
+// How old a member's location can be to count towards completing a quest
+const QuestLocationWindow = time.Minute * 2
+
+func (q *ActiveQuestFull) IsGeofenced() bool {
+	return q.Radius > 0 && !q.HonourBased
+}
+
+func (q *ActiveQuestFull) Location() *Location {
+	return &Location{
+		Lat: q.Lat,
+		Lng: q.Lng,
+	}
+}
@@ -0,0 +1,46 @@
+package domain
+
+import "time"
+
+const (
+	SubmissionStatusPending  = "pending"
+	SubmissionStatusApproved = "approved"
+	SubmissionStatusRejected = "rejected"
+)
+
+// Biggest evidence file we accept, in bytes
+const MaxEvidenceSize = 20 << 20
+
+type QuestSubmission struct {
+	ID            string `json:"id"`
+	ActiveQuestID string `json:"active_quest_id"`
+	TeamID        string `json:"team_id"`
+	UserID        string `json:"user_id"`
+
+	BlobKey     string `json:"-"`
+	ContentType string `json:"content_type"`
+
+	Status string `json:"status"`
+	// Left by the host when reviewing
+	Note string `json:"note"`
+
+	ReviewedBy *string    `json:"reviewed_by"`
+	ReviewedAt *time.Time `json:"reviewed_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type QuestSubmissionCreate struct {
+	ActiveQuestID string `json:"-"`
+	TeamID        string `json:"-"`
+	UserID        string `json:"-"`
+	BlobKey       string `json:"-"`
+	ContentType   string `json:"-"`
+}
+
+type QuestSubmissionReview struct {
+	Note string `json:"note"`
+}
+
+func (s *QuestSubmission) IsPending() bool {
+	return s.Status == SubmissionStatusPending
+}
@@ -7,9 +7,16 @@ type RefreshToken struct {
 	CreatedAt time.Time `json:"created_at"`
 	ExpiresAt time.Time `json:"expires_at"`
 	UserID    string    `json:"user_id"`
+	SessionID string    `json:"session_id"`
+
+	// Only set on tokens from before sessions, they get
+	// a session the next time they're used
+	ClientID string   `json:"client_id"`
+	Scopes   []string `json:"scopes"`
 }
 
 const (
+	// Since the session was last used
 	RefreshTokenExpiryTime = time.Hour * 24 * 365
 	RefreshTokenLength     = 128
 )
@@ -0,0 +1,233 @@
+package domain
+
+import (
+	"encoding/xml"
+	"math"
+	"time"
+)
+
+// Limits for the downsampling parameters
+const (
+	MaxReplayInterval  = 10 * 60
+	MaxReplayTolerance = 1000
+)
+
+// A user's path through a game, oldest point first
+type Track struct {
+	User   *User       `json:"user"`
+	Points []*Location `json:"points"`
+}
+
+type ReplayTeam struct {
+	Team   *Team    `json:"team"`
+	Tracks []*Track `json:"tracks"`
+}
+
+type Replay struct {
+	GameID string        `json:"game_id"`
+	From   time.Time     `json:"from"`
+	To     time.Time     `json:"to"`
+	Teams  []*ReplayTeam `json:"teams"`
+}
+
+// How tracks are thinned out, zero values keep every point
+type ReplayOptions struct {
+	// Points sooner than this after the last kept one are dropped
+	Interval time.Duration
+	// Points that stray less than this many meters from
+	// the simplified line are dropped (Douglas-Peucker)
+	Tolerance float64
+}
+
+// The time window of the game's replay, which ends now
+// if the game is still going
+func (g *Game) ReplayWindow() (time.Time, time.Time) {
+	to := g.TimeEnd
+	if now := time.Now(); to.After(now) {
+		to = now
+	}
+
+	return g.TimeStart, to
+}
+
+func (o ReplayOptions) Downsample(points []*Location) []*Location {
+	if o.Interval > 0 && len(points) > 2 {
+		kept := []*Location{points[0]}
+		for _, p := range points[1 : len(points)-1] {
+			if p.CreatedAt.Sub(kept[len(kept)-1].CreatedAt) >= o.Interval {
+				kept = append(kept, p)
+			}
+		}
+		points = append(kept, points[len(points)-1])
+	}
+
+	if o.Tolerance > 0 && len(points) > 2 {
+		points = simplify(points, o.Tolerance)
+	}
+
+	return points
+}
+
+// Douglas-Peucker, without recursion since tracks can be long
+func simplify(points []*Location, tolerance float64) []*Location {
+	keep := make([]bool, len(points))
+	keep[0] = true
+	keep[len(points)-1] = true
+
+	stack := [][2]int{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		span := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		first, last := span[0], span[1]
+		furthest, dist := 0, 0.0
+		for i := first + 1; i < last; i++ {
+			if d := points[i].distanceToSegment(points[first], points[last]); d > dist {
+				furthest, dist = i, d
+			}
+		}
+
+		if dist > tolerance {
+			keep[furthest] = true
+			stack = append(stack, [2]int{first, furthest}, [2]int{furthest, last})
+		}
+	}
+
+	kept := []*Location{}
+	for i, p := range points {
+		if keep[i] {
+			kept = append(kept, p)
+		}
+	}
+
+	return kept
+}
+
+// Distance in meters from the segment between a and b. Projects onto
+// a plane around a, which is close enough at the scale of a game.
+func (l *Location) distanceToSegment(a, b *Location) float64 {
+	scale := math.Cos(a.Lat * math.Pi / 180)
+	project := func(p *Location) (float64, float64) {
+		x := (p.Lng - a.Lng) * math.Pi / 180 * earthRadius * scale
+		y := (p.Lat - a.Lat) * math.Pi / 180 * earthRadius
+		return x, y
+	}
+
+	px, py := project(l)
+	bx, by := project(b)
+
+	length := bx*bx + by*by
+	if length == 0 {
+		return math.Hypot(px, py)
+	}
+
+	t := math.Max(0, math.Min(1, (px*bx+py*by)/length))
+	return math.Hypot(px-t*bx, py-t*by)
+}
+
+type gpx struct {
+	XMLName  xml.Name    `xml:"gpx"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	Version  string      `xml:"version,attr"`
+	Creator  string      `xml:"creator,attr"`
+	Metadata gpxMetadata `xml:"metadata"`
+	Tracks   []gpxTrack  `xml:"trk"`
+}
+
+type gpxMetadata struct {
+	Name string    `xml:"name"`
+	Time time.Time `xml:"time"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name"`
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  float64   `xml:"lat,attr"`
+	Lng  float64   `xml:"lon,attr"`
+	Ele  float64   `xml:"ele"`
+	Time time.Time `xml:"time"`
+}
+
+// The team's tracks as a GPX 1.1 document, one track per member
+func (t *ReplayTeam) GPX(from time.Time) ([]byte, error) {
+	doc := gpx{
+		Xmlns:   "http://www.topografix.com/GPX/1/1",
+		Version: "1.1",
+		Creator: "Inertia",
+		Metadata: gpxMetadata{
+			Name: t.Team.Name,
+			Time: from.UTC(),
+		},
+		Tracks: []gpxTrack{},
+	}
+
+	for _, track := range t.Tracks {
+		segment := gpxSegment{Points: []gpxPoint{}}
+		for _, p := range track.Points {
+			segment.Points = append(segment.Points, gpxPoint{
+				Lat:  p.Lat,
+				Lng:  p.Lng,
+				Ele:  p.Alt,
+				Time: p.CreatedAt.UTC(),
+			})
+		}
+
+		doc.Tracks = append(doc.Tracks, gpxTrack{
+			Name:     track.User.DisplayName,
+			Segments: []gpxSegment{segment},
+		})
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), out...), nil
+}
+
+// The team's tracks as line strings, with the times of the
+// points in the properties so the route can be animated
+func (t *ReplayTeam) GeoJSON() *GeoJSONFeatureCollection {
+	fc := NewGeoJSONFeatureCollection()
+
+	for _, track := range t.Tracks {
+		if len(track.Points) == 0 {
+			continue
+		}
+
+		coords := [][]float64{}
+		times := []time.Time{}
+		for _, p := range track.Points {
+			coords = append(coords, []float64{p.Lng, p.Lat, p.Alt})
+			times = append(times, p.CreatedAt)
+		}
+
+		geometry := GeoJSONGeometry{Type: "LineString", Coordinates: coords}
+		if len(coords) == 1 {
+			geometry = GeoJSONGeometry{Type: "Point", Coordinates: coords[0]}
+		}
+
+		fc.Features = append(fc.Features, &GeoJSONFeature{
+			Type:     "Feature",
+			Geometry: geometry,
+			Properties: map[string]interface{}{
+				"user_id":   track.User.ID,
+				"user_name": track.User.DisplayName,
+				"team_id":   t.Team.ID,
+				"team_name": t.Team.Name,
+				"color":     t.Team.Color,
+				"times":     times,
+			},
+		})
+	}
+
+	return fc
+}
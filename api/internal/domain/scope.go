@@ -0,0 +1,134 @@
+package domain
+
+import "strings"
+
+// What a token can be used for
+const (
+	ScopeProfile    = "profile"
+	ScopeGamesRead  = "games:read"
+	ScopeGamesWrite = "games:write"
+	ScopeLocation   = "location"
+
+	// Signing out sessions and (un)linking logins, only our own apps
+	// should ever get this one
+	ScopeAccount = "account"
+)
+
+var AllScopes = []string{
+	ScopeProfile,
+	ScopeGamesRead,
+	ScopeGamesWrite,
+	ScopeLocation,
+	ScopeAccount,
+}
+
+// What clients get unless they're registered with more,
+// everything but ScopeAccount
+var DefaultClientScopes = []string{
+	ScopeProfile,
+	ScopeGamesRead,
+	ScopeGamesWrite,
+	ScopeLocation,
+}
+
+// Guests can play the game they were invited to, or just watch
+// it with a spectator invite, and never get ScopeAccount. Only
+// what the client has of those is given out.
+func GuestScopes(clientScopes []string, spectator bool) []string {
+	allowed := DefaultClientScopes
+	if spectator {
+		allowed = []string{ScopeProfile, ScopeGamesRead}
+	}
+
+	scopes := []string{}
+	for _, s := range allowed {
+		if HasScope(clientScopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+
+	return scopes
+}
+
+// Scopes needed under each /api/v5 path, for reading (GET)
+// and for anything else. New route groups go here too.
+var routeScopes = map[string][2]string{
+	"/users":        {ScopeProfile, ScopeProfile},
+	"/devices":      {ScopeProfile, ScopeProfile},
+	"/leaderboard":  {ScopeProfile, ScopeProfile},
+	"/games":        {ScopeGamesRead, ScopeGamesWrite},
+	"/teams":        {ScopeGamesRead, ScopeGamesWrite},
+	"/quest-groups": {ScopeGamesRead, ScopeGamesWrite},
+	"/quests":       {ScopeGamesRead, ScopeGamesWrite},
+	"/powerups":     {ScopeGamesRead, ScopeGamesWrite},
+	"/submissions":  {ScopeGamesRead, ScopeGamesWrite},
+	"/catches":      {ScopeGamesRead, ScopeGamesWrite},
+	"/locations":    {ScopeLocation, ScopeLocation},
+}
+
+// Routes under a group that need a scope of their own,
+// checked before routeScopes
+var accountRoutes = []string{
+	"/users/@me/sessions",
+	"/users/@me/accounts",
+}
+
+const linkAccountRoute = "/users/@me/accounts"
+
+// The scope a request needs, path is relative to /api/v5.
+// Empty if any token will do.
+func RequiredScope(method, path string) string {
+	// Guests don't get ScopeAccount, and linking a login is
+	// how they keep their progress
+	if method == "POST" && path == linkAccountRoute {
+		return ScopeProfile
+	}
+
+	for _, route := range accountRoutes {
+		if path == route || strings.HasPrefix(path, route+"/") {
+			return ScopeAccount
+		}
+	}
+
+	prefix := path
+	if len(path) > 1 {
+		if i := strings.IndexByte(path[1:], '/'); i >= 0 {
+			prefix = path[:i+1]
+		}
+	}
+
+	scopes, ok := routeScopes[prefix]
+	if !ok {
+		return ""
+	}
+
+	if method == "GET" || method == "HEAD" || method == "OPTIONS" {
+		return scopes[0]
+	}
+
+	return scopes[1]
+}
+
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return ErrInvalidScope
+	}
+
+	for _, s := range scopes {
+		if !HasScope(AllScopes, s) {
+			return ErrInvalidScope
+		}
+	}
+
+	return nil
+}
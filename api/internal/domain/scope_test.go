@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGuestScopes(t *testing.T) {
+	tests := []struct {
+		name      string
+		client    []string
+		spectator bool
+		want      []string
+	}{
+		{"player", AllScopes, false, []string{ScopeProfile, ScopeGamesRead, ScopeGamesWrite, ScopeLocation}},
+		{"spectator", AllScopes, true, []string{ScopeProfile, ScopeGamesRead}},
+		{"limited client", []string{ScopeProfile, ScopeAccount}, false, []string{ScopeProfile}},
+		{"client without any", []string{ScopeAccount}, false, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GuestScopes(tt.client, tt.spectator); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		scope  string
+	}{
+		{"GET", "/health", ""},
+		{"GET", "/", ""},
+		{"GET", "/users/@me", ScopeProfile},
+		{"PATCH", "/users/@me", ScopeProfile},
+		{"GET", "/devices", ScopeProfile},
+		{"GET", "/leaderboard", ScopeProfile},
+
+		{"GET", "/games", ScopeGamesRead},
+		{"HEAD", "/games/1", ScopeGamesRead},
+		{"OPTIONS", "/teams/1", ScopeGamesRead},
+		{"POST", "/games", ScopeGamesWrite},
+		{"DELETE", "/teams/1/members/2", ScopeGamesWrite},
+		{"PUT", "/quest-groups/1", ScopeGamesWrite},
+		{"GET", "/catches/1", ScopeGamesRead},
+		{"POST", "/locations", ScopeLocation},
+		{"GET", "/locations/1", ScopeLocation},
+		// Only the first segment counts
+		{"GET", "/gamesx", ""},
+
+		{"GET", "/users/@me/sessions", ScopeAccount},
+		{"DELETE", "/users/@me/sessions/1", ScopeAccount},
+		{"GET", "/users/@me/accounts", ScopeAccount},
+		{"DELETE", "/users/@me/accounts/1", ScopeAccount},
+		{"POST", "/users/@me/accounts", ScopeProfile},
+		{"GET", "/users/@me/sessionsx", ScopeProfile},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			if got := RequiredScope(tt.method, tt.path); got != tt.scope {
+				t.Fatalf("expected %q, got %q", tt.scope, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package domain
+
+import "time"
+
+// A signed in device. Every refresh token it's been given is
+// part of it, so revoking the session revokes all of them,
+// along with the access tokens issued for it.
+type Session struct {
+	ID       string   `json:"id"`
+	UserID   string   `json:"user_id"`
+	ClientID string   `json:"client_id"`
+	Scopes   []string `json:"scopes"`
+	// Guests' sessions only work in the game they were invited to
+	GameID string `json:"game_id,omitempty"`
+
+	// Whatever the app called the device when signing in
+	DeviceName string `json:"device_name"`
+	UserAgent  string `json:"user_agent"`
+
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+
+	// The only refresh token that still works, an older one
+	// turning up means it was stolen
+	Token string `json:"-"`
+	// Whether it's the session making the request
+	Current bool `json:"current" msgpack:"-"`
+}
+
+type SessionCreate struct {
+	UserID     string
+	ClientID   string
+	Scopes     []string
+	GameID     string
+	DeviceName string
+	UserAgent  string
+}
+
+const MaxDeviceNameLength = 64
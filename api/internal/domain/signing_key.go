@@ -0,0 +1,139 @@
+package domain
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	SigningAlgorithmEdDSA = "EdDSA"
+	SigningAlgorithmRS256 = "RS256"
+)
+
+const (
+	// How often a new signing key takes over
+	DefaultSigningKeyRotation = time.Hour * 24 * 30
+	// New keys are in the JWKS this long before they sign
+	// anything, so whoever caches it has them in time
+	SigningKeyPublishLead = time.Hour
+	rsaKeyBits            = 2048
+)
+
+var ErrInvalidSigningAlgorithm = errors.New("signing algorithm must be EdDSA or RS256")
+
+// A key access tokens are signed with, its ID is the kid
+type SigningKey struct {
+	ID         string        `json:"id"`
+	Algorithm  string        `json:"algorithm"`
+	PrivateKey crypto.Signer `json:"-"`
+
+	CreatedAt time.Time `json:"created_at"`
+	// Set once a newer key has taken over, tokens it signed
+	// have expired by then
+	RetiresAt *time.Time `json:"retires_at"`
+}
+
+func NewSigningKey(id, algorithm string) (*SigningKey, error) {
+	var key crypto.Signer
+	var err error
+
+	switch algorithm {
+	case SigningAlgorithmEdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case SigningAlgorithmRS256:
+		key, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		return nil, ErrInvalidSigningAlgorithm
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		ID:         id,
+		Algorithm:  algorithm,
+		PrivateKey: key,
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// PKCS #8, which is how keys are stored
+func (k *SigningKey) MarshalPrivateKey() ([]byte, error) {
+	return x509.MarshalPKCS8PrivateKey(k.PrivateKey)
+}
+
+func (k *SigningKey) UnmarshalPrivateKey(der []byte) error {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return ErrInvalidSigningAlgorithm
+	}
+
+	k.PrivateKey = signer
+	return nil
+}
+
+func (k *SigningKey) Method() jwt.SigningMethod {
+	if k.Algorithm == SigningAlgorithmRS256 {
+		return jwt.SigningMethodRS256
+	}
+
+	return jwt.SigningMethodEdDSA
+}
+
+func (k *SigningKey) PublicKey() crypto.PublicKey {
+	return k.PrivateKey.Public()
+}
+
+// A key in a JWKS (RFC 7517), only the public half
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k *SigningKey) JWK() JWK {
+	jwk := JWK{
+		KeyID:     k.ID,
+		Algorithm: k.Algorithm,
+		Use:       "sig",
+	}
+
+	switch pub := k.PublicKey().(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	}
+
+	return jwk
+}
@@ -14,4 +14,11 @@ const (
 	DeviceSnowflakeNode
 	LiveActivitySnowflakeNode
 	PowerupSnowflakeNode
+	GameResultSnowflakeNode
+	TeamTransactionSnowflakeNode
+	CatchSnowflakeNode
+	QuestSubmissionSnowflakeNode
+	SuspiciousActivitySnowflakeNode
+	SessionSnowflakeNode
+	SigningKeySnowflakeNode
 )
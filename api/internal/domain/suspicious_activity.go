@@ -0,0 +1,32 @@
+package domain
+
+import "time"
+
+// Something a player's device sent that doesn't add up, e.g. a
+// location that's too far from the last one. Only hosts see these.
+type SuspiciousActivity struct {
+	ID     string `json:"id"`
+	GameID string `json:"game_id"`
+	UserID string `json:"user_id"`
+
+	// One of the LocationReject* reasons
+	Kind   string  `json:"kind"`
+	Detail string  `json:"detail"`
+	Lat    float64 `json:"lat"`
+	Lng    float64 `json:"lng"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type SuspiciousActivityCreate struct {
+	GameID string
+	UserID string
+	Kind   string
+	Detail string
+	Lat    float64
+	Lng    float64
+}
+
+// The same kind of activity is logged at most this often per user,
+// a spoofed device would otherwise flood the log
+const SuspiciousActivityCooldown = time.Minute
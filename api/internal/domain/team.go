@@ -17,6 +17,8 @@ type Team struct {
 	IsRunner bool `json:"is_runner"`
 
 	VetoPeriodEnd time.Time `json:"veto_period_end"`
+	// Set when the team is frozen by another team's powerup
+	FrozenUntil *time.Time `json:"frozen_until"`
 
 	GameID    string    `json:"game_id"`
 	CreatedAt time.Time `json:"created_at"`
@@ -31,12 +33,22 @@ func (t *Team) IsVeto() bool {
 	return time.Now().Before(t.VetoPeriodEnd)
 }
 
+func (t *Team) ApplyFreeze(powerups []*Powerup) {
+	t.FrozenUntil = nil
+
+	if freeze := ActiveFreeze(t, powerups); freeze != nil {
+		endsAt := freeze.EndsAt
+		t.FrozenUntil = &endsAt
+	}
+}
+
 type TeamCreate struct {
 	Name       string `json:"name"`
 	Emoji      string `json:"emoji"`
 	Color      string `json:"color"`
 	GameInvite string `json:"game_invite"`
 	GameID     string `json:"-"`
+	Balance    int    `json:"-"`
 }
 
 type TeamUpdate struct {
@@ -0,0 +1,42 @@
+package domain
+
+import "time"
+
+const (
+	TeamTransactionQuestReward     = "quest_reward"
+	TeamTransactionTicketPurchase  = "ticket_purchase"
+	TeamTransactionPowerupPurchase = "powerup_purchase"
+	TeamTransactionHostAdjustment  = "host_adjustment"
+	TeamTransactionRefund          = "refund"
+	TeamTransactionOutOfBounds     = "out_of_bounds"
+)
+
+type TeamTransaction struct {
+	ID     string `json:"id"`
+	TeamID string `json:"team_id"`
+
+	Kind string `json:"kind"`
+	// Signed, negative amounts are debits
+	Amount       int `json:"amount"`
+	XP           int `json:"xp"`
+	BalanceAfter int `json:"balance_after"`
+
+	// ID of the quest/powerup or ticket type the transaction is for
+	Reference string `json:"reference"`
+	Note      string `json:"note"`
+	UserID    string `json:"user_id"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TeamTransactionCreate struct {
+	TeamID string `json:"-"`
+	Kind   string `json:"-"`
+
+	Amount int `json:"amount"`
+	XP     int `json:"-"`
+
+	Reference string `json:"-"`
+	Note      string `json:"note"`
+	UserID    string `json:"-"`
+}
@@ -1,14 +1,31 @@
 package domain
 
 import (
+	"errors"
 	"time"
+	"unicode/utf8"
 )
 
 const (
 	UserAuthRoleBasic = "user"
 	UserAuthRoleAdmin = "admin"
+	// Signed in with just an invite code, can play but
+	// not host until they link a provider
+	UserAuthRoleGuest = "guest"
 )
 
+const MaxGuestNameLength = 32
+
+// Anyone with an invite can make guests, so there's only so
+// many of them per invite and per address in a window
+const (
+	GuestsPerInvite  = 50
+	GuestsPerAddress = 10
+	GuestLimitWindow = time.Hour
+)
+
+var ErrInvalidGuestName = errors.New("name must be between 1 and 32 characters")
+
 type User struct {
 	ID string `json:"id"`
 
@@ -27,3 +44,19 @@ type UserCreate struct {
 
 	AuthRole string `json:"auth_role"`
 }
+
+func (u *User) IsGuest() bool {
+	return u.AuthRole == UserAuthRoleGuest
+}
+
+func (u *User) CanHost() bool {
+	return !u.IsGuest()
+}
+
+func ValidateGuestName(name string) error {
+	if name == "" || utf8.RuneCountInString(name) > MaxGuestNameLength {
+		return ErrInvalidGuestName
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const appleIssuer = "https://appleid.apple.com"
+
+// Apple is OIDC, except the client secret is a JWT signed
+// with a key from the developer account and the code
+// gets posted back
+func newApple(cfg Config) (*oidc, error) {
+	if cfg.TeamID == "" || cfg.KeyID == "" || cfg.PrivateKey == "" {
+		return nil, errors.New("apple needs a team id, key id and private key")
+	}
+
+	// The key is usually in .env on one line
+	block, _ := pem.Decode([]byte(strings.ReplaceAll(cfg.PrivateKey, `\n`, "\n")))
+	if block == nil {
+		return nil, errors.New("apple: private key isn't PEM")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("apple: %w", err)
+	}
+
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("apple: private key isn't an EC key")
+	}
+
+	if cfg.Issuer == "" {
+		cfg.Issuer = appleIssuer
+	}
+
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"name", "email"}
+	}
+
+	o := newOIDC(cfg)
+	o.formPost = true
+	o.clientSecret = func() (string, error) {
+		now := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+			Issuer:    cfg.TeamID,
+			Subject:   cfg.ClientID,
+			Audience:  jwt.ClaimStrings{appleIssuer},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute * 5)),
+		})
+		token.Header["kid"] = cfg.KeyID
+
+		return token.SignedString(key)
+	}
+
+	return o, nil
+}
@@ -0,0 +1,83 @@
+package provider
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+type discord struct {
+	config Config
+}
+
+func newDiscord(cfg Config) *discord {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"identify", "email"}
+	}
+
+	return &discord{config: cfg}
+}
+
+func (d *discord) Name() string {
+	return d.config.Name
+}
+
+func (d *discord) AuthURL(ctx context.Context, state, nonce string) (string, error) {
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", d.config.ClientID)
+	query.Set("scope", strings.Join(d.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("redirect_uri", d.config.RedirectURI)
+
+	return "https://discord.com/oauth2/authorize?" + query.Encode(), nil
+}
+
+func (d *discord) Exchange(ctx context.Context, code, nonce string) (*Identity, error) {
+	var tokenResp struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	form := url.Values{}
+	form.Set("client_id", d.config.ClientID)
+	form.Set("client_secret", d.config.ClientSecret)
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", d.config.RedirectURI)
+
+	if err := postForm(ctx, "https://discord.com/api/oauth2/token", form, &tokenResp); err != nil {
+		return nil, err
+	}
+
+	var userResp struct {
+		ID          string `json:"id"`
+		Username    string `json:"username"`
+		DisplayName string `json:"global_name"`
+		Email       string `json:"email"`
+		Verified    bool   `json:"verified"`
+		Image       string `json:"avatar"`
+	}
+
+	if err := getJSON(ctx, "https://discord.com/api/v10/users/@me", tokenResp.AccessToken, &userResp); err != nil {
+		return nil, err
+	}
+
+	dn := userResp.DisplayName
+	if dn == "" {
+		dn = userResp.Username
+	}
+
+	return &Identity{
+		ID:            userResp.ID,
+		Email:         userResp.Email,
+		EmailVerified: userResp.Verified,
+
+		Username:    userResp.Username,
+		DisplayName: dn,
+		Image:       "https://cdn.discordapp.com/avatars/" + userResp.ID + "/" + userResp.Image + ".png",
+
+		AccessToken:  tokenResp.AccessToken,
+		RefreshToken: tokenResp.RefreshToken,
+	}, nil
+}
@@ -0,0 +1,272 @@
+package provider
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	fakeCodeTTL  = time.Minute
+	fakeTokenTTL = time.Hour
+)
+
+// A tiny OIDC provider the tests sign in with, it signs in
+// whoever is passed as login_hint
+type fakeOIDCServer struct {
+	Issuer string
+
+	mu     sync.Mutex
+	key    *rsa.PrivateKey
+	keyID  string
+	codes  map[string]fakeGrant
+	tokens map[string]fakeGrant
+	// How many times the keys were fetched
+	jwksFetches int
+
+	// Tampers with the ID token before it's signed
+	idTokenClaims func(claims jwt.MapClaims)
+	// Same for userinfo
+	userinfoClaims func(claims jwt.MapClaims)
+}
+
+type fakeGrant struct {
+	ClientID    string
+	RedirectURI string
+	Nonce       string
+	Email       string
+	ExpiresAt   time.Time
+}
+
+func newFakeOIDCServer(t *testing.T) *fakeOIDCServer {
+	t.Helper()
+
+	s := &fakeOIDCServer{
+		codes:  make(map[string]fakeGrant),
+		tokens: make(map[string]fakeGrant),
+	}
+	s.rotate(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discoveryHandler)
+	mux.HandleFunc("/jwks", s.jwksHandler)
+	mux.HandleFunc("/authorize", s.authorizeHandler)
+	mux.HandleFunc("/token", s.tokenHandler)
+	mux.HandleFunc("/userinfo", s.userinfoHandler)
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	s.Issuer = srv.URL
+	return s
+}
+
+// Swaps the signing key for a new one with a new kid,
+// like providers do every now and then
+func (s *fakeOIDCServer) rotate(t *testing.T) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.key = key
+	s.keyID = "fake-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+func (s *fakeOIDCServer) fetches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.jwksFetches
+}
+
+func (s *fakeOIDCServer) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, oidcDiscovery{
+		Issuer:                s.Issuer,
+		AuthorizationEndpoint: s.Issuer + "/authorize",
+		TokenEndpoint:         s.Issuer + "/token",
+		UserinfoEndpoint:      s.Issuer + "/userinfo",
+		JWKSURI:               s.Issuer + "/jwks",
+	})
+}
+
+func (s *fakeOIDCServer) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	pub := s.key.PublicKey
+	kid := s.keyID
+	s.jwksFetches++
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []jwk{{
+			KeyType: "RSA",
+			KeyID:   kid,
+			Use:     "sig",
+			N:       base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// Signs in login_hint straight away
+func (s *fakeOIDCServer) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.Form.Get("response_type") != "code" || r.Form.Get("client_id") == "" || r.Form.Get("login_hint") == "" {
+		http.Error(w, "unsupported request", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(r.Form.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = fakeGrant{
+		ClientID:    r.Form.Get("client_id"),
+		RedirectURI: redirectURI.String(),
+		Nonce:       r.Form.Get("nonce"),
+		Email:       r.Form.Get("login_hint"),
+		ExpiresAt:   time.Now().Add(fakeCodeTTL),
+	}
+	s.mu.Unlock()
+
+	query := redirectURI.Query()
+	query.Set("code", code)
+	query.Set("state", r.Form.Get("state"))
+	redirectURI.RawQuery = query.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *fakeOIDCServer) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	// Codes are single use, like the real thing
+	s.mu.Lock()
+	grant, ok := s.codes[r.Form.Get("code")]
+	delete(s.codes, r.Form.Get("code"))
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(grant.ExpiresAt) ||
+		grant.ClientID != r.Form.Get("client_id") ||
+		grant.RedirectURI != r.Form.Get("redirect_uri") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := s.claims(grant)
+	claims["aud"] = grant.ClientID
+	claims["nonce"] = grant.Nonce
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(fakeTokenTTL).Unix()
+
+	if s.idTokenClaims != nil {
+		s.idTokenClaims(claims)
+	}
+
+	s.mu.Lock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.keyID
+	idToken, err := token.SignedString(s.key)
+	s.mu.Unlock()
+
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	accessToken := randomString()
+	grant.ExpiresAt = now.Add(fakeTokenTTL)
+	s.mu.Lock()
+	s.tokens[accessToken] = grant
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(fakeTokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func (s *fakeOIDCServer) userinfoHandler(w http.ResponseWriter, r *http.Request) {
+	accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mu.Lock()
+	grant, ok := s.tokens[accessToken]
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(grant.ExpiresAt) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+
+	claims := s.claims(grant)
+	if s.userinfoClaims != nil {
+		s.userinfoClaims(claims)
+	}
+
+	writeJSON(w, http.StatusOK, claims)
+}
+
+// Same email, same subject
+func (s *fakeOIDCServer) claims(grant fakeGrant) jwt.MapClaims {
+	sum := sha256.Sum256([]byte(strings.ToLower(grant.Email)))
+	username := usernameFromEmail(grant.Email)
+
+	return jwt.MapClaims{
+		"iss":                s.Issuer,
+		"sub":                hex.EncodeToString(sum[:8]),
+		"email":              grant.Email,
+		"email_verified":     true,
+		"name":               username,
+		"preferred_username": username,
+	}
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
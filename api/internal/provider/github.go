@@ -0,0 +1,106 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// GitHub's OAuth apps, it doesn't do OIDC for users
+type gitHub struct {
+	config Config
+}
+
+func newGitHub(cfg Config) *gitHub {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"read:user", "user:email"}
+	}
+
+	return &gitHub{config: cfg}
+}
+
+func (g *gitHub) Name() string {
+	return g.config.Name
+}
+
+func (g *gitHub) AuthURL(ctx context.Context, state, nonce string) (string, error) {
+	query := url.Values{}
+	query.Set("client_id", g.config.ClientID)
+	query.Set("scope", strings.Join(g.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("redirect_uri", g.config.RedirectURI)
+
+	return "https://github.com/login/oauth/authorize?" + query.Encode(), nil
+}
+
+func (g *gitHub) Exchange(ctx context.Context, code, nonce string) (*Identity, error) {
+	var tokenResp struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		Error        string `json:"error_description"`
+	}
+
+	form := url.Values{}
+	form.Set("client_id", g.config.ClientID)
+	form.Set("client_secret", g.config.ClientSecret)
+	form.Set("code", code)
+	form.Set("redirect_uri", g.config.RedirectURI)
+
+	if err := postForm(ctx, "https://github.com/login/oauth/access_token", form, &tokenResp); err != nil {
+		return nil, err
+	}
+
+	// Errors come back with a 200
+	if tokenResp.AccessToken == "" {
+		return nil, errors.New("github: " + tokenResp.Error)
+	}
+
+	var userResp struct {
+		ID     int64  `json:"id"`
+		Login  string `json:"login"`
+		Name   string `json:"name"`
+		Avatar string `json:"avatar_url"`
+	}
+
+	if err := getJSON(ctx, "https://api.github.com/user", tokenResp.AccessToken, &userResp); err != nil {
+		return nil, err
+	}
+
+	// The profile's email is whatever the user made public,
+	// this says which ones are verified
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+
+	if err := getJSON(ctx, "https://api.github.com/user/emails", tokenResp.AccessToken, &emails); err != nil {
+		return nil, err
+	}
+
+	id := &Identity{
+		ID: strconv.FormatInt(userResp.ID, 10),
+
+		Username:    userResp.Login,
+		DisplayName: userResp.Name,
+		Image:       userResp.Avatar,
+
+		AccessToken:  tokenResp.AccessToken,
+		RefreshToken: tokenResp.RefreshToken,
+	}
+
+	if id.DisplayName == "" {
+		id.DisplayName = userResp.Login
+	}
+
+	for _, e := range emails {
+		if e.Verified && (e.Primary || !id.EmailVerified) {
+			id.Email = e.Email
+			id.EmailVerified = true
+		}
+	}
+
+	return id, nil
+}
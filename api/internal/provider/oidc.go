@@ -0,0 +1,390 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("invalid id token")
+	ErrUnknownKey     = errors.New("id token signed with an unknown key")
+)
+
+// Don't hammer the provider when someone sends a bogus kid
+const jwksRefetchInterval = time.Minute
+
+// Anything with a discovery document, Google included
+type oidc struct {
+	config Config
+
+	// Apple signs its secret per request, everyone else
+	// just has a static one
+	clientSecret func() (string, error)
+	// Apple posts the code back instead of a redirect
+	formPost bool
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcClaims struct {
+	jwt.RegisteredClaims
+	Nonce string `json:"nonce"`
+
+	Email string `json:"email"`
+	// Some providers send this as a string
+	EmailVerified interface{} `json:"email_verified"`
+
+	Name              string `json:"name"`
+	Picture           string `json:"picture"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+func newOIDC(cfg Config) *oidc {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &oidc{
+		config: cfg,
+		clientSecret: func() (string, error) {
+			return cfg.ClientSecret, nil
+		},
+	}
+}
+
+func (o *oidc) Name() string {
+	return o.config.Name
+}
+
+// Fetched on first use so a provider being down doesn't
+// stop the API from starting
+func (o *oidc) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.discovery != nil {
+		return o.discovery, nil
+	}
+
+	endpoint := strings.TrimSuffix(o.config.Issuer, "/") + "/.well-known/openid-configuration"
+
+	var d oidcDiscovery
+	if err := getJSON(ctx, endpoint, "", &d); err != nil {
+		return nil, err
+	}
+
+	if d.Issuer != o.config.Issuer {
+		return nil, fmt.Errorf("%s: discovery issuer %q doesn't match", o.config.Name, d.Issuer)
+	}
+
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("%s: discovery document is missing endpoints", o.config.Name)
+	}
+
+	o.discovery = &d
+	return o.discovery, nil
+}
+
+func (o *oidc) AuthURL(ctx context.Context, state, nonce string) (string, error) {
+	d, err := o.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", o.config.ClientID)
+	query.Set("scope", strings.Join(o.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("redirect_uri", o.config.RedirectURI)
+	if o.formPost {
+		query.Set("response_mode", "form_post")
+	}
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return d.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+func (o *oidc) Exchange(ctx context.Context, code, nonce string) (*Identity, error) {
+	d, err := o.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := o.clientSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	var tokenResp struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		IDToken      string `json:"id_token"`
+	}
+
+	form := url.Values{}
+	form.Set("client_id", o.config.ClientID)
+	form.Set("client_secret", secret)
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", o.config.RedirectURI)
+
+	if err := postForm(ctx, d.TokenEndpoint, form, &tokenResp); err != nil {
+		return nil, err
+	}
+
+	claims, err := o.verifyIDToken(ctx, d, tokenResp.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// Some providers leave the profile out of the ID token
+	if claims.Email == "" && d.UserinfoEndpoint != "" && tokenResp.AccessToken != "" {
+		var info oidcClaims
+		if err := getJSON(ctx, d.UserinfoEndpoint, tokenResp.AccessToken, &info); err != nil {
+			return nil, err
+		}
+
+		// Userinfo isn't signed, it has to be about the same user
+		if info.Subject == claims.Subject {
+			claims.Email = info.Email
+			claims.EmailVerified = info.EmailVerified
+			if claims.Name == "" {
+				claims.Name = info.Name
+			}
+			if claims.Picture == "" {
+				claims.Picture = info.Picture
+			}
+			if claims.PreferredUsername == "" {
+				claims.PreferredUsername = info.PreferredUsername
+			}
+		}
+	}
+
+	id := &Identity{
+		ID:            claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: isTrue(claims.EmailVerified),
+
+		Username:    claims.PreferredUsername,
+		DisplayName: claims.Name,
+		Image:       claims.Picture,
+
+		AccessToken:  tokenResp.AccessToken,
+		RefreshToken: tokenResp.RefreshToken,
+	}
+
+	if id.Username == "" {
+		id.Username = usernameFromEmail(id.Email)
+	}
+
+	if id.Username == "" {
+		id.Username = claims.Name
+	}
+
+	if id.DisplayName == "" {
+		id.DisplayName = id.Username
+	}
+
+	return id, nil
+}
+
+func (o *oidc) verifyIDToken(ctx context.Context, d *oidcDiscovery, raw, nonce string) (*oidcClaims, error) {
+	if raw == "" {
+		return nil, ErrInvalidIDToken
+	}
+
+	var claims oidcClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return o.getKey(ctx, d, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(o.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+
+	if claims.Subject == "" || claims.Nonce != nonce {
+		return nil, ErrInvalidIDToken
+	}
+
+	return &claims, nil
+}
+
+// Keys are cached, an unknown kid means the provider
+// probably rotated so they get fetched again
+func (o *oidc) getKey(ctx context.Context, d *oidcDiscovery, kid string) (crypto.PublicKey, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if key, ok := o.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	if time.Since(o.fetchedAt) < jwksRefetchInterval {
+		return nil, ErrUnknownKey
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := getJSON(ctx, d.JWKSURI, "", &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		// Ones we can't use are skipped, not fatal
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.KeyID] = pub
+		}
+	}
+
+	o.keys = keys
+	o.fetchedAt = time.Now()
+
+	if key, ok := o.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// A token without a kid is fine if there's only one key
+func (o *oidc) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(o.keys) == 1 {
+		for _, key := range o.keys {
+			return key, true
+		}
+	}
+
+	key, ok := o.keys[kid]
+	return key, ok
+}
+
+// Just what's needed to verify ID tokens
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+	N     string `json:"n"`
+	E     string `json:"e"`
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("point isn't on the curve")
+		}
+
+		return pub, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+}
+
+func isTrue(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+
+	return false
+}
@@ -0,0 +1,246 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestOIDC(s *fakeOIDCServer) *oidc {
+	return newOIDC(Config{
+		Name:         "fake",
+		ClientID:     "inertia",
+		ClientSecret: "secret",
+		RedirectURI:  "http://localhost:3001/oauth2/callback/fake",
+		Issuer:       s.Issuer,
+	})
+}
+
+// Goes through the whole flow, the nonce the code was asked
+// for with and the one checked on the way back can differ
+func signIn(t *testing.T, o *oidc, email, authNonce, nonce string) (*Identity, error) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	authURL, err := o.AuthURL(ctx, "state", authNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(authURL + "&login_hint=" + url.QueryEscape(email))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned %s", resp.Status)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state := location.Query().Get("state"); state != "state" {
+		t.Fatalf("state came back as %q", state)
+	}
+
+	return o.Exchange(ctx, location.Query().Get("code"), nonce)
+}
+
+func TestOIDCExchange(t *testing.T) {
+	s := newFakeOIDCServer(t)
+	o := newTestOIDC(s)
+
+	id, err := signIn(t, o, "runner@example.com", "nonce", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id.ID == "" || id.Email != "runner@example.com" || !id.EmailVerified {
+		t.Fatalf("unexpected identity %+v", id)
+	}
+
+	if id.Username != "runner" || id.DisplayName != "runner" {
+		t.Fatalf("unexpected username %q, display name %q", id.Username, id.DisplayName)
+	}
+
+	again, err := signIn(t, o, "runner@example.com", "other", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again.ID != id.ID {
+		t.Fatalf("same email got subjects %q and %q", id.ID, again.ID)
+	}
+}
+
+func TestOIDCRejectsNonce(t *testing.T) {
+	s := newFakeOIDCServer(t)
+	o := newTestOIDC(s)
+
+	if _, err := signIn(t, o, "runner@example.com", "nonce", "stolen"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("expected ErrInvalidIDToken, got %v", err)
+	}
+
+	if _, err := signIn(t, o, "runner@example.com", "", "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("expected ErrInvalidIDToken without a nonce, got %v", err)
+	}
+}
+
+func TestOIDCRejectsClaims(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(claims jwt.MapClaims)
+	}{
+		{"issuer", func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" }},
+		{"audience", func(claims jwt.MapClaims) { claims["aud"] = "someone-else" }},
+		{"expired", func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"no expiry", func(claims jwt.MapClaims) { delete(claims, "exp") }},
+		{"no subject", func(claims jwt.MapClaims) { delete(claims, "sub") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeOIDCServer(t)
+			s.idTokenClaims = tt.tamper
+			o := newTestOIDC(s)
+
+			if _, err := signIn(t, o, "runner@example.com", "nonce", "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+				t.Fatalf("expected ErrInvalidIDToken, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	s := newFakeOIDCServer(t)
+	o := newTestOIDC(s)
+
+	if _, err := signIn(t, o, "runner@example.com", "nonce", "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := signIn(t, o, "runner@example.com", "nonce", "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := s.fetches(); n != 1 {
+		t.Fatalf("keys were fetched %d times, expected them cached", n)
+	}
+
+	s.rotate(t)
+
+	// Too soon after the last fetch, so the unknown kid isn't looked up
+	if _, err := signIn(t, o, "runner@example.com", "nonce", "nonce"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected ErrUnknownKey, got %v", err)
+	}
+
+	if n := s.fetches(); n != 1 {
+		t.Fatalf("keys were fetched %d times, expected no refetch yet", n)
+	}
+
+	o.mu.Lock()
+	o.fetchedAt = time.Now().Add(-jwksRefetchInterval)
+	o.mu.Unlock()
+
+	if _, err := signIn(t, o, "runner@example.com", "nonce", "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := s.fetches(); n != 2 {
+		t.Fatalf("keys were fetched %d times, expected a refetch", n)
+	}
+}
+
+func TestOIDCEmailVerified(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		verified bool
+	}{
+		{"bool true", true, true},
+		{"bool false", false, false},
+		{"string true", "true", true},
+		{"string false", "false", false},
+		{"missing", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeOIDCServer(t)
+			s.idTokenClaims = func(claims jwt.MapClaims) {
+				if tt.value == nil {
+					delete(claims, "email_verified")
+				} else {
+					claims["email_verified"] = tt.value
+				}
+			}
+			o := newTestOIDC(s)
+
+			id, err := signIn(t, o, "runner@example.com", "nonce", "nonce")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if id.EmailVerified != tt.verified {
+				t.Fatalf("expected verified %v, got %v", tt.verified, id.EmailVerified)
+			}
+		})
+	}
+}
+
+func TestOIDCUserinfo(t *testing.T) {
+	s := newFakeOIDCServer(t)
+	s.idTokenClaims = func(claims jwt.MapClaims) {
+		delete(claims, "email")
+		delete(claims, "email_verified")
+	}
+	s.userinfoClaims = func(claims jwt.MapClaims) {
+		claims["email_verified"] = "true"
+	}
+	o := newTestOIDC(s)
+
+	id, err := signIn(t, o, "runner@example.com", "nonce", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id.Email != "runner@example.com" || !id.EmailVerified {
+		t.Fatalf("expected the email from userinfo, got %+v", id)
+	}
+}
+
+func TestOIDCUserinfoForSomeoneElse(t *testing.T) {
+	s := newFakeOIDCServer(t)
+	s.idTokenClaims = func(claims jwt.MapClaims) {
+		delete(claims, "email")
+		delete(claims, "email_verified")
+	}
+	s.userinfoClaims = func(claims jwt.MapClaims) {
+		claims["sub"] = "someone-else"
+	}
+	o := newTestOIDC(s)
+
+	id, err := signIn(t, o, "runner@example.com", "nonce", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id.Email != "" || id.EmailVerified {
+		t.Fatalf("expected userinfo about another subject to be ignored, got %+v", id)
+	}
+}
@@ -0,0 +1,142 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Who the user is according to the provider
+type Identity struct {
+	// Stable ID at the provider, stored as the account ID
+	ID            string
+	Email         string
+	EmailVerified bool
+
+	Username    string
+	DisplayName string
+	Image       string
+
+	AccessToken  string
+	RefreshToken string
+}
+
+// Somewhere users can sign in with, e.g. Discord or Google
+type Provider interface {
+	// Stored as the account type
+	Name() string
+	// Where to send the user to sign in, they come back to the
+	// redirect URI with the state. The nonce ends up in the ID
+	// token of OIDC providers.
+	AuthURL(ctx context.Context, state, nonce string) (string, error)
+	// Swaps the code the user came back with for who they are
+	Exchange(ctx context.Context, code, nonce string) (*Identity, error)
+}
+
+// From the environment, see the README
+type Config struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	// Our callback, registered with the provider
+	RedirectURI string
+
+	// Generic OIDC providers only need this, it's
+	// where the discovery document is
+	Issuer string
+	// Overrides the provider's default scopes
+	Scopes []string
+
+	// Apple signs its client secret with a key instead
+	TeamID     string
+	KeyID      string
+	PrivateKey string
+}
+
+func New(cfg Config) (Provider, error) {
+	if cfg.ClientID == "" {
+		return nil, fmt.Errorf("%s needs a client id", cfg.Name)
+	}
+
+	if cfg.RedirectURI == "" {
+		return nil, fmt.Errorf("%s needs a redirect uri", cfg.Name)
+	}
+
+	switch cfg.Name {
+	case "discord":
+		return newDiscord(cfg), nil
+	case "github":
+		return newGitHub(cfg), nil
+	case "google":
+		if cfg.Issuer == "" {
+			cfg.Issuer = "https://accounts.google.com"
+		}
+		return newOIDC(cfg), nil
+	case "apple":
+		return newApple(cfg)
+	}
+
+	if cfg.Issuer == "" {
+		return nil, fmt.Errorf("%s isn't built in, it needs an OIDC issuer", cfg.Name)
+	}
+
+	return newOIDC(cfg), nil
+}
+
+// Providers can be slow, but not forever
+var httpClient = &http.Client{Timeout: time.Second * 10}
+
+// Sends the request and decodes the JSON response into v
+func doJSON(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Host, resp.Status, body)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func getJSON(ctx context.Context, endpoint, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	return doJSON(req, v)
+}
+
+func postForm(ctx context.Context, endpoint string, form url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doJSON(req, v)
+}
+
+// Usernames are what's before the @ when there's nothing better
+func usernameFromEmail(email string) string {
+	if i := strings.IndexByte(email, '@'); i > 0 {
+		return email[:i]
+	}
+
+	return email
+}
@@ -1,37 +1,150 @@
 package repository
 
 import (
-	"os"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/peonii/inertia/internal/domain"
+	"github.com/redis/go-redis/v9"
 )
 
+var ErrAccessTokenRevoked = errors.New("access token was revoked")
+
 type AccessTokenRepository interface {
-	CreateAccessToken(userID string) (string, error)
+	CreateAccessToken(session *domain.Session) (string, error)
+	// Checks the signature, algorithm, issuer and expiry
+	ParseAccessToken(token string) (*domain.AccessToken, error)
+
+	// Denylists the token until it would've expired
+	RevokeAccessToken(ctx context.Context, token *domain.AccessToken) error
+	IsAccessTokenRevoked(ctx context.Context, token *domain.AccessToken) (bool, error)
 }
 
 type JWTAccessTokenRepository struct {
 	AccessTokenRepository
+	keys *SigningKeyRing
+	rdc  *redis.Client
+
+	// Tokens used to be signed with JWT_SECRET, they're
+	// accepted while it's set
+	legacySecret []byte
 }
 
-func MakeJWTAccessTokenRepository() *JWTAccessTokenRepository {
-	return &JWTAccessTokenRepository{}
+func MakeJWTAccessTokenRepository(keys *SigningKeyRing, rdc *redis.Client, legacySecret string) *JWTAccessTokenRepository {
+	r := &JWTAccessTokenRepository{
+		keys: keys,
+		rdc:  rdc,
+	}
+
+	if legacySecret != "" {
+		r.legacySecret = []byte(legacySecret)
+	}
+
+	return r
 }
 
-func (r *JWTAccessTokenRepository) CreateAccessToken(userID string) (string, error) {
-	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, domain.AccessToken{
+const accessTokenIssuer = "inertia"
+
+func (r *JWTAccessTokenRepository) CreateAccessToken(session *domain.Session) (string, error) {
+	key, err := r.keys.Signing()
+	if err != nil {
+		return "", err
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	tok := jwt.NewWithClaims(key.Method(), domain.AccessToken{
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        base64.RawURLEncoding.EncodeToString(jti),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(domain.AccessTokenExpiryTime)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "inertia",
-			Subject:   userID,
+			Issuer:    accessTokenIssuer,
+			Subject:   session.UserID,
 		},
+		Scope:     strings.Join(session.Scopes, " "),
+		ClientID:  session.ClientID,
+		SessionID: session.ID,
+		GameID:    session.GameID,
 	})
+	tok.Header["kid"] = key.ID
+
+	return tok.SignedString(key.PrivateKey)
+}
+
+func (r *JWTAccessTokenRepository) ParseAccessToken(token string) (*domain.AccessToken, error) {
+	methods := []string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}
+	if r.legacySecret != nil {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+
+	var claims domain.AccessToken
+	jt, err := jwt.ParseWithClaims(token, &claims, r.key,
+		jwt.WithValidMethods(methods),
+		jwt.WithIssuer(accessTokenIssuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if !jt.Valid || claims.Subject == "" {
+		return nil, errors.New("invalid jwt")
+	}
+
+	return &claims, nil
+}
+
+func (r *JWTAccessTokenRepository) key(token *jwt.Token) (interface{}, error) {
+	if token.Method == jwt.SigningMethodHS256 {
+		return r.legacySecret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, err := r.keys.Find(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Otherwise a token could pick how it's checked
+	if key.Method().Alg() != token.Method.Alg() {
+		return nil, errors.New("jwt algorithm doesn't match its key")
+	}
+
+	return key.PublicKey(), nil
+}
+
+func revokedAccessTokenKey(id string) string {
+	return "deny." + id
+}
+
+func (r *JWTAccessTokenRepository) RevokeAccessToken(ctx context.Context, token *domain.AccessToken) error {
+	// Legacy tokens have no ID to revoke them by
+	if token.ID == "" || token.ExpiresAt == nil {
+		return nil
+	}
+
+	ttl := time.Until(token.ExpiresAt.Time)
+	if ttl <= 0 {
+		return nil
+	}
+
+	return r.rdc.Set(ctx, revokedAccessTokenKey(token.ID), 1, ttl).Err()
+}
+
+func (r *JWTAccessTokenRepository) IsAccessTokenRevoked(ctx context.Context, token *domain.AccessToken) (bool, error) {
+	if token.ID == "" {
+		return false, nil
+	}
 
-	// No way to safely get JWT_SECRET without
-	// breaking structure so we have to
-	// use a direct `os.Getenv` call here
-	return tok.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	n, err := r.rdc.Exists(ctx, revokedAccessTokenKey(token.ID)).Result()
+	return n > 0, err
 }
@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/peonii/inertia/internal/domain"
+)
+
+// Keys in memory, they never rotate
+type fakeSigningKeyRepo struct {
+	keys []*domain.SigningKey
+}
+
+func (r *fakeSigningKeyRepo) FindActive(ctx context.Context) ([]*domain.SigningKey, error) {
+	return r.keys, nil
+}
+
+func (r *fakeSigningKeyRepo) Rotate(ctx context.Context, algorithm string, since time.Time) (bool, error) {
+	return false, nil
+}
+
+func (r *fakeSigningKeyRepo) DeleteRetired(ctx context.Context) error {
+	return nil
+}
+
+func newTestSigningKey(t *testing.T, id, algorithm string) *domain.SigningKey {
+	t.Helper()
+
+	key, err := domain.NewSigningKey(id, algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Published long enough ago to sign with
+	key.CreatedAt = time.Now().Add(-2 * domain.SigningKeyPublishLead)
+	return key
+}
+
+func TestParseAccessToken(t *testing.T) {
+	ed := newTestSigningKey(t, "ed", domain.SigningAlgorithmEdDSA)
+	rs := newTestSigningKey(t, "rs", domain.SigningAlgorithmRS256)
+	// Stored as EdDSA but holding an RSA key, tokens can't pick
+	// RS256 for it even though the signature would check out
+	mislabeled := newTestSigningKey(t, "mislabeled", domain.SigningAlgorithmRS256)
+	mislabeled.Algorithm = domain.SigningAlgorithmEdDSA
+	legacySecret := "legacy"
+
+	keys := MakeSigningKeyRing(&fakeSigningKeyRepo{keys: []*domain.SigningKey{ed, rs, mislabeled}}, domain.SigningAlgorithmEdDSA, domain.DefaultSigningKeyRotation)
+	if err := keys.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	rsPublic, err := x509.MarshalPKIXPublicKey(rs.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := func(tamper func(claims jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"iss": accessTokenIssuer,
+			"sub": "user",
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		if tamper != nil {
+			tamper(c)
+		}
+
+		return c
+	}
+
+	sign := func(method jwt.SigningMethod, key interface{}, kid string, c jwt.MapClaims) string {
+		tok := jwt.NewWithClaims(method, c)
+		if kid != "" {
+			tok.Header["kid"] = kid
+		}
+
+		s, err := tok.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return s
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		legacy bool
+		ok     bool
+	}{
+		{"eddsa", sign(jwt.SigningMethodEdDSA, ed.PrivateKey, ed.ID, claims(nil)), false, true},
+		{"rs256", sign(jwt.SigningMethodRS256, rs.PrivateKey, rs.ID, claims(nil)), false, true},
+		{"legacy hs256", sign(jwt.SigningMethodHS256, []byte(legacySecret), "", claims(nil)), true, true},
+		{"legacy hs256 without the secret", sign(jwt.SigningMethodHS256, []byte(legacySecret), "", claims(nil)), false, false},
+
+		{"none", sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, ed.ID, claims(nil)), true, false},
+		// The RSA public key is public, so it can't be an HMAC secret
+		{"hs256 with the public key", sign(jwt.SigningMethodHS256, rsPublic, rs.ID, claims(nil)), true, false},
+		{"hs512", sign(jwt.SigningMethodHS512, []byte(legacySecret), "", claims(nil)), true, false},
+		{"rs256 with the eddsa kid", sign(jwt.SigningMethodRS256, rs.PrivateKey, ed.ID, claims(nil)), false, false},
+		{"eddsa with the rs256 kid", sign(jwt.SigningMethodEdDSA, ed.PrivateKey, rs.ID, claims(nil)), false, false},
+		{"rs256 for an eddsa key", sign(jwt.SigningMethodRS256, mislabeled.PrivateKey, mislabeled.ID, claims(nil)), false, false},
+		{"unknown kid", sign(jwt.SigningMethodEdDSA, ed.PrivateKey, "gone", claims(nil)), false, false},
+		{"no kid", sign(jwt.SigningMethodEdDSA, ed.PrivateKey, "", claims(nil)), false, false},
+
+		{"other issuer", sign(jwt.SigningMethodEdDSA, ed.PrivateKey, ed.ID, claims(func(c jwt.MapClaims) { c["iss"] = "someone-else" })), false, false},
+		{"expired", sign(jwt.SigningMethodEdDSA, ed.PrivateKey, ed.ID, claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() })), false, false},
+		{"no expiry", sign(jwt.SigningMethodEdDSA, ed.PrivateKey, ed.ID, claims(func(c jwt.MapClaims) { delete(c, "exp") })), false, false},
+		{"issued in the future", sign(jwt.SigningMethodEdDSA, ed.PrivateKey, ed.ID, claims(func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() })), false, false},
+		{"no subject", sign(jwt.SigningMethodEdDSA, ed.PrivateKey, ed.ID, claims(func(c jwt.MapClaims) { delete(c, "sub") })), false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := ""
+			if tt.legacy {
+				secret = legacySecret
+			}
+			repo := MakeJWTAccessTokenRepository(keys, nil, secret)
+
+			at, err := repo.ParseAccessToken(tt.token)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("expected the token to parse, got %v", err)
+				}
+				if at.Subject != "user" {
+					t.Fatalf("expected the subject to be user, got %q", at.Subject)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("expected the token to be rejected")
+			}
+		})
+	}
+}
+
+func TestParseAccessTokenRoundTrip(t *testing.T) {
+	for _, algorithm := range []string{domain.SigningAlgorithmEdDSA, domain.SigningAlgorithmRS256} {
+		t.Run(algorithm, func(t *testing.T) {
+			key := newTestSigningKey(t, "key", algorithm)
+			keys := MakeSigningKeyRing(&fakeSigningKeyRepo{keys: []*domain.SigningKey{key}}, algorithm, domain.DefaultSigningKeyRotation)
+			if err := keys.Refresh(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			repo := MakeJWTAccessTokenRepository(keys, nil, "")
+			token, err := repo.CreateAccessToken(&domain.Session{
+				ID:       "session",
+				UserID:   "user",
+				ClientID: "client",
+				Scopes:   []string{domain.ScopeProfile},
+				GameID:   "game",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			at, err := repo.ParseAccessToken(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if at.Subject != "user" || at.SessionID != "session" || at.ClientID != "client" || at.Scope != domain.ScopeProfile || at.GameID != "game" {
+				t.Fatalf("unexpected claims %+v", at)
+			}
+
+			// Dropped from the ring, like a retired key
+			keys.keys = nil
+			if _, err := repo.ParseAccessToken(token); !errors.Is(err, ErrNoSigningKey) {
+				t.Fatalf("expected ErrNoSigningKey, got %v", err)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/bwmarrin/snowflake"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/peonii/inertia/internal/domain"
 )
@@ -12,6 +13,8 @@ type AccountRepository interface {
 	FindOne(ctx context.Context, id string) (*domain.Account, error)
 	FindByEmail(ctx context.Context, email string) ([]*domain.Account, error)
 	FindByAccountID(ctx context.Context, id string, provider string) (*domain.Account, error)
+	// Oldest first
+	FindByUserID(ctx context.Context, userID string) ([]*domain.Account, error)
 
 	Create(ctx context.Context, account *domain.AccountCreate) (*domain.Account, error)
 	Update(ctx context.Context, account *domain.Account) (*domain.Account, error)
@@ -29,21 +32,19 @@ func MakePostgresAccountRepository(db *pgxpool.Pool) *PostgresAccountRepository
 	}
 }
 
-func (r *PostgresAccountRepository) FindOne(ctx context.Context, id string) (*domain.Account, error) {
-	query := `
-		SELECT
-			id, user_id, account_type, account_id, email, access_token, refresh_token, created_at
-		FROM accounts
-		WHERE id = $1
-	`
+const accountColumns = `
+	id, user_id, account_type, account_id, email, email_verified, access_token, refresh_token, created_at
+`
 
+func scanAccount(row pgx.Row) (*domain.Account, error) {
 	var account domain.Account
-	if err := r.db.QueryRow(ctx, query, id).Scan(
+	if err := row.Scan(
 		&account.ID,
 		&account.UserID,
 		&account.AccountType,
 		&account.AccountID,
 		&account.Email,
+		&account.EmailVerified,
 		&account.AccessToken,
 		&account.RefreshToken,
 		&account.CreatedAt,
@@ -54,73 +55,79 @@ func (r *PostgresAccountRepository) FindOne(ctx context.Context, id string) (*do
 	return &account, nil
 }
 
+func scanAccounts(rows pgx.Rows) ([]*domain.Account, error) {
+	defer rows.Close()
+
+	accounts := []*domain.Account{}
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
+
+func (r *PostgresAccountRepository) FindOne(ctx context.Context, id string) (*domain.Account, error) {
+	query := `
+		SELECT ` + accountColumns + `
+		FROM accounts
+		WHERE id = $1
+	`
+
+	return scanAccount(r.db.QueryRow(ctx, query, id))
+}
+
 func (r *PostgresAccountRepository) FindByEmail(ctx context.Context, email string) ([]*domain.Account, error) {
 	query := `
-		SELECT
-			id, user_id, account_type, account_id, email, access_token, refresh_token, created_at
+		SELECT ` + accountColumns + `
 		FROM accounts
 		WHERE email = $1
+		ORDER BY created_at
 	`
 
 	rows, err := r.db.Query(ctx, query, email)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	accounts := []*domain.Account{}
-	for rows.Next() {
-		var account domain.Account
-		if err := rows.Scan(
-			&account.ID,
-			&account.UserID,
-			&account.AccountType,
-			&account.AccountID,
-			&account.Email,
-			&account.AccessToken,
-			&account.RefreshToken,
-			&account.CreatedAt,
-		); err != nil {
-			return nil, err
-		}
-
-		accounts = append(accounts, &account)
-	}
 
-	return accounts, nil
+	return scanAccounts(rows)
 }
 
 func (r *PostgresAccountRepository) FindByAccountID(ctx context.Context, id string, provider string) (*domain.Account, error) {
 	query := `
-		SELECT
-			id, user_id, account_type, account_id, email, access_token, refresh_token, created_at
+		SELECT ` + accountColumns + `
 		FROM accounts
 		WHERE account_type = $1 AND account_id = $2
 	`
 
-	var account domain.Account
-	if err := r.db.QueryRow(ctx, query, provider, id).Scan(
-		&account.ID,
-		&account.UserID,
-		&account.AccountType,
-		&account.AccountID,
-		&account.Email,
-		&account.AccessToken,
-		&account.RefreshToken,
-		&account.CreatedAt,
-	); err != nil {
+	return scanAccount(r.db.QueryRow(ctx, query, provider, id))
+}
+
+func (r *PostgresAccountRepository) FindByUserID(ctx context.Context, userID string) ([]*domain.Account, error) {
+	query := `
+		SELECT ` + accountColumns + `
+		FROM accounts
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
 		return nil, err
 	}
 
-	return &account, nil
+	return scanAccounts(rows)
 }
 
 func (r *PostgresAccountRepository) Create(ctx context.Context, account *domain.AccountCreate) (*domain.Account, error) {
 	query := `
-		INSERT INTO accounts (id, user_id, account_type, account_id, email, access_token, refresh_token)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, user_id, account_type, account_id, email, access_token, refresh_token, created_at
-	`
+		INSERT INTO accounts (id, user_id, account_type, account_id, email, email_verified, access_token, refresh_token)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING ` + accountColumns
 
 	node, err := snowflake.NewNode(domain.AccountSnowflakeNode)
 	if err != nil {
@@ -129,29 +136,16 @@ func (r *PostgresAccountRepository) Create(ctx context.Context, account *domain.
 
 	id := node.Generate().String()
 
-	var newAccount domain.Account
-	if err := r.db.QueryRow(ctx, query,
+	return scanAccount(r.db.QueryRow(ctx, query,
 		id,
 		account.UserID,
 		account.AccountType,
 		account.AccountID,
 		account.Email,
+		account.EmailVerified,
 		account.AccessToken,
 		account.RefreshToken,
-	).Scan(
-		&newAccount.ID,
-		&newAccount.UserID,
-		&newAccount.AccountType,
-		&newAccount.AccountID,
-		&newAccount.Email,
-		&newAccount.AccessToken,
-		&newAccount.RefreshToken,
-		&newAccount.CreatedAt,
-	); err != nil {
-		return nil, err
-	}
-
-	return &newAccount, nil
+	))
 }
 
 func (r *PostgresAccountRepository) Update(ctx context.Context, account *domain.Account) (*domain.Account, error) {
@@ -162,35 +156,22 @@ func (r *PostgresAccountRepository) Update(ctx context.Context, account *domain.
 			account_type = $3,
 			account_id = $4,
 			email = $5,
-			access_token = $6,
-			refresh_token = $7
+			email_verified = $6,
+			access_token = $7,
+			refresh_token = $8
 		WHERE id = $1
-		RETURNING id, user_id, account_type, account_id, email, access_token, refresh_token, created_at
-	`
+		RETURNING ` + accountColumns
 
-	var updatedAccount domain.Account
-	if err := r.db.QueryRow(ctx, query,
+	return scanAccount(r.db.QueryRow(ctx, query,
 		account.ID,
 		account.UserID,
 		account.AccountType,
 		account.AccountID,
 		account.Email,
+		account.EmailVerified,
 		account.AccessToken,
 		account.RefreshToken,
-	).Scan(
-		&updatedAccount.ID,
-		&updatedAccount.UserID,
-		&updatedAccount.AccountType,
-		&updatedAccount.AccountID,
-		&updatedAccount.Email,
-		&updatedAccount.AccessToken,
-		&updatedAccount.RefreshToken,
-		&updatedAccount.CreatedAt,
-	); err != nil {
-		return nil, err
-	}
-
-	return &updatedAccount, nil
+	))
 }
 
 func (r *PostgresAccountRepository) Delete(ctx context.Context, id string) error {
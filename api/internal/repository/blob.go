@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInvalidBlobKey = errors.New("invalid blob key")
+
+// Stores uploaded files (quest evidence) by key
+type BlobRepository interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Keeps blobs as plain files under a directory
+type LocalBlobRepository struct {
+	dir string
+}
+
+func MakeLocalBlobRepository(dir string) *LocalBlobRepository {
+	return &LocalBlobRepository{
+		dir: dir,
+	}
+}
+
+func (r *LocalBlobRepository) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "..") {
+		return "", ErrInvalidBlobKey
+	}
+
+	return filepath.Join(r.dir, clean), nil
+}
+
+func (r *LocalBlobRepository) Put(ctx context.Context, key string, src io.Reader) error {
+	p, err := r.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see half a blob
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
+
+func (r *LocalBlobRepository) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := r.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(p)
+}
+
+func (r *LocalBlobRepository) Delete(ctx context.Context, key string) error {
+	p, err := r.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,258 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/peonii/inertia/internal/domain"
+)
+
+var ErrCatchNotPending = errors.New("catch has already been resolved")
+
+type CatchRepository interface {
+	FindOne(ctx context.Context, id string) (*domain.Catch, error)
+	FindByGameID(ctx context.Context, gameID string) ([]*domain.Catch, error)
+
+	// Records the catch. Accepted catches swap the runners and update
+	// the stats of both teams' members in the same transaction, or get
+	// rejected if the runners were caught by someone else first.
+	Create(ctx context.Context, catch *domain.CatchCreate) (*domain.Catch, error)
+
+	// Accepts or rejects a pending catch, same as Create. Returns
+	// ErrCatchNotPending if someone else resolved it first.
+	Resolve(ctx context.Context, id, status, userID string) (*domain.Catch, error)
+}
+
+type PostgresCatchRepository struct {
+	db *pgxpool.Pool
+}
+
+func MakePostgresCatchRepository(db *pgxpool.Pool) *PostgresCatchRepository {
+	return &PostgresCatchRepository{
+		db: db,
+	}
+}
+
+const catchColumns = `
+	id, game_id, catcher_id, runner_id, user_id, status, distance, resolved_by, resolved_at, created_at
+`
+
+func scanCatch(row pgx.Row) (*domain.Catch, error) {
+	var c domain.Catch
+	if err := row.Scan(
+		&c.ID,
+		&c.GameID,
+		&c.CatcherID,
+		&c.RunnerID,
+		&c.UserID,
+		&c.Status,
+		&c.Distance,
+		&c.ResolvedBy,
+		&c.ResolvedAt,
+		&c.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (r *PostgresCatchRepository) FindOne(ctx context.Context, id string) (*domain.Catch, error) {
+	query := `SELECT ` + catchColumns + ` FROM catches WHERE id = $1`
+
+	return scanCatch(r.db.QueryRow(ctx, query, id))
+}
+
+func (r *PostgresCatchRepository) FindByGameID(ctx context.Context, gameID string) ([]*domain.Catch, error) {
+	query := `SELECT ` + catchColumns + ` FROM catches WHERE game_id = $1 ORDER BY created_at DESC`
+
+	rows, err := r.db.Query(ctx, query, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	catches := []*domain.Catch{}
+	for rows.Next() {
+		c, err := scanCatch(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		catches = append(catches, c)
+	}
+
+	return catches, rows.Err()
+}
+
+func (r *PostgresCatchRepository) Create(ctx context.Context, catch *domain.CatchCreate) (*domain.Catch, error) {
+	node, err := snowflake.NewNode(domain.CatchSnowflakeNode)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	status := catch.Status
+	if status == domain.CatchStatusAccepted {
+		swapped, err := r.swapRunners(ctx, tx, catch.CatcherID, catch.RunnerID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !swapped {
+			status = domain.CatchStatusRejected
+		}
+	}
+
+	query := `
+		INSERT INTO catches (id, game_id, catcher_id, runner_id, user_id, status, distance)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + catchColumns
+
+	c, err := scanCatch(tx.QueryRow(ctx, query,
+		node.Generate().String(),
+		catch.GameID,
+		catch.CatcherID,
+		catch.RunnerID,
+		catch.UserID,
+		status,
+		catch.Distance,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Status == domain.CatchStatusAccepted {
+		if err := r.recordStats(ctx, tx, c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (r *PostgresCatchRepository) Resolve(ctx context.Context, id, status, userID string) (*domain.Catch, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Rolled back with everything else if the catch isn't pending
+	if status == domain.CatchStatusAccepted {
+		c, err := r.FindOne(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		swapped, err := r.swapRunners(ctx, tx, c.CatcherID, c.RunnerID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !swapped {
+			status = domain.CatchStatusRejected
+		}
+	}
+
+	query := `
+		UPDATE catches
+		SET status = $2, resolved_by = $3, resolved_at = now()
+		WHERE id = $1 AND status = 'pending'
+		RETURNING ` + catchColumns
+
+	c, err := scanCatch(tx.QueryRow(ctx, query, id, status, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrCatchNotPending
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Status == domain.CatchStatusAccepted {
+		if err := r.recordStats(ctx, tx, c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Makes the caught team hunters and the catching team runners, as long
+// as they still are what the catch expects. Returns false and changes
+// nothing if someone else caught the runners first. The caught team's
+// row stays locked until the transaction ends, so concurrent catches
+// wait for each other.
+func (r *PostgresCatchRepository) swapRunners(ctx context.Context, tx pgx.Tx, catcherID, runnerID string) (bool, error) {
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer sp.Rollback(ctx)
+
+	tag, err := sp.Exec(ctx, `UPDATE teams SET is_runner = false WHERE id = $1 AND is_runner`, runnerID)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	tag, err = sp.Exec(ctx, `UPDATE teams SET is_runner = true WHERE id = $1 AND NOT is_runner`, catcherID)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if _, err := sp.Exec(ctx, `
+		UPDATE runner_periods SET ended_at = now() WHERE team_id = $1 AND ended_at IS NULL
+	`, runnerID); err != nil {
+		return false, err
+	}
+
+	if _, err := sp.Exec(ctx, `
+		INSERT INTO runner_periods (team_id)
+		SELECT $1
+		WHERE NOT EXISTS (
+			SELECT 1 FROM runner_periods WHERE team_id = $1 AND ended_at IS NULL
+		)
+	`, catcherID); err != nil {
+		return false, err
+	}
+
+	return true, sp.Commit(ctx)
+}
+
+func (r *PostgresCatchRepository) recordStats(ctx context.Context, tx pgx.Tx, c *domain.Catch) error {
+	if _, err := tx.Exec(ctx, `
+		UPDATE user_stats SET catches = catches + 1
+		WHERE user_id IN (SELECT user_id FROM teams_users WHERE team_id = $1)
+	`, c.CatcherID); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(ctx, `
+		UPDATE user_stats SET times_caught = times_caught + 1
+		WHERE user_id IN (SELECT user_id FROM teams_users WHERE team_id = $1)
+	`, c.RunnerID)
+
+	return err
+}
@@ -14,12 +14,23 @@ type GameRepository interface {
 	FindOne(ctx context.Context, id string) (*domain.Game, error)
 
 	FindAllByHostID(ctx context.Context, hostID string) ([]*domain.Game, error)
+	// Games the user hosts, plays in or has a role in
+	FindAllForUser(ctx context.Context, userID string) ([]*domain.Game, error)
 
 	Create(ctx context.Context, game *domain.GameCreate) (*domain.Game, error)
 	Update(ctx context.Context, id string, game *domain.GameUpdate) (*domain.Game, error)
+	// A nil area lets players go anywhere
+	UpdatePlayArea(ctx context.Context, id string, area *domain.GeoJSONPolygon) (*domain.Game, error)
 	Delete(ctx context.Context, id string) error
 
 	FindAllUsersIDs(ctx context.Context, gameID string) ([]string, error)
+
+	UpdateStatus(ctx context.Context, id string, from string, to string) (*domain.Game, error)
+	FindDueToStart(ctx context.Context) ([]*domain.Game, error)
+	FindDueToFinish(ctx context.Context) ([]*domain.Game, error)
+	// Finished games that haven't been settled, when
+	// settling them at the finish failed
+	FindUnsettled(ctx context.Context) ([]*domain.Game, error)
 }
 
 type PostgresGameRepository struct {
@@ -36,7 +47,7 @@ func MakePostgresGameRepository(db *pgxpool.Pool) *PostgresGameRepository {
 func (r *PostgresGameRepository) FindAll(ctx context.Context) ([]*domain.Game, error) {
 	query := `
 		SELECT
-			id, name, official, host_id, time_start, time_end, loc_lat, loc_lng, created_at
+			id, name, official, host_id, status, ranking, time_start, time_end, loc_lat, loc_lng, play_area, created_at
 		FROM games
 	`
 
@@ -53,10 +64,13 @@ func (r *PostgresGameRepository) FindAll(ctx context.Context) ([]*domain.Game, e
 			&game.Name,
 			&game.Official,
 			&game.HostID,
+			&game.Status,
+			&game.Ranking,
 			&game.TimeStart,
 			&game.TimeEnd,
 			&game.LocLat,
 			&game.LocLng,
+			&game.PlayArea,
 			&game.CreatedAt,
 		); err != nil {
 			return nil, err
@@ -71,7 +85,7 @@ func (r *PostgresGameRepository) FindAll(ctx context.Context) ([]*domain.Game, e
 func (r *PostgresGameRepository) FindOne(ctx context.Context, id string) (*domain.Game, error) {
 	query := `
 		SELECT
-			id, name, official, host_id, time_start, time_end, loc_lat, loc_lng, created_at
+			id, name, official, host_id, status, ranking, time_start, time_end, loc_lat, loc_lng, play_area, created_at
 		FROM games
 		WHERE id = $1
 	`
@@ -82,10 +96,13 @@ func (r *PostgresGameRepository) FindOne(ctx context.Context, id string) (*domai
 		&game.Name,
 		&game.Official,
 		&game.HostID,
+		&game.Status,
+		&game.Ranking,
 		&game.TimeStart,
 		&game.TimeEnd,
 		&game.LocLat,
 		&game.LocLng,
+		&game.PlayArea,
 		&game.CreatedAt,
 	); err != nil {
 		return nil, err
@@ -97,7 +114,7 @@ func (r *PostgresGameRepository) FindOne(ctx context.Context, id string) (*domai
 func (r *PostgresGameRepository) FindAllByHostID(ctx context.Context, hostID string) ([]*domain.Game, error) {
 	query := `
 		SELECT
-			id, name, official, host_id, time_start, time_end, loc_lat, loc_lng, created_at
+			id, name, official, host_id, status, ranking, time_start, time_end, loc_lat, loc_lng, play_area, created_at
 		FROM games
 		WHERE host_id = $1
 	`
@@ -115,10 +132,13 @@ func (r *PostgresGameRepository) FindAllByHostID(ctx context.Context, hostID str
 			&game.Name,
 			&game.Official,
 			&game.HostID,
+			&game.Status,
+			&game.Ranking,
 			&game.TimeStart,
 			&game.TimeEnd,
 			&game.LocLat,
 			&game.LocLng,
+			&game.PlayArea,
 			&game.CreatedAt,
 		); err != nil {
 			return nil, err
@@ -130,14 +150,37 @@ func (r *PostgresGameRepository) FindAllByHostID(ctx context.Context, hostID str
 	return games, nil
 }
 
+func (r *PostgresGameRepository) FindAllForUser(ctx context.Context, userID string) ([]*domain.Game, error) {
+	query := `
+		SELECT
+			id, name, official, host_id, status, ranking, time_start, time_end, loc_lat, loc_lng, play_area, created_at
+		FROM games g
+		WHERE g.host_id = $1
+			OR EXISTS (
+				SELECT 1
+				FROM game_roles gr
+				WHERE gr.game_id = g.id AND gr.user_id = $1
+			)
+			OR EXISTS (
+				SELECT 1
+				FROM teams_users tu
+				JOIN teams t ON t.id = tu.team_id
+				WHERE t.game_id = g.id AND tu.user_id = $1
+			)
+		ORDER BY g.created_at DESC
+	`
+
+	return r.queryMany(ctx, query, userID)
+}
+
 func (r *PostgresGameRepository) Create(ctx context.Context, game *domain.GameCreate) (*domain.Game, error) {
 	query := `
 		INSERT INTO games (
-			id, name, official, host_id, time_start, time_end, loc_lat, loc_lng
+			id, name, official, host_id, status, ranking, time_start, time_end, loc_lat, loc_lng
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
 		) RETURNING
-			id, name, official, host_id, time_start, time_end, loc_lat, loc_lng, created_at
+			id, name, official, host_id, status, ranking, time_start, time_end, loc_lat, loc_lng, play_area, created_at
 	`
 
 	node, err := snowflake.NewNode(domain.GameSnowflakeNode)
@@ -153,6 +196,8 @@ func (r *PostgresGameRepository) Create(ctx context.Context, game *domain.GameCr
 		game.Name,
 		false,
 		game.HostID,
+		domain.GameStatusLobby,
+		game.Ranking,
 		game.TimeStart,
 		game.TimeEnd,
 		game.LocLat,
@@ -162,10 +207,13 @@ func (r *PostgresGameRepository) Create(ctx context.Context, game *domain.GameCr
 		&g.Name,
 		&g.Official,
 		&g.HostID,
+		&g.Status,
+		&g.Ranking,
 		&g.TimeStart,
 		&g.TimeEnd,
 		&g.LocLat,
 		&g.LocLng,
+		&g.PlayArea,
 		&g.CreatedAt,
 	); err != nil {
 		return nil, err
@@ -184,7 +232,7 @@ func (r *PostgresGameRepository) Update(ctx context.Context, id string, game *do
 		SET %s
 		WHERE id = $1
 		RETURNING
-			id, name, official, host_id, time_start, time_end, loc_lat, loc_lng, created_at
+			id, name, official, host_id, status, ranking, time_start, time_end, loc_lat, loc_lng, play_area, created_at
 	`, qtext)
 
 	var g domain.Game
@@ -193,10 +241,43 @@ func (r *PostgresGameRepository) Update(ctx context.Context, id string, game *do
 		&g.Name,
 		&g.Official,
 		&g.HostID,
+		&g.Status,
+		&g.Ranking,
+		&g.TimeStart,
+		&g.TimeEnd,
+		&g.LocLat,
+		&g.LocLng,
+		&g.PlayArea,
+		&g.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+func (r *PostgresGameRepository) UpdatePlayArea(ctx context.Context, id string, area *domain.GeoJSONPolygon) (*domain.Game, error) {
+	query := `
+		UPDATE games
+		SET play_area = $2
+		WHERE id = $1
+		RETURNING
+			id, name, official, host_id, status, ranking, time_start, time_end, loc_lat, loc_lng, play_area, created_at
+	`
+
+	var g domain.Game
+	if err := r.db.QueryRow(ctx, query, id, area).Scan(
+		&g.ID,
+		&g.Name,
+		&g.Official,
+		&g.HostID,
+		&g.Status,
+		&g.Ranking,
 		&g.TimeStart,
 		&g.TimeEnd,
 		&g.LocLat,
 		&g.LocLng,
+		&g.PlayArea,
 		&g.CreatedAt,
 	); err != nil {
 		return nil, err
@@ -244,3 +325,131 @@ func (r *PostgresGameRepository) FindAllUsersIDs(ctx context.Context, id string)
 
 	return ids, nil
 }
+
+// Only moves the game if it's still in the `from` status,
+// so concurrent transitions can't overwrite each other.
+// Finishing the game also ends its open runner periods.
+func (r *PostgresGameRepository) UpdateStatus(ctx context.Context, id string, from string, to string) (*domain.Game, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		UPDATE games
+		SET status = $3
+		WHERE id = $1 AND status = $2
+		RETURNING
+			id, name, official, host_id, status, ranking, time_start, time_end, loc_lat, loc_lng, play_area, created_at
+	`
+
+	var g domain.Game
+	if err := tx.QueryRow(ctx, query, id, from, to).Scan(
+		&g.ID,
+		&g.Name,
+		&g.Official,
+		&g.HostID,
+		&g.Status,
+		&g.Ranking,
+		&g.TimeStart,
+		&g.TimeEnd,
+		&g.LocLat,
+		&g.LocLng,
+		&g.PlayArea,
+		&g.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	// Runner time is scored from these, so they end when the game
+	// did and not whenever it gets settled. Finishing late (the
+	// worker) still ends them at the scheduled end.
+	if g.Status == domain.GameStatusFinished {
+		periodsQuery := `
+			UPDATE runner_periods
+			SET ended_at = LEAST(now(), $2)
+			WHERE ended_at IS NULL AND team_id IN (SELECT id FROM teams WHERE game_id = $1)
+		`
+
+		if _, err := tx.Exec(ctx, periodsQuery, g.ID, g.TimeEnd); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+func (r *PostgresGameRepository) FindDueToStart(ctx context.Context) ([]*domain.Game, error) {
+	query := `
+		SELECT
+			id, name, official, host_id, status, ranking, time_start, time_end, loc_lat, loc_lng, play_area, created_at
+		FROM games
+		WHERE status = $1 AND time_start <= now() AND time_end > now()
+	`
+
+	return r.queryMany(ctx, query, domain.GameStatusLobby)
+}
+
+func (r *PostgresGameRepository) FindDueToFinish(ctx context.Context) ([]*domain.Game, error) {
+	query := `
+		SELECT
+			id, name, official, host_id, status, ranking, time_start, time_end, loc_lat, loc_lng, play_area, created_at
+		FROM games
+		WHERE status = ANY($1) AND time_end <= now()
+	`
+
+	return r.queryMany(ctx, query, []string{
+		domain.GameStatusLobby,
+		domain.GameStatusRunning,
+		domain.GameStatusPaused,
+	})
+}
+
+func (r *PostgresGameRepository) FindUnsettled(ctx context.Context) ([]*domain.Game, error) {
+	query := `
+		SELECT
+			id, name, official, host_id, status, ranking, time_start, time_end, loc_lat, loc_lng, play_area, created_at
+		FROM games
+		WHERE status = $1 AND settled_at IS NULL
+	`
+
+	return r.queryMany(ctx, query, domain.GameStatusFinished)
+}
+
+func (r *PostgresGameRepository) queryMany(ctx context.Context, query string, args ...interface{}) ([]*domain.Game, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	games := []*domain.Game{}
+	for rows.Next() {
+		var game domain.Game
+		if err := rows.Scan(
+			&game.ID,
+			&game.Name,
+			&game.Official,
+			&game.HostID,
+			&game.Status,
+			&game.Ranking,
+			&game.TimeStart,
+			&game.TimeEnd,
+			&game.LocLat,
+			&game.LocLng,
+			&game.PlayArea,
+			&game.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		games = append(games, &game)
+	}
+
+	return games, nil
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/peonii/inertia/internal/domain"
+	"github.com/redis/go-redis/v9"
+)
+
+type GameEventRepository interface {
+	// Numbers the event, buffers it and publishes it
+	// to the game's channel. Returns the event's seq.
+	Publish(ctx context.Context, gameID, typ string, data []byte) (int64, error)
+	// Seq of the last event published in the game
+	Latest(ctx context.Context, gameID string) (int64, error)
+	// Buffered events after seq, oldest first. Complete is false
+	// if some of them are no longer buffered.
+	Since(ctx context.Context, gameID string, seq int64) (events []*domain.GameEvent, complete bool, err error)
+}
+
+type RedisGameEventRepository struct {
+	rdc *redis.Client
+}
+
+func MakeRedisGameEventRepository(rdc *redis.Client) *RedisGameEventRepository {
+	return &RedisGameEventRepository{
+		rdc: rdc,
+	}
+}
+
+func gameEventSeqKey(gameID string) string {
+	return "events:game:" + gameID + ":seq"
+}
+
+func gameEventBufferKey(gameID string) string {
+	return "events:game:" + gameID + ":buffer"
+}
+
+// Numbering, buffering and publishing happen in one go, so
+// events always reach subscribers in the order of their seq
+var publishGameEventScript = redis.NewScript(`
+local seq = redis.call("INCR", KEYS[1])
+local event = '{"seq":' .. seq .. ',"typ":"' .. ARGV[2] .. '","dat":' .. ARGV[3] .. '}'
+
+redis.call("LPUSH", KEYS[2], event)
+redis.call("LTRIM", KEYS[2], 0, tonumber(ARGV[4]) - 1)
+redis.call("EXPIRE", KEYS[1], ARGV[5])
+redis.call("EXPIRE", KEYS[2], ARGV[5])
+redis.call("PUBLISH", ARGV[1], event)
+
+return seq
+`)
+
+func (r *RedisGameEventRepository) Publish(ctx context.Context, gameID, typ string, data []byte) (int64, error) {
+	if !json.Valid(data) {
+		return 0, errors.New("event data is not valid json")
+	}
+
+	return publishGameEventScript.Run(ctx, r.rdc,
+		[]string{gameEventSeqKey(gameID), gameEventBufferKey(gameID)},
+		domain.GameEventsChannel(gameID),
+		typ,
+		string(data),
+		domain.GameEventBufferSize,
+		int(domain.GameEventBufferTTL.Seconds()),
+	).Int64()
+}
+
+func (r *RedisGameEventRepository) Latest(ctx context.Context, gameID string) (int64, error) {
+	seq, err := r.rdc.Get(ctx, gameEventSeqKey(gameID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(seq, 10, 64)
+}
+
+func (r *RedisGameEventRepository) Since(ctx context.Context, gameID string, seq int64) ([]*domain.GameEvent, bool, error) {
+	latest, err := r.Latest(ctx, gameID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The counter expired and started over
+	if seq > latest {
+		return []*domain.GameEvent{}, false, nil
+	}
+
+	// Newest first
+	raw, err := r.rdc.LRange(ctx, gameEventBufferKey(gameID), 0, -1).Result()
+	if err != nil {
+		return nil, false, err
+	}
+
+	events := []*domain.GameEvent{}
+	for i := len(raw) - 1; i >= 0; i-- {
+		var ev domain.GameEvent
+		if err := json.Unmarshal([]byte(raw[i]), &ev); err != nil {
+			return nil, false, err
+		}
+
+		if ev.Seq > seq {
+			events = append(events, &ev)
+		}
+	}
+
+	complete := seq == latest || (len(events) > 0 && events[0].Seq == seq+1)
+
+	return events, complete, nil
+}
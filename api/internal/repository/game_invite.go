@@ -30,9 +30,9 @@ func MakePostgresGameInviteRepository(db *pgxpool.Pool) *PostgresGameInviteRepos
 
 func (r *PostgresGameInviteRepository) Create(ctx context.Context, gameInvite *domain.GameInviteCreate) (*domain.GameInvite, error) {
 	query := `
-		INSERT INTO game_invite (id, slug, game_id, uses)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, slug, game_id, uses
+		INSERT INTO game_invite (id, slug, game_id, uses, kind)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, slug, game_id, uses, kind
 	`
 
 	node, err := snowflake.NewNode(domain.GameInviteSnowflakeNode)
@@ -46,10 +46,10 @@ func (r *PostgresGameInviteRepository) Create(ctx context.Context, gameInvite *d
 		return nil, err
 	}
 	slug := base32.StdEncoding.EncodeToString(sData)
-	row := r.db.QueryRow(ctx, query, id, slug, gameInvite.GameID, 0)
+	row := r.db.QueryRow(ctx, query, id, slug, gameInvite.GameID, 0, gameInvite.Kind)
 
 	var gi domain.GameInvite
-	if err := row.Scan(&gi.ID, &gi.Slug, &gi.GameID, &gi.Uses); err != nil {
+	if err := row.Scan(&gi.ID, &gi.Slug, &gi.GameID, &gi.Uses, &gi.Kind); err != nil {
 		return nil, err
 	}
 
@@ -58,7 +58,7 @@ func (r *PostgresGameInviteRepository) Create(ctx context.Context, gameInvite *d
 
 func (r *PostgresGameInviteRepository) FindBySlug(ctx context.Context, slug string) (*domain.GameInvite, error) {
 	query := `
-		SELECT id, slug, game_id, uses
+		SELECT id, slug, game_id, uses, kind
 		FROM game_invite
 		WHERE slug = $1
 	`
@@ -66,7 +66,7 @@ func (r *PostgresGameInviteRepository) FindBySlug(ctx context.Context, slug stri
 	row := r.db.QueryRow(ctx, query, slug)
 
 	var gi domain.GameInvite
-	if err := row.Scan(&gi.ID, &gi.Slug, &gi.GameID, &gi.Uses); err != nil {
+	if err := row.Scan(&gi.ID, &gi.Slug, &gi.GameID, &gi.Uses, &gi.Kind); err != nil {
 		return nil, err
 	}
 
@@ -75,7 +75,7 @@ func (r *PostgresGameInviteRepository) FindBySlug(ctx context.Context, slug stri
 
 func (r *PostgresGameInviteRepository) FindByGameID(ctx context.Context, gameID string) ([]*domain.GameInvite, error) {
 	query := `
-		SELECT id, slug, game_id, uses
+		SELECT id, slug, game_id, uses, kind
 		FROM game_invite
 		WHERE game_id = $1
 	`
@@ -88,7 +88,7 @@ func (r *PostgresGameInviteRepository) FindByGameID(ctx context.Context, gameID
 	var gameInvites []*domain.GameInvite
 	for rows.Next() {
 		var gi domain.GameInvite
-		if err := rows.Scan(&gi.ID, &gi.Slug, &gi.GameID, &gi.Uses); err != nil {
+		if err := rows.Scan(&gi.ID, &gi.Slug, &gi.GameID, &gi.Uses, &gi.Kind); err != nil {
 			return nil, err
 		}
 		gameInvites = append(gameInvites, &gi)
@@ -0,0 +1,203 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/peonii/inertia/internal/domain"
+)
+
+type GameResultRepository interface {
+	FindByGameID(ctx context.Context, gameID string) ([]*domain.GameResult, error)
+
+	// Ranks the game's teams, stores the results and updates
+	// every member's stats. Calling it again for a game that
+	// has already been settled returns the stored results.
+	Settle(ctx context.Context, game *domain.Game) ([]*domain.GameResult, error)
+}
+
+type PostgresGameResultRepository struct {
+	db *pgxpool.Pool
+}
+
+func MakePostgresGameResultRepository(db *pgxpool.Pool) *PostgresGameResultRepository {
+	return &PostgresGameResultRepository{
+		db: db,
+	}
+}
+
+func (r *PostgresGameResultRepository) FindByGameID(ctx context.Context, gameID string) ([]*domain.GameResult, error) {
+	return r.findByGameID(ctx, r.db, gameID)
+}
+
+func (r *PostgresGameResultRepository) findByGameID(ctx context.Context, q pgxQuerier, gameID string) ([]*domain.GameResult, error) {
+	query := `
+		SELECT
+			id, game_id, team_id, place, score, outcome, ranking, created_at
+		FROM game_results
+		WHERE game_id = $1
+		ORDER BY place ASC
+	`
+
+	rows, err := q.Query(ctx, query, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*domain.GameResult{}
+	for rows.Next() {
+		var res domain.GameResult
+		if err := rows.Scan(
+			&res.ID,
+			&res.GameID,
+			&res.TeamID,
+			&res.Place,
+			&res.Score,
+			&res.Outcome,
+			&res.Ranking,
+			&res.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		results = append(results, &res)
+	}
+
+	return results, nil
+}
+
+func (r *PostgresGameResultRepository) Settle(ctx context.Context, game *domain.Game) ([]*domain.GameResult, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the game so two settlements can't race each other
+	var settled bool
+	if err := tx.QueryRow(ctx, `SELECT settled_at IS NOT NULL FROM games WHERE id = $1 FOR UPDATE`, game.ID).Scan(&settled); err != nil {
+		return nil, err
+	}
+
+	if settled {
+		return r.findByGameID(ctx, tx, game.ID)
+	}
+
+	scores, err := r.scoreTeams(ctx, tx, game)
+	if err != nil {
+		return nil, err
+	}
+
+	domain.RankGameResults(scores)
+
+	node, err := snowflake.NewNode(domain.GameResultSnowflakeNode)
+	if err != nil {
+		return nil, err
+	}
+
+	insertQuery := `
+		INSERT INTO game_results (id, game_id, team_id, place, score, outcome, ranking)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	statsQuery := `
+		UPDATE user_stats
+		SET wins = wins + $2, losses = losses + $3, draws = draws + $4
+		WHERE user_id IN (SELECT user_id FROM teams_users WHERE team_id = $1)
+	`
+
+	for _, res := range scores {
+		if _, err := tx.Exec(ctx, insertQuery,
+			node.Generate().String(),
+			game.ID,
+			res.TeamID,
+			res.Place,
+			res.Score,
+			res.Outcome,
+			game.Ranking,
+		); err != nil {
+			return nil, err
+		}
+
+		wins, losses, draws := 0, 0, 0
+		switch res.Outcome {
+		case domain.GameOutcomeWin:
+			wins = 1
+		case domain.GameOutcomeLoss:
+			losses = 1
+		case domain.GameOutcomeDraw:
+			draws = 1
+		}
+
+		if _, err := tx.Exec(ctx, statsQuery, res.TeamID, wins, losses, draws); err != nil {
+			return nil, err
+		}
+	}
+
+	// Games without teams are settled too, with no results
+	if _, err := tx.Exec(ctx, `UPDATE games SET settled_at = now() WHERE id = $1`, game.ID); err != nil {
+		return nil, err
+	}
+
+	results, err := r.findByGameID(ctx, tx, game.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *PostgresGameResultRepository) scoreTeams(ctx context.Context, q pgxQuerier, game *domain.Game) ([]*domain.GameResultCreate, error) {
+	var query string
+	args := []interface{}{game.ID}
+
+	switch game.Ranking {
+	case domain.GameRankingXP:
+		query = `SELECT id, xp FROM teams WHERE game_id = $1`
+	case domain.GameRankingBalance:
+		query = `SELECT id, balance FROM teams WHERE game_id = $1`
+	case domain.GameRankingRunnerTime:
+		// Seconds spent as runner, clipped to the game's time window.
+		// Finishing the game ends the open periods, so settling
+		// again later comes out the same.
+		query = `
+			SELECT
+				t.id,
+				COALESCE(SUM(GREATEST(0, EXTRACT(EPOCH FROM (
+					LEAST(rp.ended_at, $2) - GREATEST(rp.started_at, $3)
+				)))) FILTER (WHERE rp.ended_at IS NOT NULL), 0)::bigint
+			FROM teams t
+			LEFT JOIN runner_periods rp ON rp.team_id = t.id
+			WHERE t.game_id = $1
+			GROUP BY t.id
+		`
+		args = append(args, game.TimeEnd, game.TimeStart)
+	default:
+		return nil, fmt.Errorf("unknown ranking %q", game.Ranking)
+	}
+
+	rows, err := q.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := []*domain.GameResultCreate{}
+	for rows.Next() {
+		var res domain.GameResultCreate
+		if err := rows.Scan(&res.TeamID, &res.Score); err != nil {
+			return nil, err
+		}
+
+		scores = append(scores, &res)
+	}
+
+	return scores, rows.Err()
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/peonii/inertia/internal/domain"
+)
+
+type GameRoleRepository interface {
+	FindOne(ctx context.Context, gameID string, userID string) (*domain.GameRole, error)
+	FindByGameID(ctx context.Context, gameID string) ([]*domain.GameRole, error)
+
+	// Replaces whatever role the user had
+	Grant(ctx context.Context, role *domain.GameRole) (*domain.GameRole, error)
+	Revoke(ctx context.Context, gameID string, userID string) error
+}
+
+type PostgresGameRoleRepository struct {
+	GameRoleRepository
+	db *pgxpool.Pool
+}
+
+func MakePostgresGameRoleRepository(db *pgxpool.Pool) *PostgresGameRoleRepository {
+	return &PostgresGameRoleRepository{
+		db: db,
+	}
+}
+
+const gameRoleColumns = `
+	game_id, user_id, role, created_at
+`
+
+func scanGameRole(row pgx.Row) (*domain.GameRole, error) {
+	var role domain.GameRole
+	if err := row.Scan(
+		&role.GameID,
+		&role.UserID,
+		&role.Role,
+		&role.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+func (r *PostgresGameRoleRepository) FindOne(ctx context.Context, gameID string, userID string) (*domain.GameRole, error) {
+	query := `
+		SELECT ` + gameRoleColumns + `
+		FROM game_roles
+		WHERE game_id = $1 AND user_id = $2
+	`
+
+	return scanGameRole(r.db.QueryRow(ctx, query, gameID, userID))
+}
+
+func (r *PostgresGameRoleRepository) FindByGameID(ctx context.Context, gameID string) ([]*domain.GameRole, error) {
+	query := `
+		SELECT ` + gameRoleColumns + `
+		FROM game_roles
+		WHERE game_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(ctx, query, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*domain.GameRole{}
+	for rows.Next() {
+		role, err := scanGameRole(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+func (r *PostgresGameRoleRepository) Grant(ctx context.Context, role *domain.GameRole) (*domain.GameRole, error) {
+	query := `
+		INSERT INTO game_roles (game_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (game_id, user_id) DO UPDATE SET role = excluded.role
+		RETURNING ` + gameRoleColumns
+
+	return scanGameRole(r.db.QueryRow(ctx, query, role.GameID, role.UserID, role.Role))
+}
+
+func (r *PostgresGameRoleRepository) Revoke(ctx context.Context, gameID string, userID string) error {
+	query := `
+		DELETE FROM game_roles
+		WHERE game_id = $1 AND user_id = $2
+	`
+
+	_, err := r.db.Exec(ctx, query, gameID, userID)
+	return err
+}
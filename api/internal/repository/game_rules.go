@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/peonii/inertia/internal/domain"
+)
+
+type GameRulesRepository interface {
+	// Returns the default rules if the game doesn't have any
+	FindByGameID(ctx context.Context, gameID string) (*domain.GameRules, error)
+	Upsert(ctx context.Context, rules *domain.GameRules) (*domain.GameRules, error)
+}
+
+type PostgresGameRulesRepository struct {
+	db *pgxpool.Pool
+}
+
+func MakePostgresGameRulesRepository(db *pgxpool.Pool) *PostgresGameRulesRepository {
+	return &PostgresGameRulesRepository{
+		db: db,
+	}
+}
+
+func (r *PostgresGameRulesRepository) FindByGameID(ctx context.Context, gameID string) (*domain.GameRules, error) {
+	query := `
+		SELECT
+			game_id, starting_balance, veto_duration, catch_distance, catch_window, spectator_delay, out_of_bounds_penalty, out_of_bounds_fine, reveal_interval, tickets, powerups
+		FROM game_rules
+		WHERE game_id = $1
+	`
+
+	var rules domain.GameRules
+	err := r.db.QueryRow(ctx, query, gameID).Scan(
+		&rules.GameID,
+		&rules.StartingBalance,
+		&rules.VetoDuration,
+		&rules.CatchDistance,
+		&rules.CatchWindow,
+		&rules.SpectatorDelay,
+		&rules.OutOfBoundsPenalty,
+		&rules.OutOfBoundsFine,
+		&rules.RevealInterval,
+		&rules.Tickets,
+		&rules.Powerups,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.DefaultGameRules(gameID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &rules, nil
+}
+
+func (r *PostgresGameRulesRepository) Upsert(ctx context.Context, rules *domain.GameRules) (*domain.GameRules, error) {
+	query := `
+		INSERT INTO game_rules (game_id, starting_balance, veto_duration, catch_distance, catch_window, spectator_delay, out_of_bounds_penalty, out_of_bounds_fine, reveal_interval, tickets, powerups)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (game_id) DO UPDATE SET
+			starting_balance = excluded.starting_balance,
+			veto_duration = excluded.veto_duration,
+			catch_distance = excluded.catch_distance,
+			catch_window = excluded.catch_window,
+			spectator_delay = excluded.spectator_delay,
+			out_of_bounds_penalty = excluded.out_of_bounds_penalty,
+			out_of_bounds_fine = excluded.out_of_bounds_fine,
+			reveal_interval = excluded.reveal_interval,
+			tickets = excluded.tickets,
+			powerups = excluded.powerups
+		RETURNING game_id, starting_balance, veto_duration, catch_distance, catch_window, spectator_delay, out_of_bounds_penalty, out_of_bounds_fine, reveal_interval, tickets, powerups
+	`
+
+	var updated domain.GameRules
+	if err := r.db.QueryRow(ctx, query,
+		rules.GameID,
+		rules.StartingBalance,
+		rules.VetoDuration,
+		rules.CatchDistance,
+		rules.CatchWindow,
+		rules.SpectatorDelay,
+		rules.OutOfBoundsPenalty,
+		rules.OutOfBoundsFine,
+		rules.RevealInterval,
+		rules.Tickets,
+		rules.Powerups,
+	).Scan(
+		&updated.GameID,
+		&updated.StartingBalance,
+		&updated.VetoDuration,
+		&updated.CatchDistance,
+		&updated.CatchWindow,
+		&updated.SpectatorDelay,
+		&updated.OutOfBoundsPenalty,
+		&updated.OutOfBoundsFine,
+		&updated.RevealInterval,
+		&updated.Tickets,
+		&updated.Powerups,
+	); err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
@@ -2,6 +2,8 @@ package repository
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/bwmarrin/snowflake"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -13,30 +15,44 @@ import (
 type LocationRepository interface {
 	Store(ctx context.Context, location *domain.LocationCreate) error
 	GetUserLatest(ctx context.Context, userID string) (*domain.Location, error)
+	// Drops the latest location of a user that's gone,
+	// the history goes with the user
+	Forget(ctx context.Context, userID string) error
+	// Stored locations of the users between from and to,
+	// grouped by user and oldest first
+	FindHistory(ctx context.Context, userIDs []string, from, to time.Time) ([]*domain.Location, error)
 }
 
 type PostgresLocationRepository struct {
 	LocationRepository
 
-	db  *pgxpool.Pool
-	rdc *redis.Client
+	db     *pgxpool.Pool
+	rdc    *redis.Client
+	writer *LocationWriter
+	// Locations come in fast, a node per location
+	// would hand out the same IDs
+	node *snowflake.Node
 }
 
-func MakePostgresLocationRepository(db *pgxpool.Pool, rdc *redis.Client) *PostgresLocationRepository {
+// The writer keeps the history, without one only
+// the latest location of each user is kept
+func MakePostgresLocationRepository(db *pgxpool.Pool, rdc *redis.Client, writer *LocationWriter) *PostgresLocationRepository {
+	node, err := snowflake.NewNode(domain.LocationSnowflakeNode)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create location snowflake node: %v", err))
+	}
+
 	return &PostgresLocationRepository{
-		db:  db,
-		rdc: rdc,
+		db:     db,
+		rdc:    rdc,
+		writer: writer,
+		node:   node,
 	}
 }
 
 func (r *PostgresLocationRepository) Store(ctx context.Context, location *domain.LocationCreate) error {
-	node, err := snowflake.NewNode(domain.LocationSnowflakeNode)
-	if err != nil {
-		return err
-	}
-
 	nloc := &domain.Location{
-		ID:        node.Generate().String(),
+		ID:        r.node.Generate().String(),
 		Lat:       location.Lat,
 		Lng:       location.Lng,
 		Alt:       location.Alt,
@@ -44,40 +60,26 @@ func (r *PostgresLocationRepository) Store(ctx context.Context, location *domain
 		Heading:   location.Heading,
 		Speed:     location.Speed,
 		UserID:    location.UserID,
+
+		RecordedAt: location.RecordedAt,
+		CreatedAt:  time.Now(),
 	}
 
 	key := "loc." + location.UserID
 	enc, err := msgpack.Marshal(nloc)
 	if err != nil {
-		return nil
+		return err
 	}
 
 	if err := r.rdc.Set(ctx, key, enc, 0).Err(); err != nil {
 		return err
 	}
 
-	// Also save the location in Postgres
-	go func() {
-		query := `
-			INSERT INTO locations (id, lat, lng, alt, precision, heading, speed, user_id)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		`
-
-		_, err := r.db.Exec(ctx, query,
-			nloc.ID,
-			location.Lat,
-			location.Lng,
-			location.Alt,
-			location.Precision,
-			location.Heading,
-			location.Speed,
-			location.UserID,
-		)
-		if err != nil {
-			// We can fail silently here, since all this does is impact the
-			// location history of the user.
-		}
-	}()
+	// A point missing from the history isn't worth failing
+	// the update over, the writer counts what it drops
+	if r.writer != nil {
+		_ = r.writer.Write(ctx, nloc)
+	}
 
 	return nil
 }
@@ -97,3 +99,46 @@ func (r *PostgresLocationRepository) GetUserLatest(ctx context.Context, userID s
 
 	return &location, nil
 }
+
+func (r *PostgresLocationRepository) Forget(ctx context.Context, userID string) error {
+	return r.rdc.Del(ctx, "loc."+userID).Err()
+}
+
+func (r *PostgresLocationRepository) FindHistory(ctx context.Context, userIDs []string, from, to time.Time) ([]*domain.Location, error) {
+	query := `
+		SELECT
+			id, lat, lng, alt, precision, heading, speed, user_id, created_at
+		FROM locations
+		WHERE user_id = ANY($1) AND created_at BETWEEN $2 AND $3
+		ORDER BY user_id, created_at
+	`
+
+	rows, err := r.db.Query(ctx, query, userIDs, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locations := []*domain.Location{}
+	for rows.Next() {
+		var location domain.Location
+		err := rows.Scan(
+			&location.ID,
+			&location.Lat,
+			&location.Lng,
+			&location.Alt,
+			&location.Precision,
+			&location.Heading,
+			&location.Speed,
+			&location.UserID,
+			&location.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		locations = append(locations, &location)
+	}
+
+	return locations, rows.Err()
+}
@@ -0,0 +1,252 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/peonii/inertia/internal/domain"
+)
+
+var ErrLocationDropped = errors.New("location was dropped from history")
+
+const foreignKeyViolation = "23503"
+
+// Served at /debug/vars when METRICS_ADDR is set
+var locationMetrics = expvar.NewMap("locations")
+
+type LocationWriterConfig struct {
+	// Locations waiting to be written
+	QueueSize int
+	// Locations written in a single COPY
+	BatchSize int
+	// How long a location can wait for its batch to fill up
+	FlushInterval time.Duration
+	// How long Write waits for room in the queue before
+	// giving up on the location
+	WriteTimeout time.Duration
+	// Attempts at writing a batch before it's dropped
+	Retries int
+}
+
+var DefaultLocationWriterConfig = LocationWriterConfig{
+	QueueSize:     10000,
+	BatchSize:     1000,
+	FlushInterval: time.Second,
+	WriteTimeout:  time.Millisecond * 100,
+	Retries:       3,
+}
+
+// Writes locations to Postgres in batches, in the background.
+// When the database can't keep up, writers are slowed down for
+// a bit and then locations are dropped, which shows up in the
+// "locations" metrics.
+type LocationWriter struct {
+	db     *pgxpool.Pool
+	config LocationWriterConfig
+
+	queue chan *domain.Location
+	stop  chan struct{}
+	done  chan struct{}
+	// Set if a batch couldn't be written
+	OnError func(err error, dropped int)
+}
+
+func MakeLocationWriter(db *pgxpool.Pool, config LocationWriterConfig) *LocationWriter {
+	return &LocationWriter{
+		db:     db,
+		config: config,
+		queue:  make(chan *domain.Location, config.QueueSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Queues the location, waiting for room in the queue
+// for at most the write timeout
+func (w *LocationWriter) Write(ctx context.Context, location *domain.Location) error {
+	select {
+	case <-w.stop:
+		locationMetrics.Add("dropped", 1)
+		return ErrLocationDropped
+	default:
+	}
+
+	select {
+	case w.queue <- location:
+		locationMetrics.Add("queued", 1)
+		locationMetrics.Add("pending", 1)
+		return nil
+	default:
+	}
+
+	// Full, hold the writer back a bit
+	locationMetrics.Add("throttled", 1)
+
+	timer := time.NewTimer(w.config.WriteTimeout)
+	defer timer.Stop()
+
+	select {
+	case w.queue <- location:
+		locationMetrics.Add("queued", 1)
+		locationMetrics.Add("pending", 1)
+		return nil
+	case <-timer.C:
+	case <-ctx.Done():
+	case <-w.stop:
+	}
+
+	locationMetrics.Add("dropped", 1)
+	return ErrLocationDropped
+}
+
+func (w *LocationWriter) Run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*domain.Location, 0, w.config.BatchSize)
+	for {
+		select {
+		case location := <-w.queue:
+			batch = append(batch, location)
+			if len(batch) >= w.config.BatchSize {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-w.stop:
+			// Whatever made it into the queue still gets written
+			for {
+				select {
+				case location := <-w.queue:
+					batch = append(batch, location)
+					if len(batch) >= w.config.BatchSize {
+						w.flush(batch)
+						batch = batch[:0]
+					}
+				default:
+					if len(batch) > 0 {
+						w.flush(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// Stops taking locations and waits for the queued ones to be written
+func (w *LocationWriter) Close(ctx context.Context) error {
+	close(w.stop)
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *LocationWriter) flush(batch []*domain.Location) {
+	locationMetrics.Add("pending", -int64(len(batch)))
+
+	var err error
+	for attempt := 0; attempt < w.config.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Millisecond * 200)
+		}
+
+		if err = w.copy(batch); err == nil {
+			locationMetrics.Add("written", int64(len(batch)))
+			locationMetrics.Add("batches", 1)
+			return
+		}
+
+		// A guest merged into someone else is deleted, possibly
+		// with locations still queued. Those go instead of the batch.
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+			kept, ferr := w.withoutDeletedUsers(batch)
+			if ferr != nil {
+				continue
+			}
+
+			locationMetrics.Add("dropped", int64(len(batch)-len(kept)))
+			batch = kept
+
+			if len(batch) == 0 {
+				return
+			}
+		}
+	}
+
+	locationMetrics.Add("failed", int64(len(batch)))
+	if w.OnError != nil {
+		w.OnError(err, len(batch))
+	}
+}
+
+func (w *LocationWriter) withoutDeletedUsers(batch []*domain.Location) ([]*domain.Location, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	userIDs := make([]string, 0, len(batch))
+	for _, l := range batch {
+		userIDs = append(userIDs, l.UserID)
+	}
+
+	rows, err := w.db.Query(ctx, `SELECT id FROM users WHERE id = ANY($1)`, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		existing[id] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	kept := make([]*domain.Location, 0, len(batch))
+	for _, l := range batch {
+		if existing[l.UserID] {
+			kept = append(kept, l)
+		}
+	}
+
+	return kept, nil
+}
+
+func (w *LocationWriter) copy(batch []*domain.Location) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	_, err := w.db.CopyFrom(ctx,
+		pgx.Identifier{"locations"},
+		[]string{"id", "lat", "lng", "alt", "precision", "heading", "speed", "user_id", "created_at"},
+		pgx.CopyFromSlice(len(batch), func(i int) ([]any, error) {
+			l := batch[i]
+			return []any{l.ID, l.Lat, l.Lng, l.Alt, l.Precision, l.Heading, l.Speed, l.UserID, l.CreatedAt}, nil
+		}),
+	)
+
+	return err
+}
@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/peonii/inertia/internal/domain"
+)
+
+type OAuthClientRepository interface {
+	FindOne(ctx context.Context, id string) (*domain.OAuthClient, error)
+	FindAll(ctx context.Context) ([]*domain.OAuthClient, error)
+
+	Create(ctx context.Context, client *domain.OAuthClientCreate) (*domain.OAuthClient, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type PostgresOAuthClientRepository struct {
+	db *pgxpool.Pool
+}
+
+func MakePostgresOAuthClientRepository(db *pgxpool.Pool) *PostgresOAuthClientRepository {
+	return &PostgresOAuthClientRepository{
+		db: db,
+	}
+}
+
+const oauthClientColumns = `
+	id, name, redirect_uris, scopes, secret_hash, created_at
+`
+
+func scanOAuthClient(row pgx.Row) (*domain.OAuthClient, error) {
+	var c domain.OAuthClient
+	if err := row.Scan(
+		&c.ID,
+		&c.Name,
+		&c.RedirectURIs,
+		&c.Scopes,
+		&c.SecretHash,
+		&c.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (r *PostgresOAuthClientRepository) FindOne(ctx context.Context, id string) (*domain.OAuthClient, error) {
+	query := `
+		SELECT ` + oauthClientColumns + ` FROM oauth_clients
+		WHERE id = $1
+	`
+
+	return scanOAuthClient(r.db.QueryRow(ctx, query, id))
+}
+
+func (r *PostgresOAuthClientRepository) FindAll(ctx context.Context) ([]*domain.OAuthClient, error) {
+	query := `
+		SELECT ` + oauthClientColumns + ` FROM oauth_clients
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*domain.OAuthClient{}
+	for rows.Next() {
+		c, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, c)
+	}
+
+	return clients, rows.Err()
+}
+
+func (r *PostgresOAuthClientRepository) Create(ctx context.Context, client *domain.OAuthClientCreate) (*domain.OAuthClient, error) {
+	query := `
+		INSERT INTO oauth_clients (id, name, redirect_uris, scopes, secret_hash)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + oauthClientColumns
+
+	return scanOAuthClient(r.db.QueryRow(ctx, query,
+		client.ID,
+		client.Name,
+		client.RedirectURIs,
+		client.Scopes,
+		client.SecretHash,
+	))
+}
+
+func (r *PostgresOAuthClientRepository) Delete(ctx context.Context, id string) error {
+	query := `
+		DELETE FROM oauth_clients
+		WHERE id = $1
+	`
+
+	_, err := r.db.Exec(ctx, query, id)
+	return err
+}
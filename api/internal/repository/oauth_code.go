@@ -13,9 +13,20 @@ import (
 )
 
 type OAuthCodeRepository interface {
-	CreateOAuthCode(ctx context.Context, userID string) (*domain.OAuthCode, error)
+	CreateOAuthCode(ctx context.Context, userID string, req *domain.OAuthRequest) (*domain.OAuthCode, error)
 	FindOAuthCodeByToken(ctx context.Context, token string) (*domain.OAuthCode, error)
+	// Finds and deletes the code, so it can only be used once
+	ConsumeOAuthCode(ctx context.Context, token string) (*domain.OAuthCode, error)
 	DeleteOAuthCodeByToken(ctx context.Context, token string) error
+
+	// Sets req.Key, which is the state sent to the provider
+	CreateOAuthRequest(ctx context.Context, req *domain.OAuthRequest) error
+	ConsumeOAuthRequest(ctx context.Context, key string) (*domain.OAuthRequest, error)
+
+	// Lets a guest's next sign in keep their progress,
+	// consuming it gives back the guest's ID
+	CreateLinkCode(ctx context.Context, userID string) (string, error)
+	ConsumeLinkCode(ctx context.Context, code string) (string, error)
 }
 
 type RedisOAuthCodeRepository struct {
@@ -29,7 +40,7 @@ func MakeRedisOAuthCodeRepository(db *redis.Client) *RedisOAuthCodeRepository {
 	}
 }
 
-func (r *RedisOAuthCodeRepository) CreateOAuthCode(ctx context.Context, userID string) (*domain.OAuthCode, error) {
+func (r *RedisOAuthCodeRepository) CreateOAuthCode(ctx context.Context, userID string, req *domain.OAuthRequest) (*domain.OAuthCode, error) {
 	tok := make([]byte, domain.OAuthCodeLength)
 	if _, err := rand.Read(tok); err != nil {
 		return nil, err
@@ -41,6 +52,11 @@ func (r *RedisOAuthCodeRepository) CreateOAuthCode(ctx context.Context, userID s
 		UserID: userID,
 		Code:   token,
 
+		ClientID:      req.ClientID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        req.Scopes,
+		CodeChallenge: req.CodeChallenge,
+
 		CreatedAt: time.Now(),
 		ExpiresAt: time.Now().Add(domain.OAuthCodeExpiryTime),
 	}
@@ -71,6 +87,76 @@ func (r *RedisOAuthCodeRepository) FindOAuthCodeByToken(ctx context.Context, tok
 	return &s, nil
 }
 
+func (r *RedisOAuthCodeRepository) ConsumeOAuthCode(ctx context.Context, token string) (*domain.OAuthCode, error) {
+	data, err := r.db.GetDel(ctx, token).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var s domain.OAuthCode
+	if err := msgpack.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
 func (r *RedisOAuthCodeRepository) DeleteOAuthCodeByToken(ctx context.Context, token string) error {
 	return r.db.Del(ctx, token).Err()
 }
+
+func (r *RedisOAuthCodeRepository) CreateOAuthRequest(ctx context.Context, req *domain.OAuthRequest) error {
+	// Second half is the nonce for OIDC providers
+	tok := make([]byte, 32)
+	if _, err := rand.Read(tok); err != nil {
+		return err
+	}
+
+	req.Key = base64.URLEncoding.EncodeToString(tok[:16])
+	req.Nonce = base64.RawURLEncoding.EncodeToString(tok[16:])
+
+	data, err := msgpack.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	return r.db.Set(ctx, "r."+req.Key, data, domain.OAuthCodeExpiryTime).Err()
+}
+
+func (r *RedisOAuthCodeRepository) ConsumeOAuthRequest(ctx context.Context, key string) (*domain.OAuthRequest, error) {
+	data, err := r.db.GetDel(ctx, "r."+key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var req domain.OAuthRequest
+	if err := msgpack.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+func (r *RedisOAuthCodeRepository) CreateLinkCode(ctx context.Context, userID string) (string, error) {
+	tok := make([]byte, domain.OAuthCodeLength)
+	if _, err := rand.Read(tok); err != nil {
+		return "", err
+	}
+
+	code := fmt.Sprintf("l.%s", base64.URLEncoding.EncodeToString(tok))
+
+	if err := r.db.Set(ctx, code, userID, domain.OAuthCodeExpiryTime).Err(); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+func (r *RedisOAuthCodeRepository) ConsumeLinkCode(ctx context.Context, code string) (string, error) {
+	// Only ever the ones made above
+	if len(code) < 2 || code[:2] != "l." {
+		return "", redis.Nil
+	}
+
+	return r.db.GetDel(ctx, code).Result()
+}
@@ -13,7 +13,9 @@ type PowerupRepository interface {
 	GetByID(ctx context.Context, id string) (*domain.Powerup, error)
 	GetByGameID(ctx context.Context, gameID string) ([]*domain.Powerup, error)
 	GetActiveByGameID(ctx context.Context, gameID string) ([]*domain.Powerup, error)
-	Create(ctx context.Context, powerup *domain.PowerupCreate) (*domain.Powerup, error)
+	// Charges the team in the same transaction, a team that can't
+	// afford it gets ErrInsufficientBalance and no powerup
+	Create(ctx context.Context, powerup *domain.PowerupCreate, charge *domain.TeamTransactionCreate) (*domain.Powerup, error)
 }
 
 type PostgresPowerupRepository struct {
@@ -29,7 +31,7 @@ func MakePostgresPowerupRepository(db *pgxpool.Pool) *PostgresPowerupRepository
 func (r *PostgresPowerupRepository) GetByID(ctx context.Context, id string) (*domain.Powerup, error) {
 	query := `
 		SELECT
-			id, type, caster_id, ends_at, created_at
+			id, type, caster_id, ends_at, target, created_at
 		FROM powerups
 		WHERE id = $1
 	`
@@ -40,6 +42,7 @@ func (r *PostgresPowerupRepository) GetByID(ctx context.Context, id string) (*do
 		&powerup.Type,
 		&powerup.CasterID,
 		&powerup.EndsAt,
+		&powerup.Target,
 		&powerup.CreatedAt,
 	)
 	if err != nil {
@@ -52,7 +55,7 @@ func (r *PostgresPowerupRepository) GetByID(ctx context.Context, id string) (*do
 func (r *PostgresPowerupRepository) GetByGameID(ctx context.Context, gameID string) ([]*domain.Powerup, error) {
 	query := `
 		SELECT
-			powerups.id, powerups.type, powerups.caster_id, powerups.ends_at, powerups.created_at
+			powerups.id, powerups.type, powerups.caster_id, powerups.ends_at, powerups.target, powerups.created_at
 		FROM powerups
 		JOIN teams ON powerups.caster_id = teams.id
 		WHERE teams.game_id = $1
@@ -72,6 +75,7 @@ func (r *PostgresPowerupRepository) GetByGameID(ctx context.Context, gameID stri
 			&powerup.Type,
 			&powerup.CasterID,
 			&powerup.EndsAt,
+			&powerup.Target,
 			&powerup.CreatedAt,
 		); err != nil {
 			return nil, err
@@ -86,7 +90,7 @@ func (r *PostgresPowerupRepository) GetByGameID(ctx context.Context, gameID stri
 func (r *PostgresPowerupRepository) GetActiveByGameID(ctx context.Context, gameID string) ([]*domain.Powerup, error) {
 	query := `
 		SELECT
-			powerups.id, powerups.type, powerups.caster_id, powerups.ends_at, powerups.created_at
+			powerups.id, powerups.type, powerups.caster_id, powerups.ends_at, powerups.target, powerups.created_at
 		FROM powerups
 		JOIN teams ON powerups.caster_id = teams.id
 		WHERE teams.game_id = $1
@@ -107,6 +111,7 @@ func (r *PostgresPowerupRepository) GetActiveByGameID(ctx context.Context, gameI
 			&powerup.Type,
 			&powerup.CasterID,
 			&powerup.EndsAt,
+			&powerup.Target,
 			&powerup.CreatedAt,
 		); err != nil {
 			return nil, err
@@ -118,47 +123,50 @@ func (r *PostgresPowerupRepository) GetActiveByGameID(ctx context.Context, gameI
 	return powerups, nil
 }
 
-func (r *PostgresPowerupRepository) Create(ctx context.Context, powerup *domain.PowerupCreate) (*domain.Powerup, error) {
+func (r *PostgresPowerupRepository) Create(ctx context.Context, powerup *domain.PowerupCreate, charge *domain.TeamTransactionCreate) (*domain.Powerup, error) {
 	node, err := snowflake.NewNode(domain.PowerupSnowflakeNode)
 	if err != nil {
 		return nil, err
 	}
 
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if charge != nil {
+		if _, err := applyTransaction(ctx, tx, charge); err != nil {
+			return nil, err
+		}
+	}
+
 	id := node.Generate().String()
 	query := `
-		INSERT INTO powerups (id, type, caster_id, ends_at)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, type, caster_id, ends_at, created_at
+		INSERT INTO powerups (id, type, caster_id, ends_at, target)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, type, caster_id, ends_at, target, created_at
 	`
 
-	endsAt := time.Now()
-	switch powerup.Type {
-	case domain.PowerupTypeHunt:
-		endsAt = endsAt.Add(20 * time.Minute)
-	case domain.PowerupTypeBlacklist:
-		endsAt = endsAt.Add(10 * time.Minute)
-	case domain.PowerupTypeFreezeHunters:
-		endsAt = endsAt.Add(10 * time.Minute)
-	case domain.PowerupTypeFreezeRunners:
-		endsAt = endsAt.Add(5 * time.Minute)
-	case domain.PowerupTypeRevealHunters:
-		endsAt = endsAt.Add(20 * time.Minute)
-	case domain.PowerupTypeHideTracker:
-		endsAt = endsAt.Add(10 * time.Minute)
-	}
+	endsAt := time.Now().Add(powerup.Duration)
 
 	var pow domain.Powerup
 
-	row := r.db.QueryRow(ctx, query, id, powerup.Type, powerup.CasterID, endsAt)
+	row := tx.QueryRow(ctx, query, id, powerup.Type, powerup.CasterID, endsAt, powerup.Target)
 	if err := row.Scan(
 		&pow.ID,
 		&pow.Type,
 		&pow.CasterID,
 		&pow.EndsAt,
+		&pow.Target,
 		&pow.CreatedAt,
 	); err != nil {
 		return nil, err
 	}
 
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
 	return &pow, nil
 }
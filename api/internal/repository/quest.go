@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"time"
@@ -11,6 +12,8 @@ import (
 	"github.com/peonii/inertia/internal/domain"
 )
 
+var ErrQuestNotActive = errors.New("quest is not active")
+
 type QuestRepository interface {
 	FindByGameID(ctx context.Context, gameID string) ([]*domain.Quest, error)
 	FindOne(ctx context.Context, id string) (*domain.Quest, error)
@@ -29,7 +32,9 @@ type QuestRepository interface {
 	TeamHasActiveSide(ctx context.Context, teamID string) (bool, error)
 	CreateActive(ctx context.Context, quest *domain.ActiveQuestCreate) (*domain.ActiveQuest, error)
 	CreateManyActive(ctx context.Context, quests []*domain.ActiveQuestCreate) ([]*domain.ActiveQuest, error)
-	Complete(ctx context.Context, id string) error
+	// Pays the reward out in the same transaction, nil
+	// for quests that don't pay, like vetoed ones
+	Complete(ctx context.Context, id string, reward *domain.TeamTransactionCreate) error
 	DeleteActive(ctx context.Context, id string) error
 	PurgeAllActive(ctx context.Context, gameID string) error
 
@@ -49,7 +54,7 @@ func MakePostgresQuestRepository(db *pgxpool.Pool) *PostgresQuestRepository {
 }
 
 func (r *PostgresQuestRepository) FindByGameID(ctx context.Context, gameID string) ([]*domain.Quest, error) {
-	query := `SELECT id, title, description, money, xp, quest_type, group_id, lat, lng, game_id, created_at FROM quests WHERE game_id = $1`
+	query := `SELECT id, title, description, money, xp, quest_type, group_id, lat, lng, radius, honour_based, requires_evidence, game_id, created_at FROM quests WHERE game_id = $1`
 	rows, err := r.db.Query(ctx, query, gameID)
 	if err != nil {
 		return nil, err
@@ -58,7 +63,7 @@ func (r *PostgresQuestRepository) FindByGameID(ctx context.Context, gameID strin
 	quests := []*domain.Quest{}
 	for rows.Next() {
 		quest := &domain.Quest{}
-		err = rows.Scan(&quest.ID, &quest.Title, &quest.Description, &quest.Money, &quest.XP, &quest.QuestType, &quest.GroupID, &quest.Lat, &quest.Lng, &quest.GameID, &quest.CreatedAt)
+		err = rows.Scan(&quest.ID, &quest.Title, &quest.Description, &quest.Money, &quest.XP, &quest.QuestType, &quest.GroupID, &quest.Lat, &quest.Lng, &quest.Radius, &quest.HonourBased, &quest.RequiresEvidence, &quest.GameID, &quest.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -69,9 +74,9 @@ func (r *PostgresQuestRepository) FindByGameID(ctx context.Context, gameID strin
 }
 
 func (r *PostgresQuestRepository) FindOne(ctx context.Context, id string) (*domain.Quest, error) {
-	query := `SELECT id, title, description, money, xp, quest_type, group_id, lat, lng, game_id, created_at FROM quests WHERE id = $1`
+	query := `SELECT id, title, description, money, xp, quest_type, group_id, lat, lng, radius, honour_based, requires_evidence, game_id, created_at FROM quests WHERE id = $1`
 	quest := &domain.Quest{}
-	err := r.db.QueryRow(ctx, query, id).Scan(&quest.ID, &quest.Title, &quest.Description, &quest.Money, &quest.XP, &quest.QuestType, &quest.GroupID, &quest.Lat, &quest.Lng, &quest.GameID, &quest.CreatedAt)
+	err := r.db.QueryRow(ctx, query, id).Scan(&quest.ID, &quest.Title, &quest.Description, &quest.Money, &quest.XP, &quest.QuestType, &quest.GroupID, &quest.Lat, &quest.Lng, &quest.Radius, &quest.HonourBased, &quest.RequiresEvidence, &quest.GameID, &quest.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -80,7 +85,7 @@ func (r *PostgresQuestRepository) FindOne(ctx context.Context, id string) (*doma
 }
 
 func (r *PostgresQuestRepository) Create(ctx context.Context, quest *domain.QuestCreate) (*domain.Quest, error) {
-	query := `INSERT INTO quests (id, title, description, money, xp, quest_type, group_id, lat, lng, game_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING created_at`
+	query := `INSERT INTO quests (id, title, description, money, xp, quest_type, group_id, lat, lng, radius, honour_based, requires_evidence, game_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING created_at`
 	node, err := snowflake.NewNode(domain.QuestSnowflakeNode)
 	if err != nil {
 		return nil, err
@@ -88,7 +93,7 @@ func (r *PostgresQuestRepository) Create(ctx context.Context, quest *domain.Ques
 
 	questID := node.Generate().String()
 	createdAt := time.Time{}
-	err = r.db.QueryRow(ctx, query, questID, quest.Title, quest.Description, quest.Money, quest.XP, quest.QuestType, quest.GroupID, quest.Lat, quest.Lng, quest.GameID).Scan(&createdAt)
+	err = r.db.QueryRow(ctx, query, questID, quest.Title, quest.Description, quest.Money, quest.XP, quest.QuestType, quest.GroupID, quest.Lat, quest.Lng, quest.Radius, quest.HonourBased, quest.RequiresEvidence, quest.GameID).Scan(&createdAt)
 	if err != nil {
 		return nil, err
 	}
@@ -103,14 +108,18 @@ func (r *PostgresQuestRepository) Create(ctx context.Context, quest *domain.Ques
 		GroupID:     quest.GroupID,
 		Lat:         quest.Lat,
 		Lng:         quest.Lng,
+		Radius:      quest.Radius,
+		HonourBased: quest.HonourBased,
 		GameID:      quest.GameID,
 		CreatedAt:   createdAt,
+
+		RequiresEvidence: quest.RequiresEvidence,
 	}, nil
 }
 
 func (r *PostgresQuestRepository) Update(ctx context.Context, quest *domain.Quest) error {
-	query := `UPDATE quests SET title = $1, description = $2, money = $3, xp = $4, quest_type = $5, group_id = $6, lat = $7, lng = $8, game_id = $9 WHERE id = $10`
-	_, err := r.db.Exec(ctx, query, quest.Title, quest.Description, quest.Money, quest.XP, quest.QuestType, quest.GroupID, quest.Lat, quest.Lng, quest.GameID, quest.ID)
+	query := `UPDATE quests SET title = $1, description = $2, money = $3, xp = $4, quest_type = $5, group_id = $6, lat = $7, lng = $8, radius = $9, honour_based = $10, requires_evidence = $11, game_id = $12 WHERE id = $13`
+	_, err := r.db.Exec(ctx, query, quest.Title, quest.Description, quest.Money, quest.XP, quest.QuestType, quest.GroupID, quest.Lat, quest.Lng, quest.Radius, quest.HonourBased, quest.RequiresEvidence, quest.GameID, quest.ID)
 	if err != nil {
 		return err
 	}
@@ -201,14 +210,14 @@ func (r *PostgresQuestRepository) DeleteGroup(ctx context.Context, id string) er
 
 func (r *PostgresQuestRepository) FindActive(ctx context.Context, id string) (*domain.ActiveQuestFull, error) {
 	query := `
-	SELECT q.id, q.title, q.description, q.money, q.xp, q.quest_type, q.group_id, q.lat, q.lng, q.game_id, q.created_at, aq.team_id, aq.complete, aq.created_at
+	SELECT q.id, q.title, q.description, q.money, q.xp, q.quest_type, q.group_id, q.lat, q.lng, q.radius, q.honour_based, q.requires_evidence, q.game_id, q.created_at, aq.team_id, aq.complete, aq.submitted, aq.created_at
 	FROM quests q
 	JOIN active_quests aq ON q.id = aq.quest_id
 	WHERE aq.id = $1
 	`
 
 	activeQuest := &domain.ActiveQuestFull{}
-	err := r.db.QueryRow(ctx, query, id).Scan(&activeQuest.ID, &activeQuest.Title, &activeQuest.Description, &activeQuest.Money, &activeQuest.XP, &activeQuest.QuestType, &activeQuest.GroupID, &activeQuest.Lat, &activeQuest.Lng, &activeQuest.GameID, &activeQuest.CreatedAt, &activeQuest.TeamID, &activeQuest.Complete, &activeQuest.StartedAt)
+	err := r.db.QueryRow(ctx, query, id).Scan(&activeQuest.ID, &activeQuest.Title, &activeQuest.Description, &activeQuest.Money, &activeQuest.XP, &activeQuest.QuestType, &activeQuest.GroupID, &activeQuest.Lat, &activeQuest.Lng, &activeQuest.Radius, &activeQuest.HonourBased, &activeQuest.RequiresEvidence, &activeQuest.GameID, &activeQuest.CreatedAt, &activeQuest.TeamID, &activeQuest.Complete, &activeQuest.Submitted, &activeQuest.StartedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -218,7 +227,7 @@ func (r *PostgresQuestRepository) FindActive(ctx context.Context, id string) (*d
 
 func (r *PostgresQuestRepository) FindActiveByTeamID(ctx context.Context, teamID string) ([]*domain.ActiveQuestFull, error) {
 	query := `
-	SELECT aq.id, q.id, q.title, q.description, q.money, q.xp, q.quest_type, q.group_id, q.lat, q.lng, q.game_id, q.created_at, aq.complete, aq.created_at
+	SELECT aq.id, q.id, q.title, q.description, q.money, q.xp, q.quest_type, q.group_id, q.lat, q.lng, q.radius, q.honour_based, q.requires_evidence, q.game_id, q.created_at, aq.complete, aq.submitted, aq.created_at
 	FROM quests q
 	JOIN active_quests aq ON q.id = aq.quest_id
 	WHERE aq.team_id = $1
@@ -232,7 +241,7 @@ func (r *PostgresQuestRepository) FindActiveByTeamID(ctx context.Context, teamID
 	activeQuests := []*domain.ActiveQuestFull{}
 	for rows.Next() {
 		activeQuest := &domain.ActiveQuestFull{}
-		err = rows.Scan(&activeQuest.ID, &activeQuest.QuestID, &activeQuest.Title, &activeQuest.Description, &activeQuest.Money, &activeQuest.XP, &activeQuest.QuestType, &activeQuest.GroupID, &activeQuest.Lat, &activeQuest.Lng, &activeQuest.GameID, &activeQuest.CreatedAt, &activeQuest.Complete, &activeQuest.StartedAt)
+		err = rows.Scan(&activeQuest.ID, &activeQuest.QuestID, &activeQuest.Title, &activeQuest.Description, &activeQuest.Money, &activeQuest.XP, &activeQuest.QuestType, &activeQuest.GroupID, &activeQuest.Lat, &activeQuest.Lng, &activeQuest.Radius, &activeQuest.HonourBased, &activeQuest.RequiresEvidence, &activeQuest.GameID, &activeQuest.CreatedAt, &activeQuest.Complete, &activeQuest.Submitted, &activeQuest.StartedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -300,14 +309,32 @@ func (r *PostgresQuestRepository) CreateManyActive(ctx context.Context, active [
 	return activeQuests, nil
 }
 
-func (r *PostgresQuestRepository) Complete(ctx context.Context, id string) error {
-	query := `UPDATE active_quests SET complete = true WHERE id = $1`
-	_, err := r.db.Exec(ctx, query, id)
+// Returns ErrQuestNotActive if the quest was already completed,
+// so a quest can't be paid out twice
+func (r *PostgresQuestRepository) Complete(ctx context.Context, id string, reward *domain.TeamTransactionCreate) error {
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
 
-	return nil
+	query := `UPDATE active_quests SET complete = true WHERE id = $1 AND complete = false`
+	tag, err := tx.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrQuestNotActive
+	}
+
+	if reward != nil {
+		if _, err := applyTransaction(ctx, tx, reward); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
 }
 
 func (r *PostgresQuestRepository) DeleteActive(ctx context.Context, id string) error {
@@ -322,7 +349,7 @@ func (r *PostgresQuestRepository) DeleteActive(ctx context.Context, id string) e
 
 func (r *PostgresQuestRepository) GenerateMainQuests(ctx context.Context, gameID string) error {
 	query := `
-	SELECT q.id, q.title, q.description, q.money, q.xp, q.quest_type, q.group_id, q.lat, q.lng, q.game_id, q.created_at
+	SELECT q.id, q.title, q.description, q.money, q.xp, q.quest_type, q.group_id, q.lat, q.lng, q.radius, q.honour_based, q.requires_evidence, q.game_id, q.created_at
 	FROM quests q
 	WHERE q.game_id = $1 AND q.quest_type = 'main'
 	`
@@ -335,7 +362,7 @@ func (r *PostgresQuestRepository) GenerateMainQuests(ctx context.Context, gameID
 
 	for rows.Next() {
 		quest := &domain.Quest{}
-		err = rows.Scan(&quest.ID, &quest.Title, &quest.Description, &quest.Money, &quest.XP, &quest.QuestType, &quest.GroupID, &quest.Lat, &quest.Lng, &quest.GameID, &quest.CreatedAt)
+		err = rows.Scan(&quest.ID, &quest.Title, &quest.Description, &quest.Money, &quest.XP, &quest.QuestType, &quest.GroupID, &quest.Lat, &quest.Lng, &quest.Radius, &quest.HonourBased, &quest.RequiresEvidence, &quest.GameID, &quest.CreatedAt)
 		if err != nil {
 			return err
 		}
@@ -433,7 +460,7 @@ func (r *PostgresQuestRepository) GenerateSideQuest(ctx context.Context, teamID
 	}
 
 	query := `
-	SELECT q.id, q.title, q.description, q.money, q.xp, q.quest_type, q.group_id, q.lat, q.lng, q.game_id, q.created_at
+	SELECT q.id, q.title, q.description, q.money, q.xp, q.quest_type, q.group_id, q.lat, q.lng, q.radius, q.honour_based, q.requires_evidence, q.game_id, q.created_at
 	FROM quests q
 	WHERE q.game_id = $1 AND q.quest_type = 'side'
 	`
@@ -446,7 +473,7 @@ func (r *PostgresQuestRepository) GenerateSideQuest(ctx context.Context, teamID
 
 	for rows.Next() {
 		quest := &domain.Quest{}
-		err = rows.Scan(&quest.ID, &quest.Title, &quest.Description, &quest.Money, &quest.XP, &quest.QuestType, &quest.GroupID, &quest.Lat, &quest.Lng, &quest.GameID, &quest.CreatedAt)
+		err = rows.Scan(&quest.ID, &quest.Title, &quest.Description, &quest.Money, &quest.XP, &quest.QuestType, &quest.GroupID, &quest.Lat, &quest.Lng, &quest.Radius, &quest.HonourBased, &quest.RequiresEvidence, &quest.GameID, &quest.CreatedAt)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/peonii/inertia/internal/domain"
+)
+
+var ErrSubmissionNotPending = errors.New("submission has already been reviewed")
+
+type QuestSubmissionRepository interface {
+	FindOne(ctx context.Context, id string) (*domain.QuestSubmission, error)
+	FindByGameID(ctx context.Context, gameID string) ([]*domain.QuestSubmission, error)
+
+	// Marks the active quest as submitted and records the submission.
+	// Returns ErrQuestNotActive if the quest is already complete or
+	// has another submission waiting for review.
+	Create(ctx context.Context, submission *domain.QuestSubmissionCreate) (*domain.QuestSubmission, error)
+
+	// Approving completes the active quest and pays the reward out,
+	// rejecting lets the team submit again. Returns
+	// ErrSubmissionNotPending if the submission was already reviewed.
+	Review(ctx context.Context, id, status, userID, note string, reward *domain.TeamTransactionCreate) (*domain.QuestSubmission, error)
+}
+
+type PostgresQuestSubmissionRepository struct {
+	db *pgxpool.Pool
+}
+
+func MakePostgresQuestSubmissionRepository(db *pgxpool.Pool) *PostgresQuestSubmissionRepository {
+	return &PostgresQuestSubmissionRepository{
+		db: db,
+	}
+}
+
+const submissionColumns = `
+	id, active_quest_id, team_id, user_id, blob_key, content_type, status, note, reviewed_by, reviewed_at, created_at
+`
+
+func scanSubmission(row pgx.Row) (*domain.QuestSubmission, error) {
+	var s domain.QuestSubmission
+	if err := row.Scan(
+		&s.ID,
+		&s.ActiveQuestID,
+		&s.TeamID,
+		&s.UserID,
+		&s.BlobKey,
+		&s.ContentType,
+		&s.Status,
+		&s.Note,
+		&s.ReviewedBy,
+		&s.ReviewedAt,
+		&s.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (r *PostgresQuestSubmissionRepository) FindOne(ctx context.Context, id string) (*domain.QuestSubmission, error) {
+	query := `SELECT ` + submissionColumns + ` FROM quest_submissions WHERE id = $1`
+
+	return scanSubmission(r.db.QueryRow(ctx, query, id))
+}
+
+func (r *PostgresQuestSubmissionRepository) FindByGameID(ctx context.Context, gameID string) ([]*domain.QuestSubmission, error) {
+	query := `
+		SELECT ` + submissionColumns + `
+		FROM quest_submissions
+		WHERE team_id IN (SELECT id FROM teams WHERE game_id = $1)
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(ctx, query, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	submissions := []*domain.QuestSubmission{}
+	for rows.Next() {
+		s, err := scanSubmission(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		submissions = append(submissions, s)
+	}
+
+	return submissions, rows.Err()
+}
+
+func (r *PostgresQuestSubmissionRepository) Create(ctx context.Context, submission *domain.QuestSubmissionCreate) (*domain.QuestSubmission, error) {
+	node, err := snowflake.NewNode(domain.QuestSubmissionSnowflakeNode)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE active_quests SET submitted = true
+		WHERE id = $1 AND complete = false AND submitted = false
+	`, submission.ActiveQuestID)
+	if err != nil {
+		return nil, err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return nil, ErrQuestNotActive
+	}
+
+	query := `
+		INSERT INTO quest_submissions (id, active_quest_id, team_id, user_id, blob_key, content_type)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + submissionColumns
+
+	s, err := scanSubmission(tx.QueryRow(ctx, query,
+		node.Generate().String(),
+		submission.ActiveQuestID,
+		submission.TeamID,
+		submission.UserID,
+		submission.BlobKey,
+		submission.ContentType,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (r *PostgresQuestSubmissionRepository) Review(ctx context.Context, id, status, userID, note string, reward *domain.TeamTransactionCreate) (*domain.QuestSubmission, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		UPDATE quest_submissions
+		SET status = $2, reviewed_by = $3, note = $4, reviewed_at = now()
+		WHERE id = $1 AND status = 'pending'
+		RETURNING ` + submissionColumns
+
+	s, err := scanSubmission(tx.QueryRow(ctx, query, id, status, userID, note))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSubmissionNotPending
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	complete := s.Status == domain.SubmissionStatusApproved
+	tag, err := tx.Exec(ctx, `
+		UPDATE active_quests SET submitted = false, complete = $2
+		WHERE id = $1 AND complete = false
+	`, s.ActiveQuestID, complete)
+	if err != nil {
+		return nil, err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return nil, ErrQuestNotActive
+	}
+
+	if complete && reward != nil {
+		if _, err := applyTransaction(ctx, tx, reward); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type RateLimitRepository interface {
+	// Counts a hit against the key, false once there were more
+	// than limit of them in the window
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, error)
+}
+
+type RedisRateLimitRepository struct {
+	RateLimitRepository
+	db *redis.Client
+}
+
+func MakeRedisRateLimitRepository(db *redis.Client) *RedisRateLimitRepository {
+	return &RedisRateLimitRepository{
+		db: db,
+	}
+}
+
+func (r *RedisRateLimitRepository) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, error) {
+	key = "ratelimit." + key
+
+	hits, err := r.db.Incr(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+
+	// The window starts at the first hit
+	if hits == 1 {
+		if err := r.db.Expire(ctx, key, window).Err(); err != nil {
+			return false, err
+		}
+	}
+
+	return hits <= int64(limit), nil
+}
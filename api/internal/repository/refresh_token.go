@@ -3,8 +3,12 @@ package repository
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/peonii/inertia/internal/domain"
@@ -12,9 +16,17 @@ import (
 	"github.com/vmihailenco/msgpack/v5"
 )
 
+var ErrRefreshTokenReused = errors.New("refresh token was already used")
+
 type RefreshTokenRepository interface {
-	CreateRefreshToken(ctx context.Context, userID string) (*domain.RefreshToken, error)
+	// Gives the session its first refresh token
+	CreateRefreshToken(ctx context.Context, session *domain.Session) (*domain.RefreshToken, error)
+	// A token that was swapped already has leaked, so the whole
+	// session is revoked and ErrRefreshTokenReused returned
 	FindRefreshTokenByToken(ctx context.Context, token string) (*domain.RefreshToken, error)
+	// Swaps the token for a new one in the same session, reuse is
+	// caught the same way as in FindRefreshTokenByToken
+	RotateRefreshToken(ctx context.Context, token string, userAgent string) (*domain.Session, *domain.RefreshToken, error)
 	DeleteRefreshTokenByToken(ctx context.Context, token string) error
 }
 
@@ -29,28 +41,63 @@ func MakeRedisRefreshTokenRepository(db *redis.Client) *RedisRefreshTokenReposit
 	}
 }
 
-func (r *RedisRefreshTokenRepository) CreateRefreshToken(ctx context.Context, userID string) (*domain.RefreshToken, error) {
+func newRefreshToken(session *domain.Session) (*domain.RefreshToken, error) {
 	tok := make([]byte, domain.RefreshTokenLength)
 	if _, err := rand.Read(tok); err != nil {
 		return nil, err
 	}
 
-	token := fmt.Sprintf("s.%s", base64.URLEncoding.EncodeToString(tok))
-
-	s := &domain.RefreshToken{
-		UserID: userID,
-		Token:  token,
+	return &domain.RefreshToken{
+		UserID:    session.UserID,
+		SessionID: session.ID,
+		Token:     fmt.Sprintf("s.%s.%s", session.ID, base64.URLEncoding.EncodeToString(tok)),
 
 		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(domain.RefreshTokenExpiryTime),
+		ExpiresAt: session.ExpiresAt,
+	}, nil
+}
+
+// Tokens carry their session's ID, so one that was swapped out
+// can still be traced back to it. Older ones don't, and get it
+// the next time they're rotated.
+func refreshTokenSessionID(token string) string {
+	parts := strings.SplitN(token, ".", 3)
+	if len(parts) != 3 || parts[0] != "s" {
+		return ""
 	}
 
+	return parts[1]
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Hands the session a new refresh token, the caller runs
+// it in a transaction
+func setRefreshToken(ctx context.Context, db redis.Cmdable, session *domain.Session, s *domain.RefreshToken) error {
 	data, err := msgpack.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	db.Set(ctx, s.Token, data, time.Until(s.ExpiresAt))
+
+	session.Token = s.Token
+	return saveSession(ctx, db, session)
+}
+
+func (r *RedisRefreshTokenRepository) CreateRefreshToken(ctx context.Context, session *domain.Session) (*domain.RefreshToken, error) {
+	s, err := newRefreshToken(session)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := r.db.Set(ctx, token, data, domain.RefreshTokenExpiryTime).Err(); err != nil {
+	_, err = r.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		return setRefreshToken(ctx, pipe, session, s)
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -59,6 +106,11 @@ func (r *RedisRefreshTokenRepository) CreateRefreshToken(ctx context.Context, us
 
 func (r *RedisRefreshTokenRepository) FindRefreshTokenByToken(ctx context.Context, token string) (*domain.RefreshToken, error) {
 	data, err := r.db.Get(ctx, token).Bytes()
+	if errors.Is(err, redis.Nil) {
+		if err := r.checkReuse(ctx, token); err != nil {
+			return nil, err
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -71,6 +123,110 @@ func (r *RedisRefreshTokenRepository) FindRefreshTokenByToken(ctx context.Contex
 	return &s, nil
 }
 
+// The token isn't around anymore, if its session swapped it
+// out then it's being reused and the session goes
+func (r *RedisRefreshTokenRepository) checkReuse(ctx context.Context, token string) error {
+	sid := refreshTokenSessionID(token)
+	if sid == "" {
+		return nil
+	}
+
+	rotated, err := r.db.SIsMember(ctx, rotatedTokensKey(sid), hashRefreshToken(token)).Result()
+	if err != nil || !rotated {
+		return err
+	}
+
+	s, err := getSession(ctx, r.db, sid)
+	if errors.Is(err, redis.Nil) {
+		return ErrRefreshTokenReused
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleteSession(ctx, pipe, s)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return ErrRefreshTokenReused
+}
+
+func (r *RedisRefreshTokenRepository) RotateRefreshToken(ctx context.Context, token string, userAgent string) (*domain.Session, *domain.RefreshToken, error) {
+	old, err := r.FindRefreshTokenByToken(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		session *domain.Session
+		next    *domain.RefreshToken
+	)
+
+	rotate := func(tx *redis.Tx) error {
+		s, err := getSession(ctx, tx, old.SessionID)
+		if err != nil {
+			return err
+		}
+
+		if s.Token != token {
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				deleteSession(ctx, pipe, s)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			return ErrRefreshTokenReused
+		}
+
+		now := time.Now()
+		s.LastUsedAt = now
+		s.ExpiresAt = now.Add(domain.RefreshTokenExpiryTime)
+		if userAgent != "" {
+			s.UserAgent = userAgent
+		}
+
+		t, err := newRefreshToken(s)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			// Only its hash is kept, to catch it if it's used again
+			pipe.Del(ctx, token)
+			pipe.SAdd(ctx, rotatedTokensKey(s.ID), hashRefreshToken(token))
+			pipe.ExpireAt(ctx, rotatedTokensKey(s.ID), s.ExpiresAt)
+			return setRefreshToken(ctx, pipe, s, t)
+		})
+		if err != nil {
+			return err
+		}
+
+		session = s
+		next = t
+		return nil
+	}
+
+	// Someone else rotating the same token at the same time is
+	// reuse too, which the retry sees and revokes the session for
+	for attempt := 0; attempt < 3; attempt++ {
+		err = r.db.Watch(ctx, rotate, sessionKey(old.SessionID))
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return session, next, nil
+}
+
 func (r *RedisRefreshTokenRepository) DeleteRefreshTokenByToken(ctx context.Context, token string) error {
 	return r.db.Del(ctx, token).Err()
 }
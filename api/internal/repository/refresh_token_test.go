@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/peonii/inertia/internal/domain"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	m := miniredis.RunT(t)
+	db := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { db.Close() })
+
+	return m, db
+}
+
+func newTestSession(t *testing.T, db *redis.Client) (*domain.Session, *domain.RefreshToken) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	s, err := MakeRedisSessionRepository(db).Create(ctx, &domain.SessionCreate{
+		UserID:   "user",
+		ClientID: "client",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := MakeRedisRefreshTokenRepository(db).CreateRefreshToken(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, rt
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	_, db := newTestRedis(t)
+	ctx := context.Background()
+	repo := MakeRedisRefreshTokenRepository(db)
+
+	s, rt := newTestSession(t, db)
+
+	session, next, err := repo.RotateRefreshToken(ctx, rt.Token, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.ID != s.ID || next.SessionID != s.ID {
+		t.Fatalf("rotated into session %q, token for %q, expected %q", session.ID, next.SessionID, s.ID)
+	}
+
+	if next.Token == rt.Token {
+		t.Fatal("rotation handed back the same token")
+	}
+
+	if _, err := repo.FindRefreshTokenByToken(ctx, next.Token); err != nil {
+		t.Fatalf("new token doesn't work: %v", err)
+	}
+}
+
+// The old token is gone after rotating, using it again has to
+// end the session no matter how long after that happens
+func TestRefreshTokenReuse(t *testing.T) {
+	tests := []struct {
+		name string
+		// How long after the rotation the old token shows up
+		after time.Duration
+		use   func(ctx context.Context, repo *RedisRefreshTokenRepository, token string) error
+	}{
+		{"find", 0, func(ctx context.Context, repo *RedisRefreshTokenRepository, token string) error {
+			_, err := repo.FindRefreshTokenByToken(ctx, token)
+			return err
+		}},
+		{"rotate", 0, func(ctx context.Context, repo *RedisRefreshTokenRepository, token string) error {
+			_, _, err := repo.RotateRefreshToken(ctx, token, "")
+			return err
+		}},
+		{"months later", time.Hour * 24 * 200, func(ctx context.Context, repo *RedisRefreshTokenRepository, token string) error {
+			_, _, err := repo.RotateRefreshToken(ctx, token, "")
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, db := newTestRedis(t)
+			ctx := context.Background()
+			repo := MakeRedisRefreshTokenRepository(db)
+
+			s, rt := newTestSession(t, db)
+
+			_, next, err := repo.RotateRefreshToken(ctx, rt.Token, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m.FastForward(tt.after)
+
+			if err := tt.use(ctx, repo, rt.Token); !errors.Is(err, ErrRefreshTokenReused) {
+				t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+			}
+
+			if _, err := MakeRedisSessionRepository(db).FindOne(ctx, s.ID); !errors.Is(err, redis.Nil) {
+				t.Fatalf("expected the session to be revoked, got %v", err)
+			}
+
+			if _, err := repo.FindRefreshTokenByToken(ctx, next.Token); !errors.Is(err, redis.Nil) {
+				t.Fatalf("expected the current token to be revoked, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenUnknown(t *testing.T) {
+	_, db := newTestRedis(t)
+	ctx := context.Background()
+	repo := MakeRedisRefreshTokenRepository(db)
+
+	s, _ := newTestSession(t, db)
+
+	// Made up for a real session, the session stays
+	if _, err := repo.FindRefreshTokenByToken(ctx, "s."+s.ID+".made-up"); !errors.Is(err, redis.Nil) {
+		t.Fatalf("expected redis.Nil, got %v", err)
+	}
+
+	if _, err := MakeRedisSessionRepository(db).FindOne(ctx, s.ID); err != nil {
+		t.Fatalf("expected the session to stay, got %v", err)
+	}
+}
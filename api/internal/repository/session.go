@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/peonii/inertia/internal/domain"
+	"github.com/redis/go-redis/v9"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type SessionRepository interface {
+	// The session doesn't have a refresh token until
+	// one is created for it
+	Create(ctx context.Context, session *domain.SessionCreate) (*domain.Session, error)
+	FindOne(ctx context.Context, id string) (*domain.Session, error)
+	// Most recently used first
+	FindByUserID(ctx context.Context, userID string) ([]*domain.Session, error)
+	// Revokes the session's refresh token and access tokens
+	Delete(ctx context.Context, id string) error
+}
+
+type RedisSessionRepository struct {
+	db   *redis.Client
+	node *snowflake.Node
+}
+
+func MakeRedisSessionRepository(db *redis.Client) *RedisSessionRepository {
+	node, err := snowflake.NewNode(domain.SessionSnowflakeNode)
+	if err != nil {
+		panic(err)
+	}
+
+	return &RedisSessionRepository{
+		db:   db,
+		node: node,
+	}
+}
+
+func sessionKey(id string) string {
+	return "sess." + id
+}
+
+// Hashes of every refresh token the session has swapped out,
+// kept for as long as the session is around
+func rotatedTokensKey(id string) string {
+	return "sess." + id + ".rotated"
+}
+
+// Set of a user's session IDs, sessions that expired
+// are cleaned up the next time it's read
+func userSessionsKey(userID string) string {
+	return "sessions." + userID
+}
+
+func getSession(ctx context.Context, db redis.Cmdable, id string) (*domain.Session, error) {
+	data, err := db.Get(ctx, sessionKey(id)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var s domain.Session
+	if err := msgpack.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func saveSession(ctx context.Context, db redis.Cmdable, s *domain.Session) error {
+	data, err := msgpack.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	db.Set(ctx, sessionKey(s.ID), data, time.Until(s.ExpiresAt))
+	db.SAdd(ctx, userSessionsKey(s.UserID), s.ID)
+	return nil
+}
+
+func deleteSession(ctx context.Context, db redis.Cmdable, s *domain.Session) {
+	db.Del(ctx, sessionKey(s.ID))
+	db.SRem(ctx, userSessionsKey(s.UserID), s.ID)
+	db.Del(ctx, rotatedTokensKey(s.ID))
+	if s.Token != "" {
+		db.Del(ctx, s.Token)
+	}
+}
+
+func (r *RedisSessionRepository) Create(ctx context.Context, create *domain.SessionCreate) (*domain.Session, error) {
+	now := time.Now()
+	s := &domain.Session{
+		ID:       r.node.Generate().String(),
+		UserID:   create.UserID,
+		ClientID: create.ClientID,
+		Scopes:   create.Scopes,
+		GameID:   create.GameID,
+
+		DeviceName: create.DeviceName,
+		UserAgent:  create.UserAgent,
+
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(domain.RefreshTokenExpiryTime),
+	}
+
+	_, err := r.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		return saveSession(ctx, pipe, s)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (r *RedisSessionRepository) FindOne(ctx context.Context, id string) (*domain.Session, error) {
+	return getSession(ctx, r.db, id)
+}
+
+func (r *RedisSessionRepository) FindByUserID(ctx context.Context, userID string) ([]*domain.Session, error) {
+	ids, err := r.db.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []*domain.Session{}
+	for _, id := range ids {
+		s, err := getSession(ctx, r.db, id)
+		if errors.Is(err, redis.Nil) {
+			r.db.SRem(ctx, userSessionsKey(userID), id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+func (r *RedisSessionRepository) Delete(ctx context.Context, id string) error {
+	s, err := getSession(ctx, r.db, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleteSession(ctx, pipe, s)
+		return nil
+	})
+	return err
+}
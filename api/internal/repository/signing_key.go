@@ -0,0 +1,266 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/peonii/inertia/internal/domain"
+)
+
+var ErrNoSigningKey = errors.New("no signing key")
+
+type SigningKeyRepository interface {
+	// Keys that haven't retired, newest first
+	FindActive(ctx context.Context) ([]*domain.SigningKey, error)
+	// Adds a key unless there's one of the algorithm newer than
+	// since. The older keys retire once their tokens have expired.
+	Rotate(ctx context.Context, algorithm string, since time.Time) (bool, error)
+	DeleteRetired(ctx context.Context) error
+}
+
+type PostgresSigningKeyRepository struct {
+	db   *pgxpool.Pool
+	node *snowflake.Node
+}
+
+func MakePostgresSigningKeyRepository(db *pgxpool.Pool) *PostgresSigningKeyRepository {
+	node, err := snowflake.NewNode(domain.SigningKeySnowflakeNode)
+	if err != nil {
+		panic(err)
+	}
+
+	return &PostgresSigningKeyRepository{
+		db:   db,
+		node: node,
+	}
+}
+
+// Every instance tries to rotate, the lock lets only one of them
+const signingKeyRotationLock = 7413
+
+const signingKeyColumns = `
+	id, algorithm, private_key, created_at, retires_at
+`
+
+func scanSigningKey(row pgx.Row) (*domain.SigningKey, error) {
+	var k domain.SigningKey
+	var der []byte
+	if err := row.Scan(
+		&k.ID,
+		&k.Algorithm,
+		&der,
+		&k.CreatedAt,
+		&k.RetiresAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := k.UnmarshalPrivateKey(der); err != nil {
+		return nil, err
+	}
+
+	return &k, nil
+}
+
+func (r *PostgresSigningKeyRepository) FindActive(ctx context.Context) ([]*domain.SigningKey, error) {
+	query := `
+		SELECT ` + signingKeyColumns + ` FROM signing_keys
+		WHERE retires_at IS NULL OR retires_at > now()
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*domain.SigningKey{}
+	for rows.Next() {
+		k, err := scanSigningKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+func (r *PostgresSigningKeyRepository) Rotate(ctx context.Context, algorithm string, since time.Time) (bool, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, signingKeyRotationLock); err != nil {
+		return false, err
+	}
+
+	var fresh bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM signing_keys
+			WHERE algorithm = $1 AND retires_at IS NULL AND created_at > $2
+		)
+	`
+	if err := tx.QueryRow(ctx, query, algorithm, since).Scan(&fresh); err != nil {
+		return false, err
+	}
+	if fresh {
+		return false, nil
+	}
+
+	key, err := domain.NewSigningKey(r.node.Generate().String(), algorithm)
+	if err != nil {
+		return false, err
+	}
+
+	der, err := key.MarshalPrivateKey()
+	if err != nil {
+		return false, err
+	}
+
+	// The new key takes over once it's been published,
+	// then the old ones' tokens need to expire
+	retiresAt := key.CreatedAt.Add(domain.SigningKeyPublishLead + domain.AccessTokenExpiryTime)
+	if _, err := tx.Exec(ctx, `
+		UPDATE signing_keys SET retires_at = $1
+		WHERE retires_at IS NULL
+	`, retiresAt); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO signing_keys (id, algorithm, private_key, created_at)
+		VALUES ($1, $2, $3, $4)
+	`, key.ID, key.Algorithm, der, key.CreatedAt); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+func (r *PostgresSigningKeyRepository) DeleteRetired(ctx context.Context) error {
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM signing_keys
+		WHERE retires_at <= now()
+	`)
+	return err
+}
+
+// The active signing keys, kept in memory and reloaded
+// every so often so all instances agree on them
+type SigningKeyRing struct {
+	repo      SigningKeyRepository
+	algorithm string
+	rotation  time.Duration
+
+	mu   sync.RWMutex
+	keys []*domain.SigningKey
+
+	// Set if the keys couldn't be reloaded
+	OnError func(err error)
+}
+
+const signingKeyRefreshInterval = time.Minute
+
+func MakeSigningKeyRing(repo SigningKeyRepository, algorithm string, rotation time.Duration) *SigningKeyRing {
+	return &SigningKeyRing{
+		repo:      repo,
+		algorithm: algorithm,
+		rotation:  rotation,
+	}
+}
+
+// Rotates the keys if it's time to and reloads them
+func (k *SigningKeyRing) Refresh(ctx context.Context) error {
+	// Made early enough to be published by the time it's due
+	since := time.Now().Add(-k.rotation + domain.SigningKeyPublishLead)
+	if _, err := k.repo.Rotate(ctx, k.algorithm, since); err != nil {
+		return err
+	}
+
+	if err := k.repo.DeleteRetired(ctx); err != nil {
+		return err
+	}
+
+	keys, err := k.repo.FindActive(ctx)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+
+	return nil
+}
+
+func (k *SigningKeyRing) Run(ctx context.Context) {
+	ticker := time.NewTicker(signingKeyRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.Refresh(ctx); err != nil && k.OnError != nil {
+				k.OnError(err)
+			}
+		}
+	}
+}
+
+// The newest key that's been published for long enough,
+// or the newest one if none has (the very first key)
+func (k *SigningKeyRing) Signing() (*domain.SigningKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if len(k.keys) == 0 {
+		return nil, ErrNoSigningKey
+	}
+
+	published := time.Now().Add(-domain.SigningKeyPublishLead)
+	for _, key := range k.keys {
+		if !key.CreatedAt.After(published) {
+			return key, nil
+		}
+	}
+
+	return k.keys[0], nil
+}
+
+func (k *SigningKeyRing) Find(id string) (*domain.SigningKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key, nil
+		}
+	}
+
+	return nil, ErrNoSigningKey
+}
+
+func (k *SigningKeyRing) JWKS() *domain.JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	jwks := &domain.JWKS{Keys: []domain.JWK{}}
+	for _, key := range k.keys {
+		jwks.Keys = append(jwks.Keys, key.JWK())
+	}
+
+	return jwks
+}
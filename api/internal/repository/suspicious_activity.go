@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/peonii/inertia/internal/domain"
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrSuspiciousActivityThrottled = errors.New("suspicious activity was logged recently")
+
+type SuspiciousActivityRepository interface {
+	// Logs the activity, unless the same kind was logged for the
+	// user within the cooldown, then ErrSuspiciousActivityThrottled
+	Create(ctx context.Context, activity *domain.SuspiciousActivityCreate) (*domain.SuspiciousActivity, error)
+	// Newest first, userID can be empty for everyone in the game
+	FindByGameID(ctx context.Context, gameID, userID string) ([]*domain.SuspiciousActivity, error)
+}
+
+type PostgresSuspiciousActivityRepository struct {
+	db  *pgxpool.Pool
+	rdc *redis.Client
+}
+
+func MakePostgresSuspiciousActivityRepository(db *pgxpool.Pool, rdc *redis.Client) *PostgresSuspiciousActivityRepository {
+	return &PostgresSuspiciousActivityRepository{
+		db:  db,
+		rdc: rdc,
+	}
+}
+
+const suspiciousActivityColumns = `
+	id, game_id, user_id, kind, detail, lat, lng, created_at
+`
+
+func scanSuspiciousActivity(row pgx.Row) (*domain.SuspiciousActivity, error) {
+	var a domain.SuspiciousActivity
+	if err := row.Scan(
+		&a.ID,
+		&a.GameID,
+		&a.UserID,
+		&a.Kind,
+		&a.Detail,
+		&a.Lat,
+		&a.Lng,
+		&a.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+func (r *PostgresSuspiciousActivityRepository) Create(ctx context.Context, activity *domain.SuspiciousActivityCreate) (*domain.SuspiciousActivity, error) {
+	key := "suspicious:" + activity.GameID + ":" + activity.UserID + ":" + activity.Kind
+	fresh, err := r.rdc.SetNX(ctx, key, 1, domain.SuspiciousActivityCooldown).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, ErrSuspiciousActivityThrottled
+	}
+
+	node, err := snowflake.NewNode(domain.SuspiciousActivitySnowflakeNode)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO suspicious_activity (id, game_id, user_id, kind, detail, lat, lng)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + suspiciousActivityColumns
+
+	return scanSuspiciousActivity(r.db.QueryRow(ctx, query,
+		node.Generate().String(),
+		activity.GameID,
+		activity.UserID,
+		activity.Kind,
+		activity.Detail,
+		activity.Lat,
+		activity.Lng,
+	))
+}
+
+func (r *PostgresSuspiciousActivityRepository) FindByGameID(ctx context.Context, gameID, userID string) ([]*domain.SuspiciousActivity, error) {
+	query := `
+		SELECT ` + suspiciousActivityColumns + ` FROM suspicious_activity
+		WHERE game_id = $1 AND ($2::text = '' OR user_id = $2::text)
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(ctx, query, gameID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activity := []*domain.SuspiciousActivity{}
+	for rows.Next() {
+		a, err := scanSuspiciousActivity(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		activity = append(activity, a)
+	}
+
+	return activity, rows.Err()
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/peonii/inertia/internal/domain"
+)
+
+var ErrInsufficientBalance = errors.New("team does not have enough balance")
+
+type TeamTransactionRepository interface {
+	FindByTeamID(ctx context.Context, teamID string) ([]*domain.TeamTransaction, error)
+
+	// Changes the team's balance/XP and records the change in the ledger
+	// in one transaction. Returns ErrInsufficientBalance if the balance
+	// would go below zero.
+	Apply(ctx context.Context, transaction *domain.TeamTransactionCreate) (*domain.TeamTransaction, error)
+}
+
+type PostgresTeamTransactionRepository struct {
+	db *pgxpool.Pool
+}
+
+func MakePostgresTeamTransactionRepository(db *pgxpool.Pool) *PostgresTeamTransactionRepository {
+	return &PostgresTeamTransactionRepository{
+		db: db,
+	}
+}
+
+func (r *PostgresTeamTransactionRepository) FindByTeamID(ctx context.Context, teamID string) ([]*domain.TeamTransaction, error) {
+	query := `
+		SELECT
+			id, team_id, kind, amount, xp, balance_after, reference, note, user_id, created_at
+		FROM team_transactions
+		WHERE team_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(ctx, query, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []*domain.TeamTransaction{}
+	for rows.Next() {
+		var t domain.TeamTransaction
+		if err := rows.Scan(
+			&t.ID,
+			&t.TeamID,
+			&t.Kind,
+			&t.Amount,
+			&t.XP,
+			&t.BalanceAfter,
+			&t.Reference,
+			&t.Note,
+			&t.UserID,
+			&t.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		transactions = append(transactions, &t)
+	}
+
+	return transactions, nil
+}
+
+func (r *PostgresTeamTransactionRepository) Apply(ctx context.Context, transaction *domain.TeamTransactionCreate) (*domain.TeamTransaction, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	t, err := applyTransaction(ctx, tx, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Apply inside someone else's transaction, for payments that
+// have to go through together with whatever they're paying for
+func applyTransaction(ctx context.Context, tx pgx.Tx, transaction *domain.TeamTransactionCreate) (*domain.TeamTransaction, error) {
+	node, err := snowflake.NewNode(domain.TeamTransactionSnowflakeNode)
+	if err != nil {
+		return nil, err
+	}
+
+	// The balance check happens in the same statement as the update,
+	// so concurrent purchases can't overdraw the team
+	updateQuery := `
+		UPDATE teams
+		SET balance = balance + $2, xp = xp + $3
+		WHERE id = $1 AND balance + $2 >= 0
+		RETURNING balance
+	`
+
+	var balance int
+	err = tx.QueryRow(ctx, updateQuery, transaction.TeamID, transaction.Amount, transaction.XP).Scan(&balance)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var exists bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM teams WHERE id = $1)`, transaction.TeamID).Scan(&exists); err != nil {
+			return nil, err
+		}
+
+		if exists {
+			return nil, ErrInsufficientBalance
+		}
+
+		return nil, pgx.ErrNoRows
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	insertQuery := `
+		INSERT INTO team_transactions (id, team_id, kind, amount, xp, balance_after, reference, note, user_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, team_id, kind, amount, xp, balance_after, reference, note, user_id, created_at
+	`
+
+	var t domain.TeamTransaction
+	if err := tx.QueryRow(ctx, insertQuery,
+		node.Generate().String(),
+		transaction.TeamID,
+		transaction.Kind,
+		transaction.Amount,
+		transaction.XP,
+		balance,
+		transaction.Reference,
+		transaction.Note,
+		transaction.UserID,
+	).Scan(
+		&t.ID,
+		&t.TeamID,
+		&t.Kind,
+		&t.Amount,
+		&t.XP,
+		&t.BalanceAfter,
+		&t.Reference,
+		&t.Note,
+		&t.UserID,
+		&t.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
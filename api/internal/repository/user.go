@@ -16,6 +16,10 @@ type UserRepository interface {
 	Update(ctx context.Context, user *domain.User) (*domain.User, error)
 
 	Delete(ctx context.Context, id string) error
+	// Moves everything from one user to the other, then
+	// deletes the first one. Used when a guest links a
+	// provider that already has a user.
+	Merge(ctx context.Context, fromID string, intoID string) error
 }
 
 type PostgresUserRepository struct {
@@ -161,3 +165,104 @@ func (r *PostgresUserRepository) Delete(ctx context.Context, id string) error {
 
 	return nil
 }
+
+func (r *PostgresUserRepository) Merge(ctx context.Context, fromID string, intoID string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// A user can only be in one team per game, so teams in
+	// games the other user is already playing are left
+	teamsQuery := `
+		INSERT INTO teams_users (team_id, user_id)
+		SELECT tu.team_id, $2
+		FROM teams_users tu
+		JOIN teams t ON t.id = tu.team_id
+		WHERE tu.user_id = $1 AND NOT EXISTS (
+			SELECT 1
+			FROM teams_users otu
+			JOIN teams ot ON ot.id = otu.team_id
+			WHERE otu.user_id = $2 AND ot.game_id = t.game_id
+		)
+	`
+
+	if _, err := tx.Exec(ctx, teamsQuery, fromID, intoID); err != nil {
+		return err
+	}
+
+	// Same for roles, the one the user already has wins
+	rolesQuery := `
+		INSERT INTO game_roles (game_id, user_id, role, created_at)
+		SELECT game_id, $2, role, created_at
+		FROM game_roles
+		WHERE user_id = $1
+		ON CONFLICT (game_id, user_id) DO NOTHING
+	`
+
+	if _, err := tx.Exec(ctx, rolesQuery, fromID, intoID); err != nil {
+		return err
+	}
+
+	statsQuery := `
+		UPDATE user_stats s
+		SET
+			xp = s.xp + f.xp,
+			wins = s.wins + f.wins,
+			losses = s.losses + f.losses,
+			draws = s.draws + f.draws,
+			games = s.games + f.games,
+			quests = s.quests + f.quests,
+			events = s.events + f.events,
+			powerups = s.powerups + f.powerups,
+			catches = s.catches + f.catches,
+			times_caught = s.times_caught + f.times_caught
+		FROM user_stats f
+		WHERE s.user_id = $2 AND f.user_id = $1
+	`
+
+	if _, err := tx.Exec(ctx, statsQuery, fromID, intoID); err != nil {
+		return err
+	}
+
+	// Everything else just changes hands
+	moveQueries := []string{
+		`UPDATE devices SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE live_activities SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE locations SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE team_transactions SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE catches SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE catches SET resolved_by = $2 WHERE resolved_by = $1`,
+		`UPDATE quest_submissions SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE quest_submissions SET reviewed_by = $2 WHERE reviewed_by = $1`,
+		`UPDATE suspicious_activity SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE games SET host_id = $2 WHERE host_id = $1`,
+		`UPDATE accounts SET user_id = $2 WHERE user_id = $1`,
+	}
+
+	for _, query := range moveQueries {
+		if _, err := tx.Exec(ctx, query, fromID, intoID); err != nil {
+			return err
+		}
+	}
+
+	// Teams, roles and stats were copied over above
+	cleanupQueries := []string{
+		`DELETE FROM teams_users WHERE user_id = $1`,
+		`DELETE FROM game_roles WHERE user_id = $1`,
+		`DELETE FROM user_stats WHERE user_id = $1`,
+	}
+
+	for _, query := range cleanupQueries {
+		if _, err := tx.Exec(ctx, query, fromID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM users WHERE id = $1`, fromID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
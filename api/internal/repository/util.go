@@ -1,10 +1,18 @@
 package repository
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+
+	"github.com/jackc/pgx/v5"
 )
 
+// Satisfied by both *pgxpool.Pool and pgx.Tx
+type pgxQuerier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
 func GatherFields(intf interface{}, init int, argsInit []interface{}) (string, []interface{}) {
 	fields := reflect.ValueOf(intf).Elem()
 	args := argsInit
@@ -0,0 +1,152 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/peonii/inertia/internal/domain"
+	"github.com/peonii/inertia/internal/repository"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Moves games between statuses once their
+// TimeStart/TimeEnd have passed
+type GameStatusWorker struct {
+	context.Context
+
+	logger *zap.Logger
+	rdc    *redis.Client
+	db     *pgxpool.Pool
+
+	interval time.Duration
+	done     chan struct{}
+
+	gameRepo       repository.GameRepository
+	gameResultRepo repository.GameResultRepository
+	gameEventRepo  repository.GameEventRepository
+}
+
+func NewGameStatusWorker(ctx context.Context, logger *zap.Logger, rdc *redis.Client, db *pgxpool.Pool, interval time.Duration) *GameStatusWorker {
+	return &GameStatusWorker{
+		Context:  ctx,
+		logger:   logger,
+		rdc:      rdc,
+		db:       db,
+		interval: interval,
+		done:     make(chan struct{}),
+		gameRepo: repository.MakePostgresGameRepository(db),
+
+		gameResultRepo: repository.MakePostgresGameResultRepository(db),
+		gameEventRepo:  repository.MakeRedisGameEventRepository(rdc),
+	}
+}
+
+func (gw *GameStatusWorker) Start() {
+	go func() {
+		ticker := time.NewTicker(gw.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-gw.done:
+				return
+			case <-gw.Done():
+				return
+			case <-ticker.C:
+				gw.tick()
+			}
+		}
+	}()
+}
+
+func (gw *GameStatusWorker) Stop() {
+	close(gw.done)
+}
+
+func (gw *GameStatusWorker) tick() {
+	starting, err := gw.gameRepo.FindDueToStart(gw)
+	if err != nil {
+		gw.logger.Error("failed to find games due to start", zap.Error(err))
+	}
+
+	for _, game := range starting {
+		gw.transition(game, domain.GameStatusRunning)
+	}
+
+	finishing, err := gw.gameRepo.FindDueToFinish(gw)
+	if err != nil {
+		gw.logger.Error("failed to find games due to finish", zap.Error(err))
+	}
+
+	for _, game := range finishing {
+		gw.transition(game, domain.GameStatusFinished)
+	}
+
+	// Settling when the game finished can fail, it's tried
+	// again here until it goes through
+	unsettled, err := gw.gameRepo.FindUnsettled(gw)
+	if err != nil {
+		gw.logger.Error("failed to find unsettled games", zap.Error(err))
+	}
+
+	for _, game := range unsettled {
+		gw.settle(game)
+	}
+}
+
+func (gw *GameStatusWorker) settle(game *domain.Game) {
+	if _, err := gw.gameResultRepo.Settle(gw, game); err != nil {
+		gw.logger.Error("failed to settle game",
+			zap.String("game_id", game.ID),
+			zap.Error(err),
+		)
+	}
+}
+
+func (gw *GameStatusWorker) transition(game *domain.Game, to string) {
+	if !game.CanTransition(to) {
+		gw.logger.Error("invalid scheduled transition",
+			zap.String("game_id", game.ID),
+			zap.String("from", game.Status),
+			zap.String("to", to),
+		)
+		return
+	}
+
+	updated, err := gw.gameRepo.UpdateStatus(gw, game.ID, game.Status, to)
+	if err != nil {
+		// Most likely someone else (the host, or another worker)
+		// moved the game in the meantime
+		gw.logger.Info("skipped scheduled transition",
+			zap.String("game_id", game.ID),
+			zap.Error(err),
+		)
+		return
+	}
+
+	gw.logger.Info("transitioned game",
+		zap.String("game_id", game.ID),
+		zap.String("from", game.Status),
+		zap.String("to", to),
+	)
+
+	if updated.Status == domain.GameStatusFinished {
+		gw.settle(updated)
+	}
+
+	payload, err := json.Marshal(domain.GameStatusEvent{
+		Game: updated,
+		From: game.Status,
+	})
+	if err != nil {
+		gw.logger.Error("failed to marshal status event", zap.Error(err))
+		return
+	}
+
+	if _, err := gw.gameEventRepo.Publish(gw, updated.ID, domain.GameEventStatus, payload); err != nil {
+		gw.logger.Error("failed to publish status event", zap.Error(err))
+	}
+}
@@ -0,0 +1,4 @@
+alter table games add column status varchar(16) not null default 'lobby';
+
+update games set status = 'finished' where time_end <= now();
+update games set status = 'running' where time_start <= now() and time_end > now();
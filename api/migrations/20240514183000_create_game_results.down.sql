@@ -0,0 +1,4 @@
+alter table games drop column settled_at;
+drop table game_results;
+drop table runner_periods;
+alter table games drop column ranking;
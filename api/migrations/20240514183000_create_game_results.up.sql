@@ -0,0 +1,39 @@
+alter table games add column ranking varchar(16) not null default 'xp';
+
+create table runner_periods(
+    id serial primary key,
+    team_id varchar(64) not null references teams(id),
+
+    started_at timestamptz not null default now(),
+    -- Null while the team is still running
+    ended_at timestamptz
+);
+
+-- Runners of games that haven't finished have been running since the start,
+-- there were no catches to swap them yet. Finished games aren't scored.
+insert into runner_periods (team_id, started_at)
+select t.id, g.time_start
+from teams t
+join games g on g.id = t.game_id
+where t.is_runner = true and g.status <> 'finished';
+
+create table game_results(
+    id varchar(64) primary key,
+    game_id varchar(64) not null references games(id),
+    team_id varchar(64) not null references teams(id),
+
+    place integer not null,
+    score bigint not null,
+    outcome varchar(16) not null,
+    ranking varchar(16) not null,
+
+    created_at timestamp not null default now(),
+
+    unique (game_id, team_id)
+);
+
+-- Games are settled once, games that finished before
+-- there were results never are
+alter table games add column settled_at timestamptz;
+
+update games set settled_at = now() where status = 'finished';
@@ -0,0 +1 @@
+drop table game_rules;
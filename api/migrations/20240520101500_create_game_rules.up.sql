@@ -0,0 +1,13 @@
+-- Games without a row here use the default rules
+create table game_rules(
+    game_id varchar(64) primary key references games(id),
+
+    starting_balance integer not null,
+    -- In seconds
+    veto_duration integer not null,
+
+    -- [{"type": "bus", "price": 20}, ...]
+    tickets jsonb not null,
+    -- {"hunt": {"cost": 200, "duration": 1200}, ...}
+    powerups jsonb not null
+);
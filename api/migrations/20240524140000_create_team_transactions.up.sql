@@ -0,0 +1,18 @@
+create table team_transactions(
+    id varchar(64) primary key,
+    team_id varchar(64) not null references teams(id),
+
+    -- quest_reward, ticket_purchase, powerup_purchase, host_adjustment, refund
+    kind varchar(32) not null,
+    amount integer not null,
+    xp integer not null default 0,
+    balance_after integer not null,
+
+    reference varchar(255) not null default '',
+    note text not null default '',
+    user_id varchar(64) not null references users(id),
+
+    created_at timestamp not null default now()
+);
+
+create index team_transactions_team_id on team_transactions(team_id, created_at);
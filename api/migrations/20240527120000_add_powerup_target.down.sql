@@ -0,0 +1 @@
+alter table powerups drop column target;
@@ -0,0 +1 @@
+alter table powerups add column target varchar(255) not null default '';
@@ -0,0 +1,4 @@
+drop table catches;
+
+alter table game_rules drop column catch_window;
+alter table game_rules drop column catch_distance;
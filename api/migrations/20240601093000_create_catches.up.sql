@@ -0,0 +1,22 @@
+alter table game_rules add column catch_distance integer not null default 50;
+alter table game_rules add column catch_window integer not null default 60;
+
+create table catches(
+    id varchar(64) primary key,
+    game_id varchar(64) not null references games(id),
+
+    catcher_id varchar(64) not null references teams(id),
+    runner_id varchar(64) not null references teams(id),
+    user_id varchar(64) not null references users(id),
+
+    -- pending, accepted, rejected
+    status varchar(32) not null,
+    -- In meters
+    distance double precision,
+
+    resolved_by varchar(64) references users(id),
+    resolved_at timestamptz,
+    created_at timestamptz not null default now()
+);
+
+create index catches_game_id on catches(game_id, created_at);
@@ -0,0 +1,2 @@
+alter table quests drop column honour_based;
+alter table quests drop column radius;
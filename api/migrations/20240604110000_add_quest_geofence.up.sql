@@ -0,0 +1,3 @@
+-- In meters, 0 means no geofence
+alter table quests add column radius double precision not null default 0;
+alter table quests add column honour_based boolean not null default false;
@@ -0,0 +1,4 @@
+drop table quest_submissions;
+
+alter table active_quests drop column submitted;
+alter table quests drop column requires_evidence;
@@ -0,0 +1,22 @@
+alter table quests add column requires_evidence boolean not null default false;
+alter table active_quests add column submitted boolean not null default false;
+
+create table quest_submissions(
+    id varchar(64) primary key,
+    active_quest_id varchar(64) not null references active_quests(id) on delete cascade,
+    team_id varchar(64) not null references teams(id),
+    user_id varchar(64) not null references users(id),
+
+    blob_key varchar(255) not null,
+    content_type varchar(255) not null,
+
+    -- pending, approved, rejected
+    status varchar(32) not null default 'pending',
+    note text not null default '',
+
+    reviewed_by varchar(64) references users(id),
+    reviewed_at timestamptz,
+    created_at timestamptz not null default now()
+);
+
+create index quest_submissions_team_id on quest_submissions(team_id, created_at);
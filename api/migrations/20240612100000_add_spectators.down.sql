@@ -0,0 +1,3 @@
+alter table game_rules drop column spectator_delay;
+
+alter table game_invite drop column kind;
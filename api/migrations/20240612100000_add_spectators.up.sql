@@ -0,0 +1,4 @@
+-- player, spectator
+alter table game_invite add column kind varchar(32) not null default 'player';
+
+alter table game_rules add column spectator_delay integer not null default 0;
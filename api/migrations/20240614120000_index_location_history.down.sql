@@ -0,0 +1,2 @@
+drop index locations_user_id_created_at_idx;
+alter table locations alter column created_at type timestamp using created_at at time zone 'UTC';
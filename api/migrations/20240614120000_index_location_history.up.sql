@@ -0,0 +1,2 @@
+alter table locations alter column created_at type timestamptz using created_at at time zone 'UTC';
+create index locations_user_id_created_at_idx on locations(user_id, created_at);
@@ -0,0 +1 @@
+drop table suspicious_activity;
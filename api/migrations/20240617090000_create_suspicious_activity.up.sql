@@ -0,0 +1,15 @@
+create table suspicious_activity(
+    id varchar(64) primary key,
+    game_id varchar(64) not null references games(id) on delete cascade,
+    user_id varchar(64) not null references users(id),
+
+    -- invalid, imprecise, out_of_order, too_fast, out_of_area
+    kind varchar(32) not null,
+    detail text not null default '',
+    lat double precision not null,
+    lng double precision not null,
+
+    created_at timestamptz not null default now()
+);
+
+create index suspicious_activity_game_id on suspicious_activity(game_id, user_id, created_at);
@@ -0,0 +1,4 @@
+alter table game_rules drop column out_of_bounds_fine;
+alter table game_rules drop column out_of_bounds_penalty;
+
+alter table games drop column play_area;
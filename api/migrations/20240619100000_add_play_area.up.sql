@@ -0,0 +1,6 @@
+-- GeoJSON polygon, null means players can go anywhere
+alter table games add column play_area jsonb;
+
+-- none, fine, reveal
+alter table game_rules add column out_of_bounds_penalty varchar(32) not null default 'none';
+alter table game_rules add column out_of_bounds_fine integer not null default 0;
@@ -0,0 +1 @@
+alter table game_rules drop column reveal_interval;
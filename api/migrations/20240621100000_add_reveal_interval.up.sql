@@ -0,0 +1,2 @@
+-- In seconds, 0 means runners are tracked all the time
+alter table game_rules add column reveal_interval integer not null default 0;
@@ -0,0 +1 @@
+drop table oauth_clients;
@@ -0,0 +1,11 @@
+create table oauth_clients(
+    id varchar(64) primary key,
+    name varchar(255) not null,
+
+    redirect_uris text[] not null,
+    scopes text[] not null,
+    -- sha256 of the secret, null for public clients
+    secret_hash bytea,
+
+    created_at timestamptz not null default now()
+);
@@ -0,0 +1,11 @@
+create table signing_keys(
+    id varchar(64) primary key,
+    -- EdDSA or RS256
+    algorithm varchar(16) not null,
+    -- pkcs #8
+    private_key bytea not null,
+
+    created_at timestamptz not null default now(),
+    -- set once a newer key takes over
+    retires_at timestamptz
+);
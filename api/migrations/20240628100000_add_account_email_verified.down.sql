@@ -0,0 +1,7 @@
+drop index accounts_user_id;
+drop index accounts_email;
+
+alter table accounts alter column refresh_token type varchar(255);
+alter table accounts alter column access_token type varchar(255);
+
+alter table accounts drop column email_verified;
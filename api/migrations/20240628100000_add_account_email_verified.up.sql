@@ -0,0 +1,10 @@
+-- only verified emails are used to link a new login to an existing user,
+-- existing accounts get verified the next time they sign in
+alter table accounts add column email_verified boolean not null default false;
+
+-- other providers' tokens don't fit in 255
+alter table accounts alter column access_token type text;
+alter table accounts alter column refresh_token type text;
+
+create index accounts_email on accounts(email);
+create index accounts_user_id on accounts(user_id);
@@ -0,0 +1,15 @@
+-- roles handed out in a game, the host and players
+-- come from the game and its teams instead
+create table game_roles(
+    game_id varchar(64) not null references games(id) on delete cascade,
+    user_id varchar(64) not null references users(id),
+
+    -- referee, spectator
+    role varchar(16) not null,
+
+    created_at timestamptz not null default now(),
+
+    primary key (game_id, user_id)
+);
+
+create index game_roles_user_id on game_roles(user_id);